@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/totegamma/concurrent/x/account"
+	"github.com/totegamma/concurrent/x/migration"
+	"github.com/totegamma/concurrent/x/util"
+)
+
+const commandUsage = `usage: ccapi [command]
+
+commands:
+  (none)                 start api server
+  export <ccid> [file]   write signed archive of the entity to file (default: stdout)
+  import <file>          verify and restore signed archive
+  migrate [up [version]] apply pending migrations (default: all)
+  migrate down <version> revert migrations newer than version
+  migrate status         show applied and pending migrations
+`
+
+// runCommand runs the subcommand and returns exit code
+func runCommand(args []string) int {
+	config := util.Config{}
+	configPath := os.Getenv("CONCURRENT_CONFIG")
+	if configPath == "" {
+		configPath = "/etc/concurrent/config.yaml"
+	}
+	err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	db, err := gorm.Open(postgres.Open(config.Server.Dsn), &gorm.Config{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to connect database:", err)
+		return 1
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     config.Server.RedisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	defer rdb.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "export":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, commandUsage)
+			return 2
+		}
+		accountService := SetupAccountService(db, rdb, config)
+		signed, err := accountService.ExportSigned(ctx, args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to export:", err)
+			return 1
+		}
+		out, err := json.Marshal(signed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(args) < 3 {
+			fmt.Println(string(out))
+			return 0
+		}
+		err = ioutil.WriteFile(args[2], out, 0600)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintln(os.Stderr, "exported", args[1], "to", args[2])
+	case "import":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, commandUsage)
+			return 2
+		}
+		in, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		var signed account.SignedArchive
+		err = json.Unmarshal(in, &signed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid archive:", err)
+			return 1
+		}
+		accountService := SetupAccountService(db, rdb, config)
+		archive, err := accountService.ImportSigned(ctx, signed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to import:", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "imported %v exported from %v (signed by %v): %d messages, %d characters, %d associations, %d streams, %d collections\n",
+			archive.Entity.ID, archive.Domain, signed.Signer,
+			len(archive.Messages), len(archive.Characters), len(archive.Associations), len(archive.Streams), len(archive.Collections))
+	case "migrate":
+		return runMigrate(ctx, migration.NewService(db), args[1:])
+	default:
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+
+	return 0
+}
+
+func runMigrate(ctx context.Context, migrator migration.Service, args []string) int {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	target := 0
+	if len(args) > 1 {
+		var err error
+		target, err = strconv.Atoi(args[1])
+		if err != nil || target < 0 {
+			fmt.Fprintln(os.Stderr, "invalid version:", args[1])
+			return 2
+		}
+	}
+
+	switch command {
+	case "status":
+		current, err := migrator.Current(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, m := range migrator.Migrations() {
+			state := "pending"
+			if m.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, state)
+		}
+		fmt.Printf("database: %d, binary: %d\n", current, migrator.Latest())
+		if current > migrator.Latest() {
+			fmt.Fprintln(os.Stderr, migration.ErrSchemaTooNew)
+			return 1
+		}
+	case "up":
+		err := migrator.Up(ctx, target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to migrate:", err)
+			return 1
+		}
+	case "down":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, commandUsage)
+			return 2
+		}
+		err := migrator.Down(ctx, target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to migrate:", err)
+			return 1
+		}
+	default:
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+
+	current, err := migrator.Current(ctx)
+	if err == nil && command != "status" {
+		fmt.Fprintln(os.Stderr, "database is at version", current)
+	}
+	return 0
+}
@@ -17,7 +17,8 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 
 	"github.com/totegamma/concurrent/x/auth"
-	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/migration"
 	"github.com/totegamma/concurrent/x/util"
 
 	"github.com/redis/go-redis/extra/redisotel/v9"
@@ -34,6 +35,10 @@ import (
 
 func main() {
 
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	fmt.Print(concurrentBanner)
 
 	e := echo.New()
@@ -100,17 +105,11 @@ func main() {
 
 	// Migrate the schema
 	log.Println("start migrate")
-	db.AutoMigrate(
-		&core.Message{},
-		&core.Character{},
-		&core.Association{},
-		&core.Stream{},
-		&core.Domain{},
-		&core.Entity{},
-		&core.Collection{},
-		&core.CollectionItem{},
-		&core.Ack{},
-	)
+	migrator := migration.NewService(db)
+	err = migrator.Up(context.Background(), 0)
+	if err != nil {
+		log.Fatal("failed to migrate: ", err)
+	}
 
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     config.Server.RedisAddr,
@@ -132,34 +131,46 @@ func main() {
 
 	agent := SetupAgent(db, rdb, config)
 
-	socketHandler := SetupSocketHandler(rdb, config)
+	socketHandler := SetupSocketHandler(db, rdb, config)
 	messageHandler := SetupMessageHandler(db, rdb, config)
-	characterHandler := SetupCharacterHandler(db, config)
+	tombstoneHandler := SetupTombstoneHandler(db, config)
+	characterHandler := SetupCharacterHandler(db, rdb, config)
 	associationHandler := SetupAssociationHandler(db, rdb, config)
 	streamHandler := SetupStreamHandler(db, rdb, config)
+	timelineHandler := SetupTimelineHandler(db, rdb, config)
 	domainHandler := SetupDomainHandler(db, config)
 	entityHandler := SetupEntityHandler(db, rdb, config)
-	authHandler := SetupAuthHandler(db, config)
+	authHandler := SetupAuthHandler(db, rdb, config)
 	userkvHandler := SetupUserkvHandler(db, rdb, config)
 	collectionHandler := SetupCollectionHandler(db, rdb, config)
+	outboxHandler := SetupOutboxHandler(db, rdb, config)
+	keyHandler := SetupKeyHandler(db)
+	accountHandler := SetupAccountHandler(db, rdb, config)
 
-	authService := SetupAuthService(db, config)
+	authService := SetupAuthService(db, rdb, config)
 
 	apiV1 := e.Group("")
-	apiV1.GET("/message/:id", messageHandler.Get)
+	apiV1.GET("/message/:id", messageHandler.Get, authService.ParseJWT)
+	apiV1.GET("/tombstone/:id", tombstoneHandler.Get)
 	apiV1.GET("/characters", characterHandler.Get)
-	apiV1.GET("/association/:id", associationHandler.Get)
-	apiV1.GET("/stream/:id", streamHandler.Get)
-	apiV1.GET("/streams", streamHandler.List)
-	apiV1.GET("/streams/recent", streamHandler.Recent)
-	apiV1.GET("/streams/range", streamHandler.Range)
-	apiV1.GET("/socket", socketHandler.Connect)
+	apiV1.GET("/key/chain/:id", keyHandler.GetKeyChain)
+	apiV1.GET("/association/:id", associationHandler.Get, authService.ParseJWT)
+	apiV1.GET("/stream/:id", streamHandler.Get, authService.ParseJWT)
+	apiV1.GET("/streams", streamHandler.List, authService.ParseJWT)
+	apiV1.GET("/streams/recent", streamHandler.Recent, authService.ParseJWT)
+	apiV1.GET("/streams/range", streamHandler.Range, authService.ParseJWT)
+	apiV1.GET("/streams/page", streamHandler.Page, authService.ParseJWT)
+	apiV1.GET("/timeline", timelineHandler.Get, authService.ParseJWT)
+	apiV1.GET("/socket", socketHandler.Connect, authService.ParseJWT)
 	apiV1.GET("/domain", domainHandler.Profile)
 	apiV1.GET("/domain/:id", domainHandler.Get)
 	apiV1.GET("/domains", domainHandler.List)
 	apiV1.GET("/entity/:id", entityHandler.Get)
 	apiV1.GET("/entities", entityHandler.List)
+	apiV1.GET("/entity/:id/acking", entityHandler.Acking)
+	apiV1.GET("/entity/:id/acker", entityHandler.Acker)
 	apiV1.GET("/auth/claim", authHandler.Claim)
+	apiV1.POST("/auth/refresh", authHandler.Refresh)
 	apiV1.GET("/profile", func(c echo.Context) error {
 		profile := config.Profile
 		profile.Registration = config.Concurrent.Registration
@@ -169,11 +180,27 @@ func main() {
 		return c.JSON(http.StatusOK, profile)
 	})
 
-	apiV1R := apiV1.Group("", auth.JWT)
+	apiV1S := apiV1.Group("", authService.SignedRequest)
+	apiV1S.POST("/domains/hello", domainHandler.Hello, authService.Restrict(auth.ISUNUNITED))
+	apiV1S.GET("/entities/scrape", entityHandler.List, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/streams/checkpoint", streamHandler.Checkpoint, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/streams/retract", streamHandler.RetractCheckpoint, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/account/import", accountHandler.Import, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/account/moved", accountHandler.Moved, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/message/invalidate", messageHandler.Invalidate, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/association/invalidate", associationHandler.Invalidate, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/character/invalidate", characterHandler.Invalidate, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/association/forward", associationHandler.Forward, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/association/retract", associationHandler.Retract, authService.Restrict(auth.ISUNITED))
+	apiV1S.POST("/ack/forward", entityHandler.ForwardAck, authService.Restrict(auth.ISUNITED))
+
+	apiV1R := apiV1.Group("", authService.JWT)
 	apiV1R.PUT("/domain", domainHandler.Upsert, authService.Restrict(auth.ISADMIN))
 	apiV1R.DELETE("/domain/:id", domainHandler.Delete, authService.Restrict(auth.ISADMIN))
-	apiV1R.POST("/domains/hello", domainHandler.Hello, authService.Restrict(auth.ISUNUNITED))
 	apiV1R.GET("/admin/sayhello/:fqdn", domainHandler.SayHello, authService.Restrict(auth.ISADMIN))
+	apiV1R.GET("/admin/outbox", outboxHandler.List, authService.Restrict(auth.ISADMIN))
+	apiV1R.POST("/admin/outbox/:id/replay", outboxHandler.Replay, authService.Restrict(auth.ISADMIN))
+	apiV1R.DELETE("/admin/outbox/:id", outboxHandler.Delete, authService.Restrict(auth.ISADMIN))
 
 	apiV1R.POST("/entity", entityHandler.Register, authService.Restrict(auth.ISUNKNOWN))
 	apiV1R.DELETE("/entity/:id", entityHandler.Delete, authService.Restrict(auth.ISADMIN))
@@ -181,34 +208,47 @@ func main() {
     apiV1R.POST("/ack", entityHandler.Ack, authService.Restrict(auth.ISLOCAL))
     apiV1R.DELETE("/ack", entityHandler.Unack, authService.Restrict(auth.ISLOCAL))
 	apiV1R.POST("/admin/entity", entityHandler.Create, authService.Restrict(auth.ISADMIN))
+	apiV1R.POST("/admin/entity/:id/revoke", authHandler.RevokeEntity, authService.Restrict(auth.ISADMIN))
+	apiV1R.POST("/auth/revoke", authHandler.Revoke, authService.Restrict(auth.ISLOCAL))
 
-	apiV1R.POST("/message", messageHandler.Post, authService.Restrict(auth.ISLOCAL))
+	apiV1R.POST("/message", messageHandler.Post, authService.Restrict(auth.ISLOCAL, key.ScopeMessagePost))
+	apiV1R.PUT("/message/:id", messageHandler.Update, authService.Restrict(auth.ISLOCAL, key.ScopeMessagePost))
 	apiV1R.DELETE("/message/:id", messageHandler.Delete, authService.Restrict(auth.ISLOCAL))
 
-	apiV1R.PUT("/character", characterHandler.Put, authService.Restrict(auth.ISLOCAL))
+	apiV1R.PUT("/character", characterHandler.Put, authService.Restrict(auth.ISLOCAL, key.ScopeCharacterPut))
 
 	apiV1R.POST("/association", associationHandler.Post, authService.Restrict(auth.ISKNOWN))
 	apiV1R.DELETE("/association/:id", associationHandler.Delete, authService.Restrict(auth.ISKNOWN))
 
 	apiV1R.POST("/stream", streamHandler.Create, authService.Restrict(auth.ISLOCAL))
 	apiV1R.PUT("/stream/:id", streamHandler.Update, authService.Restrict(auth.ISLOCAL))
-	apiV1R.POST("/streams/checkpoint", streamHandler.Checkpoint, authService.Restrict(auth.ISUNITED))
 	apiV1R.DELETE("/stream/:id", streamHandler.Delete, authService.Restrict(auth.ISLOCAL))
+	apiV1R.POST("/stream/:id/transfer", streamHandler.Transfer, authService.Restrict(auth.ISLOCAL))
 	apiV1R.DELETE("/stream/:stream/:element", streamHandler.Remove, authService.Restrict(auth.ISLOCAL))
 	apiV1.GET("/streams/mine", streamHandler.ListMine)
 
-	apiV1R.GET("/kv/:key", userkvHandler.Get, authService.Restrict(auth.ISLOCAL))
-	apiV1R.PUT("/kv/:key", userkvHandler.Upsert, authService.Restrict(auth.ISLOCAL))
+	apiV1R.GET("/kv/:key", userkvHandler.Get, authService.Restrict(auth.ISLOCAL, key.ScopeKVRead))
+	apiV1R.PUT("/kv/:key", userkvHandler.Upsert, authService.Restrict(auth.ISLOCAL, key.ScopeKVWrite))
+
+	apiV1R.POST("/key/grant", keyHandler.Grant, authService.Restrict(auth.ISLOCAL))
+	apiV1R.GET("/key/grants", keyHandler.ListGrants, authService.Restrict(auth.ISLOCAL))
+	apiV1R.DELETE("/key/grant/:id", keyHandler.Revoke, authService.Restrict(auth.ISLOCAL))
+	apiV1R.POST("/key/chain", keyHandler.AppendKeyChain, authService.Restrict(auth.ISLOCAL))
+
+	apiV1R.GET("/account/export", accountHandler.Export, authService.Restrict(auth.ISLOCAL))
+	apiV1R.POST("/account/move", accountHandler.Move, authService.Restrict(auth.ISLOCAL))
 
-	apiV1R.POST("/collection", collectionHandler.CreateCollection, authService.Restrict(auth.ISLOCAL))
-	apiV1R.GET("/collection/:id", collectionHandler.GetCollection)
-	apiV1R.PUT("/collection/:id", collectionHandler.UpdateCollection, authService.Restrict(auth.ISLOCAL))
-	apiV1R.DELETE("/collection/:id", collectionHandler.DeleteCollection, authService.Restrict(auth.ISLOCAL))
+	apiV1.GET("/collections", collectionHandler.ListCollections, authService.ParseJWT)
+	apiV1.GET("/collection/:id", collectionHandler.GetCollection, authService.ParseJWT)
+	apiV1.GET("/collection/:collection/:item", collectionHandler.GetItem, authService.ParseJWT)
+	apiV1R.POST("/collection", collectionHandler.CreateCollection, authService.Restrict(auth.ISLOCAL, key.ScopeCollectionWrite))
+	apiV1R.PUT("/collection/:id", collectionHandler.UpdateCollection, authService.Restrict(auth.ISLOCAL, key.ScopeCollectionWrite))
+	apiV1R.DELETE("/collection/:id", collectionHandler.DeleteCollection, authService.Restrict(auth.ISLOCAL, key.ScopeCollectionWrite))
+	apiV1R.POST("/collection/:id/transfer", collectionHandler.TransferCollection, authService.Restrict(auth.ISLOCAL, key.ScopeCollectionWrite))
 
-	apiV1R.POST("/collection/:collection", collectionHandler.CreateItem, authService.Restrict(auth.ISLOCAL))
-	apiV1R.GET("/collection/:collection/:item", collectionHandler.GetItem)
-	apiV1R.PUT("/collection/:collection/:item", collectionHandler.UpdateItem, authService.Restrict(auth.ISLOCAL))
-	apiV1R.DELETE("/collection/:collection/:item", collectionHandler.DeleteItem, authService.Restrict(auth.ISLOCAL))
+	apiV1R.POST("/collection/:collection", collectionHandler.CreateItem, authService.Restrict(auth.ISLOCAL, key.ScopeCollectionWrite))
+	apiV1R.PUT("/collection/:collection/:item", collectionHandler.UpdateItem, authService.Restrict(auth.ISLOCAL, key.ScopeCollectionWrite))
+	apiV1R.DELETE("/collection/:collection/:item", collectionHandler.DeleteItem, authService.Restrict(auth.ISLOCAL, key.ScopeCollectionWrite))
 
 	e.GET("/health", func(c echo.Context) (err error) {
 		ctx := c.Request().Context()
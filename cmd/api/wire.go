@@ -7,6 +7,7 @@ import (
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 
+	"github.com/totegamma/concurrent/x/account"
 	"github.com/totegamma/concurrent/x/agent"
 	"github.com/totegamma/concurrent/x/association"
 	"github.com/totegamma/concurrent/x/auth"
@@ -14,34 +15,48 @@ import (
 	"github.com/totegamma/concurrent/x/collection"
 	"github.com/totegamma/concurrent/x/domain"
 	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
 	"github.com/totegamma/concurrent/x/message"
+	"github.com/totegamma/concurrent/x/outbox"
 	"github.com/totegamma/concurrent/x/socket"
 	"github.com/totegamma/concurrent/x/stream"
+	"github.com/totegamma/concurrent/x/timeline"
+	"github.com/totegamma/concurrent/x/tombstone"
 	"github.com/totegamma/concurrent/x/userkv"
 	"github.com/totegamma/concurrent/x/util"
 )
 
 var domainHandlerProvider = wire.NewSet(domain.NewHandler, domain.NewService, domain.NewRepository)
-var entityHandlerProvider = wire.NewSet(entity.NewHandler, entity.NewService, entity.NewRepository)
-var streamHandlerProvider = wire.NewSet(stream.NewHandler, stream.NewService, stream.NewRepository, entity.NewService, entity.NewRepository)
-var messageHandlerProvider = wire.NewSet(message.NewHandler, message.NewService, message.NewRepository)
-var characterHandlerProvider = wire.NewSet(character.NewHandler, character.NewService, character.NewRepository)
-var associationHandlerProvider = wire.NewSet(association.NewHandler, association.NewService, association.NewRepository, message.NewService, message.NewRepository)
+var entityHandlerProvider = wire.NewSet(entity.NewHandler, entity.NewService, entity.NewRepository, key.NewService, key.NewRepository, outbox.NewService, outbox.NewRepository)
+var streamHandlerProvider = wire.NewSet(stream.NewHandler, stream.NewService, stream.NewRepository, outbox.NewService, outbox.NewRepository, entity.NewService, entity.NewRepository, key.NewService, key.NewRepository)
+var messageHandlerProvider = wire.NewSet(message.NewHandler, message.NewService, message.NewRepository, key.NewService, key.NewRepository, domain.NewService, domain.NewRepository, tombstone.NewService, tombstone.NewRepository)
+var characterHandlerProvider = wire.NewSet(character.NewHandler, character.NewService, character.NewRepository, key.NewService, key.NewRepository, domain.NewService, domain.NewRepository, outbox.NewService, outbox.NewRepository)
+var associationHandlerProvider = wire.NewSet(association.NewHandler, association.NewService, association.NewRepository, message.NewService, message.NewRepository, key.NewService, key.NewRepository, domain.NewService, domain.NewRepository, tombstone.NewService, tombstone.NewRepository)
 var userkvHandlerProvider = wire.NewSet(userkv.NewHandler, userkv.NewService, userkv.NewRepository)
-var collectionHandlerProvider = wire.NewSet(collection.NewHandler, collection.NewService, collection.NewRepository)
+var collectionHandlerProvider = wire.NewSet(collection.NewHandler, collection.NewService, collection.NewRepository, key.NewService, key.NewRepository, domain.NewService, domain.NewRepository, entity.NewService, entity.NewRepository, outbox.NewService, outbox.NewRepository)
 
 func SetupMessageHandler(db *gorm.DB, rdb *redis.Client, config util.Config) message.Handler {
-	wire.Build(messageHandlerProvider, stream.NewService, stream.NewRepository, entity.NewService, entity.NewRepository)
+	wire.Build(messageHandlerProvider, stream.NewService, stream.NewRepository, outbox.NewService, outbox.NewRepository, entity.NewService, entity.NewRepository)
 	return nil
 }
 
-func SetupCharacterHandler(db *gorm.DB, config util.Config) character.Handler {
+func SetupCharacterHandler(db *gorm.DB, rdb *redis.Client, config util.Config) character.Handler {
 	wire.Build(characterHandlerProvider)
 	return nil
 }
 
 func SetupAssociationHandler(db *gorm.DB, rdb *redis.Client, config util.Config) association.Handler {
-	wire.Build(associationHandlerProvider, stream.NewService, stream.NewRepository, entity.NewService, entity.NewRepository)
+	wire.Build(associationHandlerProvider, stream.NewService, stream.NewRepository, outbox.NewService, outbox.NewRepository, entity.NewService, entity.NewRepository)
+	return nil
+}
+
+func SetupTimelineHandler(db *gorm.DB, rdb *redis.Client, config util.Config) timeline.Handler {
+	wire.Build(timeline.NewHandler, timeline.NewService, association.NewService, association.NewRepository, message.NewService, message.NewRepository, character.NewService, character.NewRepository, stream.NewService, stream.NewRepository, outbox.NewService, outbox.NewRepository, entity.NewService, entity.NewRepository, key.NewService, key.NewRepository, domain.NewService, domain.NewRepository, tombstone.NewService, tombstone.NewRepository)
+	return nil
+}
+
+func SetupTombstoneHandler(db *gorm.DB, config util.Config) tombstone.Handler {
+	wire.Build(tombstone.NewHandler, tombstone.NewService, tombstone.NewRepository)
 	return nil
 }
 
@@ -60,28 +75,28 @@ func SetupEntityHandler(db *gorm.DB, rdb *redis.Client, config util.Config) enti
 	return nil
 }
 
-func SetupSocketHandler(rdb *redis.Client, config util.Config) socket.Handler {
-	wire.Build(socket.NewHandler, socket.NewService)
+func SetupSocketHandler(db *gorm.DB, rdb *redis.Client, config util.Config) socket.Handler {
+	wire.Build(socket.NewHandler, socket.NewService, auth.NewService, stream.NewService, stream.NewRepository, outbox.NewService, outbox.NewRepository, entity.NewService, entity.NewRepository, domain.NewService, domain.NewRepository, key.NewService, key.NewRepository)
 	return nil
 }
 
 func SetupAgent(db *gorm.DB, rdb *redis.Client, config util.Config) agent.Agent {
-	wire.Build(agent.NewAgent, domain.NewService, domain.NewRepository, entity.NewService, entity.NewRepository)
+	wire.Build(agent.NewAgent, domain.NewService, domain.NewRepository, entity.NewService, entity.NewRepository, outbox.NewService, outbox.NewRepository, key.NewService, key.NewRepository, stream.NewService, stream.NewRepository)
 	return nil
 }
 
-func SetupAuthHandler(db *gorm.DB, config util.Config) auth.Handler {
-	wire.Build(auth.NewHandler, auth.NewService, entity.NewService, entity.NewRepository, domain.NewService, domain.NewRepository)
+func SetupAuthHandler(db *gorm.DB, rdb *redis.Client, config util.Config) auth.Handler {
+	wire.Build(auth.NewHandler, auth.NewService, entity.NewService, entity.NewRepository, domain.NewService, domain.NewRepository, key.NewService, key.NewRepository, outbox.NewService, outbox.NewRepository)
 	return nil
 }
 
-func SetupAuthService(db *gorm.DB, config util.Config) auth.Service {
-	wire.Build(auth.NewService, entity.NewService, entity.NewRepository, domain.NewService, domain.NewRepository)
+func SetupAuthService(db *gorm.DB, rdb *redis.Client, config util.Config) auth.Service {
+	wire.Build(auth.NewService, entity.NewService, entity.NewRepository, domain.NewService, domain.NewRepository, key.NewService, key.NewRepository, outbox.NewService, outbox.NewRepository)
 	return nil
 }
 
 func SetupUserkvHandler(db *gorm.DB, rdb *redis.Client, config util.Config) userkv.Handler {
-	wire.Build(userkvHandlerProvider, entity.NewService, entity.NewRepository)
+	wire.Build(userkvHandlerProvider, entity.NewService, entity.NewRepository, key.NewService, key.NewRepository, outbox.NewService, outbox.NewRepository)
 	return nil
 }
 
@@ -89,3 +104,23 @@ func SetupCollectionHandler(db *gorm.DB, rdb *redis.Client, config util.Config)
 	wire.Build(collectionHandlerProvider)
 	return nil
 }
+
+func SetupOutboxHandler(db *gorm.DB, rdb *redis.Client, config util.Config) outbox.Handler {
+	wire.Build(outbox.NewHandler, outbox.NewService, outbox.NewRepository)
+	return nil
+}
+
+func SetupKeyHandler(db *gorm.DB) key.Handler {
+	wire.Build(key.NewHandler, key.NewService, key.NewRepository)
+	return nil
+}
+
+func SetupAccountHandler(db *gorm.DB, rdb *redis.Client, config util.Config) account.Handler {
+	wire.Build(account.NewHandler, account.NewService, account.NewRepository, entity.NewService, entity.NewRepository, key.NewService, key.NewRepository, outbox.NewService, outbox.NewRepository, domain.NewService, domain.NewRepository)
+	return nil
+}
+
+func SetupAccountService(db *gorm.DB, rdb *redis.Client, config util.Config) account.Service {
+	wire.Build(account.NewService, account.NewRepository, entity.NewService, entity.NewRepository, key.NewService, key.NewRepository, outbox.NewService, outbox.NewRepository, domain.NewService, domain.NewRepository)
+	return nil
+}
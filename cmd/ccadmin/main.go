@@ -0,0 +1,429 @@
+//go:generate go run github.com/google/wire/cmd/wire gen .
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/exp/slices"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/util"
+)
+
+const usage = `usage: ccadmin [-c config] [-H dbhost] <command> [args]
+
+commands:
+  entity add <ccid>              create entity without registration
+  entity delete <ccid>           delete entity
+  entity tag <ccid> <tag>        add tag to entity (alias: role)
+  entity untag <ccid> <tag>      remove tag from entity
+  domain list                    list known domains
+  domain block <fqdn>            block domain
+  domain unblock <fqdn>          unblock domain
+  domain hello <fqdn>            exchange profile with remote domain
+  stream list [schema]           list streams (of the schema)
+  stream trim <stream> <maxlen>  drop old elements of the stream from redis
+  stream rebuild [stream]        repopulate redis from postgres (default: all streams)
+  stream limit <stream> <maxlen> [retention]
+                                 set redis maxlen and postgres retention (e.g. 720h, 0 for default)
+  stream prune                   delete elements older than the retention
+  message delete <id>            delete message
+  keygen                         generate new key pair
+  config validate                check configuration file
+`
+
+var hostPattern = regexp.MustCompile(`host=\S+`)
+
+var errUsage = fmt.Errorf("invalid usage")
+
+type app struct {
+	configPath string
+	dbHost     string
+	config     util.Config
+	db         *gorm.DB
+	rdb        *redis.Client
+}
+
+func main() {
+	defaultConfig := os.Getenv("CONCURRENT_CONFIG")
+	if defaultConfig == "" {
+		defaultConfig = "/etc/concurrent/config.yaml"
+	}
+
+	a := app{}
+	flag.StringVar(&a.configPath, "c", defaultConfig, "path to config file")
+	flag.StringVar(&a.dbHost, "H", "", "override database host in dsn")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	os.Exit(a.run(flag.Args()))
+}
+
+// run dispatches the command and returns exit code
+func (a *app) run(args []string) int {
+	if len(args) < 1 {
+		flag.Usage()
+		return 2
+	}
+
+	ctx := context.Background()
+	var err error
+
+	switch args[0] {
+	case "keygen":
+		err = keygen()
+	case "config":
+		if len(args) < 2 || args[1] != "validate" {
+			flag.Usage()
+			return 2
+		}
+		err = a.validateConfig()
+	case "entity", "domain", "stream", "message":
+		if len(args) < 2 {
+			flag.Usage()
+			return 2
+		}
+		err = a.connect()
+		if err != nil {
+			break
+		}
+		defer a.rdb.Close()
+
+		switch args[0] {
+		case "entity":
+			err = a.entityCommand(ctx, args[1], args[2:])
+		case "domain":
+			err = a.domainCommand(ctx, args[1], args[2:])
+		case "stream":
+			err = a.streamCommand(ctx, args[1], args[2:])
+		case "message":
+			err = a.messageCommand(ctx, args[1], args[2:])
+		}
+	default:
+		flag.Usage()
+		return 2
+	}
+
+	if err == errUsage {
+		flag.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// connect loads the config and opens database and redis
+func (a *app) connect() error {
+	err := a.config.Load(a.configPath)
+	if err != nil {
+		return err
+	}
+
+	dsn := a.config.Server.Dsn
+	if a.dbHost != "" {
+		if hostPattern.MatchString(dsn) {
+			dsn = hostPattern.ReplaceAllString(dsn, "host="+a.dbHost)
+		} else {
+			dsn = "host=" + a.dbHost + " " + dsn
+		}
+	}
+
+	a.db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return fmt.Errorf("failed to connect database: %w", err)
+	}
+
+	a.rdb = redis.NewClient(&redis.Options{
+		Addr:     a.config.Server.RedisAddr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	return nil
+}
+
+func (a *app) entityCommand(ctx context.Context, command string, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	service := SetupEntityService(a.db, a.rdb, a.config)
+
+	switch command {
+	case "add":
+		err := service.Create(ctx, args[0], "null")
+		if err != nil {
+			return err
+		}
+		fmt.Println("created", args[0])
+	case "delete":
+		err := service.Delete(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println("deleted", args[0])
+	case "tag", "role", "untag":
+		if len(args) < 2 {
+			return errUsage
+		}
+		entity, err := service.Get(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if command == "untag" {
+			entity.Tag = removeTag(entity.Tag, args[1])
+		} else {
+			entity.Tag = addTag(entity.Tag, args[1])
+		}
+		// Upsert writes all columns so that the tag can be cleared
+		err = service.Upsert(ctx, &entity)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", entity.ID, entity.Tag)
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func (a *app) domainCommand(ctx context.Context, command string, args []string) error {
+	service := SetupDomainService(a.db, a.config)
+
+	switch command {
+	case "list":
+		domains, err := service.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, domain := range domains {
+			fmt.Printf("%s\t%s\t%s\n", domain.ID, domain.CCID, domain.Tag)
+		}
+	case "block", "unblock":
+		if len(args) < 1 {
+			return errUsage
+		}
+		domain, err := service.GetByFQDN(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if command == "block" {
+			domain.Tag = addTag(domain.Tag, "_blocked")
+		} else {
+			domain.Tag = removeTag(domain.Tag, "_blocked")
+		}
+		err = service.Upsert(ctx, &domain)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", domain.ID, domain.Tag)
+	case "hello":
+		if len(args) < 1 {
+			return errUsage
+		}
+		profile, err := service.SayHello(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", profile.ID, profile.CCID)
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func (a *app) streamCommand(ctx context.Context, command string, args []string) error {
+	service := SetupStreamService(a.db, a.rdb, a.config)
+
+	switch command {
+	case "list":
+		var streams []core.Stream
+		var err error
+		if len(args) > 0 {
+			streams, err = service.StreamListBySchema(ctx, args[0])
+		} else {
+			streams, err = service.List(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		for _, stream := range streams {
+			fmt.Printf("%s\t%s\t%s\n", stream.ID, stream.Author, stream.Schema)
+		}
+	case "trim":
+		if len(args) < 2 {
+			return errUsage
+		}
+		maxlen, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || maxlen < 0 {
+			return fmt.Errorf("invalid maxlen: %s", args[1])
+		}
+		removed, err := service.Trim(ctx, args[0], maxlen)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d elements from %s\n", removed, args[0])
+	case "rebuild":
+		streamIDs := args
+		if len(streamIDs) == 0 {
+			streams, err := service.List(ctx)
+			if err != nil {
+				return err
+			}
+			for _, stream := range streams {
+				streamIDs = append(streamIDs, stream.ID)
+			}
+		}
+		for _, streamID := range streamIDs {
+			count, err := service.Rebuild(ctx, streamID)
+			if err != nil {
+				return fmt.Errorf("failed to rebuild %s: %w", streamID, err)
+			}
+			fmt.Printf("%s: %d elements\n", streamID, count)
+		}
+	case "limit":
+		if len(args) < 2 {
+			return errUsage
+		}
+		maxlen, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid maxlen: %s", args[1])
+		}
+		var retention time.Duration
+		if len(args) > 2 {
+			retention, err = time.ParseDuration(args[2])
+			if err != nil {
+				return fmt.Errorf("invalid retention: %s", args[2])
+			}
+		}
+		err = service.SetLimit(ctx, args[0], maxlen, retention)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: maxlen %d, retention %s\n", args[0], maxlen, retention)
+	case "prune":
+		pruned, err := service.Prune(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("pruned %d elements\n", pruned)
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func (a *app) messageCommand(ctx context.Context, command string, args []string) error {
+	if command != "delete" || len(args) < 1 {
+		return errUsage
+	}
+	service := SetupMessageService(a.db, a.rdb, a.config)
+
+	deleted, err := service.Delete(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println("deleted", deleted.ID)
+	return nil
+}
+
+// keygen prints a new secp256k1 key pair and its CCID
+func keygen() error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println("privatekey:", hex.EncodeToString(crypto.FromECDSA(key)))
+	fmt.Println("publickey: ", hex.EncodeToString(crypto.FromECDSAPub(&key.PublicKey)))
+	fmt.Println("ccid:      ", "CC"+crypto.PubkeyToAddress(key.PublicKey).Hex()[2:])
+	return nil
+}
+
+// validateConfig reports every problem found in the config file
+func (a *app) validateConfig() error {
+	// Load fails on unreadable file or broken private key
+	err := a.config.Load(a.configPath)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	if a.config.Concurrent.FQDN == "" {
+		problems = append(problems, "concurrent.fqdn is empty")
+	} else if strings.Contains(a.config.Concurrent.FQDN, "/") {
+		problems = append(problems, "concurrent.fqdn must not contain scheme or path")
+	}
+	switch a.config.Concurrent.Registration {
+	case "open", "invite", "close":
+	default:
+		problems = append(problems, fmt.Sprintf("concurrent.registration must be one of open, invite, close (got %q)", a.config.Concurrent.Registration))
+	}
+	if a.config.Server.Dsn == "" {
+		problems = append(problems, "server.dsn is empty")
+	}
+	if a.config.Server.RedisAddr == "" {
+		problems = append(problems, "server.redisAddr is empty")
+	}
+	if a.config.Server.EnableTrace && a.config.Server.TraceEndpoint == "" {
+		problems = append(problems, "server.traceEndpoint is required when server.enableTrace is set")
+	}
+	if a.config.Server.TokenLifetime < 0 || a.config.Server.RefreshTokenLifetime < 0 {
+		problems = append(problems, "token lifetimes must be positive")
+	} else if a.config.Server.RefreshTokenLifetime < a.config.Server.TokenLifetime {
+		problems = append(problems, "server.refreshTokenLifetime is shorter than server.tokenLifetime")
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, "-", problem)
+		}
+		return fmt.Errorf("%d problems found in %s", len(problems), a.configPath)
+	}
+
+	fmt.Println("ok:", a.config.Concurrent.FQDN, a.config.Concurrent.CCID)
+	return nil
+}
+
+func addTag(tags string, tag string) string {
+	list := splitTags(tags)
+	if !slices.Contains(list, tag) {
+		list = append(list, tag)
+	}
+	return strings.Join(list, ",")
+}
+
+func removeTag(tags string, tag string) string {
+	list := splitTags(tags)
+	if i := slices.Index(list, tag); i >= 0 {
+		list = slices.Delete(list, i, i+1)
+	}
+	return strings.Join(list, ",")
+}
+
+func splitTags(tags string) []string {
+	list := []string{}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag != "" {
+			list = append(list, tag)
+		}
+	}
+	return list
+}
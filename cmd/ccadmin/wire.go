@@ -0,0 +1,40 @@
+//go:build wireinject
+
+package main
+
+import (
+	"github.com/google/wire"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/message"
+	"github.com/totegamma/concurrent/x/outbox"
+	"github.com/totegamma/concurrent/x/stream"
+	"github.com/totegamma/concurrent/x/tombstone"
+	"github.com/totegamma/concurrent/x/util"
+)
+
+var streamServiceProvider = wire.NewSet(stream.NewService, stream.NewRepository, outbox.NewService, outbox.NewRepository, entity.NewService, entity.NewRepository, key.NewService, key.NewRepository)
+
+func SetupEntityService(db *gorm.DB, rdb *redis.Client, config util.Config) entity.Service {
+	wire.Build(entity.NewService, entity.NewRepository, key.NewService, key.NewRepository, outbox.NewService, outbox.NewRepository)
+	return nil
+}
+
+func SetupDomainService(db *gorm.DB, config util.Config) domain.Service {
+	wire.Build(domain.NewService, domain.NewRepository)
+	return nil
+}
+
+func SetupStreamService(db *gorm.DB, rdb *redis.Client, config util.Config) stream.Service {
+	wire.Build(streamServiceProvider)
+	return nil
+}
+
+func SetupMessageService(db *gorm.DB, rdb *redis.Client, config util.Config) message.Service {
+	wire.Build(message.NewService, message.NewRepository, tombstone.NewService, tombstone.NewRepository, domain.NewService, domain.NewRepository, streamServiceProvider)
+	return nil
+}
@@ -1,8 +1,10 @@
+//go:generate go run github.com/google/wire/cmd/wire gen .
 package main
 
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -21,8 +23,8 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 
+	"github.com/totegamma/concurrent/x/migration"
 	"github.com/totegamma/concurrent/x/util"
-	"github.com/totegamma/concurrent/x/auth"
 
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
@@ -120,7 +122,6 @@ func main() {
 	}))
 
 	e.Use(echoprometheus.NewMiddleware("ccgateway"))
-	e.Use(auth.ParseJWT)
 
 	// Postrgresqlとの接続
 	db, err := gorm.Open(postgres.Open(config.Server.Dsn), &gorm.Config{})
@@ -140,6 +141,14 @@ func main() {
 		panic("failed to setup tracing plugin")
 	}
 
+	// スキーマはapiがマイグレーションする
+	err = migration.NewService(db).Check(context.Background())
+	if errors.Is(err, migration.ErrSchemaTooNew) {
+		log.Fatal(err)
+	} else if err != nil {
+		log.Print("schema check: ", err)
+	}
+
 	// Redisとの接続
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     config.Server.RedisAddr,
@@ -159,6 +168,9 @@ func main() {
 		panic("failed to setup tracing plugin")
 	}
 
+	authService := SetupAuthService(db, rdb, config)
+	e.Use(authService.ParseJWT)
+
 	cors := middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:  []string{"*"},
 		AllowHeaders:  []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
@@ -175,6 +187,8 @@ func main() {
 		proxy := httputil.NewSingleHostReverseProxy(targetUrl)
 
 		proxy.Director = func(req *http.Request) {
+			// keep the original request target for signed server-to-server requests
+			req.Header.Set("X-Forwarded-Uri", req.URL.RequestURI())
 			req.URL.Scheme = targetUrl.Scheme
 			req.URL.Host = targetUrl.Host
 			if service.PreservePath {
@@ -0,0 +1,21 @@
+//go:build wireinject
+
+package main
+
+import (
+	"github.com/google/wire"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"github.com/totegamma/concurrent/x/auth"
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/outbox"
+	"github.com/totegamma/concurrent/x/util"
+)
+
+func SetupAuthService(db *gorm.DB, rdb *redis.Client, config util.Config) auth.Service {
+	wire.Build(auth.NewService, entity.NewService, entity.NewRepository, domain.NewService, domain.NewRepository, key.NewService, key.NewRepository, outbox.NewService, outbox.NewRepository)
+	return nil
+}
@@ -0,0 +1,134 @@
+// Package account handles moving entities between domains
+package account
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/util"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("account")
+
+// Handler is the interface for handling HTTP requests
+type Handler interface {
+	Export(c echo.Context) error
+	Move(c echo.Context) error
+	Import(c echo.Context) error
+	Moved(c echo.Context) error
+}
+
+type handler struct {
+	service Service
+	domain  domain.Service
+}
+
+// NewHandler creates a new handler
+func NewHandler(service Service, domain domain.Service) Handler {
+	return &handler{service: service, domain: domain}
+}
+
+// Export returns the signed archive of the requester
+func (h handler) Export(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerExport")
+	defer span.End()
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+
+	signed, err := h.service.ExportSigned(ctx, claims.Audience)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=\""+claims.Audience+".json\"")
+	return c.JSON(http.StatusOK, signed)
+}
+
+// Move moves the requester to another domain
+func (h handler) Move(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerMove")
+	defer span.End()
+
+	var request moveRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	var object MoveSignedObject
+	err = json.Unmarshal([]byte(request.SignedObject), &object)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+	if object.Signer != claims.Audience {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action"})
+	}
+
+	moved, err := h.service.Move(ctx, request.SignedObject, request.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": moved})
+}
+
+// Import accepts the archive of the entity moving from the requesting domain
+func (h handler) Import(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerImport")
+	defer span.End()
+
+	var packet importPacket
+	err := c.Bind(&packet)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+	requester, err := h.domain.GetByCCID(ctx, claims.Issuer)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action"})
+	}
+
+	err = h.service.Import(ctx, requester.ID, packet)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
+
+// Moved receives the confirmation of the new domain that the entity has been imported
+func (h handler) Moved(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerMoved")
+	defer span.End()
+
+	var request moveRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+	requester, err := h.domain.GetByCCID(ctx, claims.Issuer)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action"})
+	}
+
+	err = h.service.Moved(ctx, requester.ID, request.SignedObject, request.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
@@ -0,0 +1,69 @@
+package account
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/totegamma/concurrent/x/core"
+)
+
+// moveWindow is how long a signed move object is accepted
+// It covers the retries of the outbox delivering the archive and the confirmation.
+const moveWindow = 72 * time.Hour
+
+// ErrMoveReplayed is returned when the move is already carried out or older than the last move of the entity
+var ErrMoveReplayed = errors.New("move is already used or outdated")
+
+// Archive is the portable data of an entity
+type Archive struct {
+	Entity         core.Entity                `json:"entity"`
+	Messages       []core.Message             `json:"messages"`
+	Characters     []core.Character           `json:"characters"`
+	Associations   []core.Association         `json:"associations"`
+	Streams        []core.Stream              `json:"streams"`
+	StreamElements map[string][]StreamElement `json:"streamElements"`
+	Collections    []core.Collection          `json:"collections"`
+	Grants         []core.Grant               `json:"grants"`
+	UserKV         map[string]string          `json:"userkv"`
+	Domain         string                     `json:"domain"`
+	ExportedAt     time.Time                  `json:"exportedAt"`
+}
+
+// StreamElement is a raw entry of the stream in redis
+type StreamElement struct {
+	Timestamp string `json:"timestamp"`
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Author    string `json:"author"`
+	Owner     string `json:"owner,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
+// SignedArchive is the archive signed by the exporting domain
+type SignedArchive struct {
+	Archive   string `json:"archive"`
+	Signer    string `json:"signer"`
+	Signature string `json:"signature"`
+}
+
+// MoveSignedObject is user signed request to move the entity to another domain
+type MoveSignedObject struct {
+	Signer   string    `json:"signer"`
+	KeyID    string    `json:"keyID,omitempty"`
+	Type     string    `json:"type"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	SignedAt time.Time `json:"signedAt"`
+}
+
+type moveRequest struct {
+	SignedObject string `json:"signedObject"`
+	Signature    string `json:"signature"`
+}
+
+// importPacket is sent from the old domain to the new domain
+type importPacket struct {
+	SignedObject string  `json:"signedObject"`
+	Signature    string  `json:"signature"`
+	Archive      Archive `json:"archive"`
+}
@@ -0,0 +1,284 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/totegamma/concurrent/x/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Repository is the interface for account repository
+type Repository interface {
+	Export(ctx context.Context, ccid string) (Archive, error)
+	Import(ctx context.Context, archive Archive, move *core.Move) error
+	ConsumeMove(ctx context.Context, move core.Move) error
+}
+
+type repository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+// NewRepository creates a new account repository
+func NewRepository(db *gorm.DB, rdb *redis.Client) Repository {
+	return &repository{db: db, rdb: rdb}
+}
+
+// Export collects all data authored by the entity
+func (r *repository) Export(ctx context.Context, ccid string) (Archive, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryExport")
+	defer span.End()
+
+	archive := Archive{
+		StreamElements: map[string][]StreamElement{},
+		UserKV:         map[string]string{},
+	}
+
+	db := r.db.WithContext(ctx)
+
+	err := db.First(&archive.Entity, "id = ?", ccid).Error
+	if err != nil {
+		return archive, err
+	}
+	err = db.Preload("Revisions").Where("author = ?", ccid).Order("c_date asc").Find(&archive.Messages).Error
+	if err != nil {
+		return archive, err
+	}
+	err = db.Where("author = ?", ccid).Find(&archive.Characters).Error
+	if err != nil {
+		return archive, err
+	}
+	err = db.Where("author = ?", ccid).Order("c_date asc").Find(&archive.Associations).Error
+	if err != nil {
+		return archive, err
+	}
+	err = db.Where("author = ?", ccid).Find(&archive.Streams).Error
+	if err != nil {
+		return archive, err
+	}
+	err = db.Preload("Items").Where("author = ?", ccid).Find(&archive.Collections).Error
+	if err != nil {
+		return archive, err
+	}
+	err = db.Where("owner = ?", ccid).Find(&archive.Grants).Error
+	if err != nil {
+		return archive, err
+	}
+
+	for _, stream := range archive.Streams {
+		var stored []core.StreamElement
+		err = db.Where("stream = ?", stream.ID).Order("ms asc, seq asc").Find(&stored).Error
+		if err != nil {
+			return archive, err
+		}
+		elements := make([]StreamElement, 0, len(stored))
+		for _, element := range stored {
+			elements = append(elements, StreamElement{
+				Timestamp: fmt.Sprintf("%d-%d", element.Ms, element.Seq),
+				ID:        element.ObjectID,
+				Type:      element.Type,
+				Author:    element.Author,
+				Owner:     element.Owner,
+				Host:      element.Host,
+			})
+		}
+		archive.StreamElements[stream.ID] = elements
+	}
+
+	prefix := "userkv:" + ccid + ":"
+	iter := r.rdb.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		value, err := r.rdb.Get(ctx, iter.Val()).Result()
+		if err != nil {
+			continue
+		}
+		archive.UserKV[strings.TrimPrefix(iter.Val(), prefix)] = value
+	}
+	if err := iter.Err(); err != nil {
+		return archive, err
+	}
+
+	return archive, nil
+}
+
+// parseTimestamp parses the "ms-seq" id of a stream element
+func parseTimestamp(timestamp string) (int64, int64, error) {
+	msStr, seqStr, found := strings.Cut(timestamp, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid timestamp: %v", timestamp)
+	}
+	ms, err := strconv.ParseInt(msStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid timestamp: %v", timestamp)
+	}
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid timestamp: %v", timestamp)
+	}
+	return ms, seq, nil
+}
+
+// upsertOwned stores the row, overwriting an existing one only if it has the same author
+// so that an archive can not take over rows of other entities which happen to share the ID.
+// condition is added to the author check for rows which must also stay in their parent.
+func upsertOwned(tx *gorm.DB, table string, value interface{}, condition ...string) error {
+	conditions := []clause.Expression{clause.Expr{SQL: table + ".author = excluded.author"}}
+	for _, c := range condition {
+		conditions = append(conditions, clause.Expr{SQL: c})
+	}
+	result := tx.Clauses(clause.OnConflict{
+		UpdateAll: true,
+		Where:     clause.Where{Exprs: conditions},
+	}).Omit(clause.Associations).Create(value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("a row of %s in the archive is owned by another entity", table)
+	}
+	return nil
+}
+
+// consumeMove records the move unless the same or a newer move of the signer is recorded
+func consumeMove(tx *gorm.DB, move core.Move) error {
+	result := tx.Exec(
+		`INSERT INTO moves (signature, signer, "from", "to", signed_at)
+		SELECT ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM moves WHERE signer = ? AND signed_at >= ?)
+		ON CONFLICT DO NOTHING`,
+		move.Signature, move.Signer, move.From, move.To, move.SignedAt, move.Signer, move.SignedAt,
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMoveReplayed
+	}
+	return nil
+}
+
+// ConsumeMove records the move, failing with ErrMoveReplayed if it is not newer than the last one
+func (r *repository) ConsumeMove(ctx context.Context, move core.Move) error {
+	ctx, span := tracer.Start(ctx, "RepositoryConsumeMove")
+	defer span.End()
+
+	return consumeMove(r.db.WithContext(ctx), move)
+}
+
+// Import stores the archive. Existing rows with the same ID are overwritten only if they have the same author.
+// If the archive is brought by a move, the move is consumed in the same transaction.
+func (r *repository) Import(ctx context.Context, archive Archive, move *core.Move) error {
+	ctx, span := tracer.Start(ctx, "RepositoryImport")
+	defer span.End()
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if move != nil {
+			if err := consumeMove(tx, *move); err != nil {
+				return err
+			}
+		}
+
+		upsert := tx.Clauses(clause.OnConflict{UpdateAll: true})
+
+		if err := upsert.Omit(clause.Associations).Create(&archive.Entity).Error; err != nil {
+			return err
+		}
+		for _, message := range archive.Messages {
+			if err := upsertOwned(tx, "messages", &message); err != nil {
+				return err
+			}
+			for _, revision := range message.Revisions {
+				// revisions are immutable, existing ones are kept as is
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revision).Error; err != nil {
+					return err
+				}
+			}
+		}
+		for _, character := range archive.Characters {
+			if err := upsertOwned(tx, "characters", &character); err != nil {
+				return err
+			}
+		}
+		for _, association := range archive.Associations {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&association).Error; err != nil {
+				return err
+			}
+		}
+		for _, stream := range archive.Streams {
+			if err := upsertOwned(tx, "streams", &stream); err != nil {
+				return err
+			}
+		}
+		for _, grant := range archive.Grants {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&grant).Error; err != nil {
+				return err
+			}
+		}
+		for _, collection := range archive.Collections {
+			items := collection.Items
+			collection.Items = nil
+			if err := upsertOwned(tx, "collections", &collection); err != nil {
+				return err
+			}
+			for _, item := range items {
+				if err := upsertOwned(tx, "collection_items", &item, "collection_items.collection = excluded.collection"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// elements keep the original timestamp, entries older than the existing ones are skipped in redis
+	for streamID, elements := range archive.StreamElements {
+		for _, element := range elements {
+			ms, seq, err := parseTimestamp(element.Timestamp)
+			if err != nil {
+				return err
+			}
+			err = r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&core.StreamElement{
+				Stream:   streamID,
+				Ms:       ms,
+				Seq:      seq,
+				ObjectID: element.ID,
+				Type:     element.Type,
+				Author:   element.Author,
+				Owner:    element.Owner,
+				Host:     element.Host,
+			}).Error
+			if err != nil {
+				return err
+			}
+			err = r.rdb.XAdd(ctx, &redis.XAddArgs{
+				Stream: streamID,
+				ID:     element.Timestamp,
+				Values: map[string]interface{}{
+					"id":     element.ID,
+					"type":   element.Type,
+					"author": element.Author,
+					"owner":  element.Owner,
+					"host":   element.Host,
+				},
+			}).Err()
+			if err != nil && !strings.Contains(err.Error(), "equal or smaller than the target stream top item") {
+				return err
+			}
+		}
+	}
+
+	for key, value := range archive.UserKV {
+		err := r.rdb.Set(ctx, "userkv:"+archive.Entity.ID+":"+key, value, 0).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
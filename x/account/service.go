@@ -0,0 +1,492 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/outbox"
+	"github.com/totegamma/concurrent/x/util"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+)
+
+// Service is the interface for account service
+type Service interface {
+	Export(ctx context.Context, ccid string) (Archive, error)
+	ExportSigned(ctx context.Context, ccid string) (SignedArchive, error)
+	ImportSigned(ctx context.Context, signed SignedArchive) (Archive, error)
+	Move(ctx context.Context, objectStr string, signature string) (MoveSignedObject, error)
+	Import(ctx context.Context, requester string, packet importPacket) error
+	Moved(ctx context.Context, requester string, objectStr string, signature string) error
+}
+
+type service struct {
+	repository Repository
+	entity     entity.Service
+	key        key.Service
+	outbox     outbox.Service
+	domain     domain.Service
+	config     util.Config
+}
+
+// NewService creates a new account service
+func NewService(repository Repository, entity entity.Service, key key.Service, outbox outbox.Service, domain domain.Service, config util.Config) Service {
+	return &service{repository, entity, key, outbox, domain, config}
+}
+
+// Export returns all data of the entity
+func (s *service) Export(ctx context.Context, ccid string) (Archive, error) {
+	ctx, span := tracer.Start(ctx, "ServiceExport")
+	defer span.End()
+
+	archive, err := s.repository.Export(ctx, ccid)
+	if err != nil {
+		span.RecordError(err)
+		return archive, err
+	}
+
+	archive.Domain = s.config.Concurrent.FQDN
+	archive.ExportedAt = time.Now()
+
+	return archive, nil
+}
+
+// ExportSigned returns all data of the entity signed by this domain
+func (s *service) ExportSigned(ctx context.Context, ccid string) (SignedArchive, error) {
+	ctx, span := tracer.Start(ctx, "ServiceExportSigned")
+	defer span.End()
+
+	archive, err := s.Export(ctx, ccid)
+	if err != nil {
+		span.RecordError(err)
+		return SignedArchive{}, err
+	}
+
+	archiveStr, err := json.Marshal(archive)
+	if err != nil {
+		span.RecordError(err)
+		return SignedArchive{}, err
+	}
+
+	signature, err := util.SignBytes(archiveStr, s.config.Concurrent.PrivateKey)
+	if err != nil {
+		span.RecordError(err)
+		return SignedArchive{}, err
+	}
+
+	return SignedArchive{
+		Archive:   string(archiveStr),
+		Signer:    s.config.Concurrent.CCID,
+		Signature: signature,
+	}, nil
+}
+
+// ImportSigned restores the archive signed by this domain or the known domain it was exported from
+// Archives from other domains can not bring the privileges of the entity
+func (s *service) ImportSigned(ctx context.Context, signed SignedArchive) (Archive, error) {
+	ctx, span := tracer.Start(ctx, "ServiceImportSigned")
+	defer span.End()
+
+	var archive Archive
+	err := util.VerifySignature(signed.Archive, signed.Signer, signed.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return archive, err
+	}
+
+	err = json.Unmarshal([]byte(signed.Archive), &archive)
+	if err != nil {
+		span.RecordError(err)
+		return archive, err
+	}
+
+	if signed.Signer != s.config.Concurrent.CCID {
+		exporter, err := s.domain.GetByCCID(ctx, signed.Signer)
+		if err != nil || exporter.ID != archive.Domain {
+			return archive, fmt.Errorf("the archive is not signed by the domain it was exported from")
+		}
+	}
+
+	if signed.Signer != s.config.Concurrent.CCID {
+		archive.Entity.Tag = ""
+		archive.Entity.Score = 0
+	}
+
+	err = s.importArchive(ctx, archive, nil)
+	if err != nil {
+		span.RecordError(err)
+		return archive, err
+	}
+
+	return archive, nil
+}
+
+// keyChain returns the key chain the archive is verified against
+// The key chain registered here is kept unless the archive extends it,
+// so that an older archive can not bring back revoked keys.
+func (s *service) keyChain(ctx context.Context, archive Archive) ([]key.KeyChainEntry, error) {
+	chain := []key.KeyChainEntry{}
+	if archive.Entity.KeyChain != "" && archive.Entity.KeyChain != "null" {
+		err := json.Unmarshal([]byte(archive.Entity.KeyChain), &chain)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	registered, err := s.key.GetKeyChain(ctx, archive.Entity.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// not registered here yet
+		return chain, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) < len(registered) || !slices.Equal(chain[:len(registered)], registered) {
+		return registered, nil
+	}
+	return chain, nil
+}
+
+// verifyArchive checks every object in the archive belongs to the entity and re-verifies its signature
+// Subkeys are taken from the given key chain of the entity.
+func verifyArchive(archive Archive, chain []key.KeyChainEntry) error {
+	owner := archive.Entity.ID
+
+	subkeys, err := key.ValidateKeyChain(owner, chain)
+	if err != nil {
+		return err
+	}
+
+	grants := map[string][]string{}
+	for _, grant := range archive.Grants {
+		if grant.Owner != owner {
+			return fmt.Errorf("grant %v is not owned by the entity", grant.ID)
+		}
+		err := util.VerifySignedObject(grant.Payload, owner, grant.Signature)
+		if err != nil {
+			return fmt.Errorf("grant %v: %v", grant.ID, err)
+		}
+		grants[grant.Subkey] = grant.Scopes
+	}
+
+	verify := func(payload string, author string, signature string, scope string, targets []string) error {
+		if author != owner {
+			return fmt.Errorf("not authored by the entity")
+		}
+		var object struct {
+			Signer string `json:"signer"`
+			KeyID  string `json:"keyID"`
+		}
+		err := json.Unmarshal([]byte(payload), &object)
+		if err != nil {
+			return err
+		}
+		if object.Signer != author {
+			return fmt.Errorf("signer mismatch")
+		}
+		if object.KeyID == "" || object.KeyID == author {
+			if !key.MasterKeyValid(author, chain, payload) {
+				return fmt.Errorf("signed after the master key is revoked")
+			}
+			return util.VerifySignedObject(payload, author, signature)
+		}
+
+		err = util.VerifySignedObject(payload, object.KeyID, signature)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(subkeys, object.KeyID) {
+			return nil
+		}
+		scopes, ok := grants[object.KeyID]
+		if !ok || scope == "" {
+			return fmt.Errorf("key %v is not authorized", object.KeyID)
+		}
+		if len(targets) == 0 {
+			targets = []string{""}
+		}
+		for _, target := range targets {
+			if !key.ScopeAllows(scopes, scope, target) {
+				return fmt.Errorf("key %v is not allowed to %v %v", object.KeyID, scope, target)
+			}
+		}
+		return nil
+	}
+
+	for _, message := range archive.Messages {
+		if err := verify(message.Payload, message.Author, message.Signature, key.ScopeMessagePost, message.Streams); err != nil {
+			return fmt.Errorf("message %v: %v", message.ID, err)
+		}
+		for _, revision := range message.Revisions {
+			if revision.Message != message.ID {
+				return fmt.Errorf("revision %v is not of the message %v", revision.ID, message.ID)
+			}
+			if err := verify(revision.Payload, message.Author, revision.Signature, key.ScopeMessagePost, message.Streams); err != nil {
+				return fmt.Errorf("revision %v: %v", revision.ID, err)
+			}
+		}
+	}
+	for _, character := range archive.Characters {
+		if err := verify(character.Payload, character.Author, character.Signature, key.ScopeCharacterPut, []string{character.Schema}); err != nil {
+			return fmt.Errorf("character %v: %v", character.ID, err)
+		}
+	}
+	for _, association := range archive.Associations {
+		if err := verify(association.Payload, association.Author, association.Signature, "", nil); err != nil {
+			return fmt.Errorf("association %v: %v", association.ID, err)
+		}
+	}
+	for _, stream := range archive.Streams {
+		if stream.Author != owner {
+			return fmt.Errorf("stream %v is not authored by the entity", stream.ID)
+		}
+	}
+	for streamID, elements := range archive.StreamElements {
+		if !slices.ContainsFunc(archive.Streams, func(stream core.Stream) bool { return stream.ID == streamID }) {
+			return fmt.Errorf("elements of unknown stream %v", streamID)
+		}
+		for _, element := range elements {
+			if _, _, err := parseTimestamp(element.Timestamp); err != nil {
+				return fmt.Errorf("element %v of the stream %v: %v", element.ID, streamID, err)
+			}
+		}
+	}
+	for _, collection := range archive.Collections {
+		if collection.Author != owner {
+			return fmt.Errorf("collection %v is not authored by the entity", collection.ID)
+		}
+		for _, item := range collection.Items {
+			if item.Collection != collection.ID {
+				return fmt.Errorf("item %v is not in the collection %v", item.ID, collection.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// importArchive verifies and stores the archive
+// Stream elements of other entities can not be verified from the archive and are left out.
+func (s *service) importArchive(ctx context.Context, archive Archive, move *core.Move) error {
+	chain, err := s.keyChain(ctx, archive)
+	if err != nil {
+		return err
+	}
+	err = verifyArchive(archive, chain)
+	if err != nil {
+		return err
+	}
+
+	chainStr, err := json.Marshal(chain)
+	if err != nil {
+		return err
+	}
+	archive.Entity.KeyChain = string(chainStr)
+
+	owned := make(map[string][]StreamElement, len(archive.StreamElements))
+	for streamID, elements := range archive.StreamElements {
+		for _, element := range elements {
+			if element.Author == archive.Entity.ID {
+				owned[streamID] = append(owned[streamID], element)
+			}
+		}
+	}
+	archive.StreamElements = owned
+
+	return s.repository.Import(ctx, archive, move)
+}
+
+// verifyMove checks the move object is signed by the entity within the move window
+func (s *service) verifyMove(ctx context.Context, objectStr string, signature string) (MoveSignedObject, error) {
+	var object MoveSignedObject
+	err := json.Unmarshal([]byte(objectStr), &object)
+	if err != nil {
+		return object, err
+	}
+
+	if object.Type != "move" {
+		return object, fmt.Errorf("object is not move")
+	}
+
+	// delegated keys are not allowed to move the entity, only the master key and its subkeys
+	err = s.key.VerifySignature(ctx, objectStr, object.Signer, object.KeyID, signature, "", nil)
+	if err != nil {
+		return object, err
+	}
+
+	if object.From == object.To {
+		return object, fmt.Errorf("destination is same as source")
+	}
+
+	now := time.Now()
+	if object.SignedAt.Before(now.Add(-moveWindow)) || object.SignedAt.After(now.Add(util.SignedRequestSkew)) {
+		return object, fmt.Errorf("move is signed out of the acceptable period")
+	}
+
+	return object, nil
+}
+
+// consumedMove is the record of the move which makes it single use
+func consumedMove(object MoveSignedObject, signature string) core.Move {
+	return core.Move{
+		Signature: signature,
+		Signer:    object.Signer,
+		From:      object.From,
+		To:        object.To,
+		SignedAt:  object.SignedAt,
+	}
+}
+
+// Move sends all data of the local entity to the new domain.
+// The archive is delivered through the outbox, so it will be retried until the new domain accepts it.
+// The entity stays here until the new domain confirms the import with Moved.
+func (s *service) Move(ctx context.Context, objectStr string, signature string) (MoveSignedObject, error) {
+	ctx, span := tracer.Start(ctx, "ServiceMove")
+	defer span.End()
+
+	object, err := s.verifyMove(ctx, objectStr, signature)
+	if err != nil {
+		span.RecordError(err)
+		return object, err
+	}
+
+	if object.From != s.config.Concurrent.FQDN {
+		return object, fmt.Errorf("the entity is not moving from this domain")
+	}
+
+	archive, err := s.Export(ctx, object.Signer)
+	if err != nil {
+		span.RecordError(err)
+		return object, err
+	}
+
+	if archive.Entity.Domain != "" {
+		return object, fmt.Errorf("the entity is not a local user")
+	}
+
+	err = s.outbox.Enqueue(ctx, object.To, "/account/import", importPacket{
+		SignedObject: objectStr,
+		Signature:    signature,
+		Archive:      archive,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return object, err
+	}
+
+	return object, nil
+}
+
+// Moved leaves a redirect to the new domain once it has imported the entity
+// From then on ResolveHost and the elements of the entity point to the new domain,
+// and other domains follow it by scraping.
+func (s *service) Moved(ctx context.Context, requester string, objectStr string, signature string) error {
+	ctx, span := tracer.Start(ctx, "ServiceMoved")
+	defer span.End()
+
+	object, err := s.verifyMove(ctx, objectStr, signature)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if object.From != s.config.Concurrent.FQDN {
+		return fmt.Errorf("the entity is not moving from this domain")
+	}
+	if object.To != requester {
+		return fmt.Errorf("the import is not confirmed by the new domain")
+	}
+
+	ent, err := s.entity.Get(ctx, object.Signer)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if ent.Domain != "" {
+		return fmt.Errorf("the entity is not a local user")
+	}
+
+	// an old move can not take over the redirect after the entity came back
+	err = s.repository.ConsumeMove(ctx, consumedMove(object, signature))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	ent.Domain = object.To
+	err = s.entity.Upsert(ctx, &ent)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Import accepts the archive sent from the old domain of the entity
+func (s *service) Import(ctx context.Context, requester string, packet importPacket) error {
+	ctx, span := tracer.Start(ctx, "ServiceImport")
+	defer span.End()
+
+	object, err := s.verifyMove(ctx, packet.SignedObject, packet.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if object.To != s.config.Concurrent.FQDN {
+		return fmt.Errorf("the entity is not moving to this domain")
+	}
+	if object.From != requester {
+		return fmt.Errorf("the archive is not sent from the old domain")
+	}
+
+	// accept only if the registration is open, or the admin has created the entity in advance
+	existing, err := s.entity.Get(ctx, object.Signer)
+	preregistered := err == nil && existing.Domain == ""
+	if s.config.Concurrent.Registration != "open" && !preregistered {
+		return fmt.Errorf("registration is not open")
+	}
+
+	archive := packet.Archive
+	if archive.Entity.ID != object.Signer {
+		return fmt.Errorf("the archive is not for the signer")
+	}
+
+	// the entity becomes local, privileges of the old domain are not carried over
+	archive.Entity.Domain = ""
+	archive.Entity.Tag = ""
+	archive.Entity.Score = 0
+	archive.Entity.Inviter = ""
+	if preregistered {
+		archive.Entity.Tag = existing.Tag
+		archive.Entity.Score = existing.Score
+		archive.Entity.Inviter = existing.Inviter
+	}
+
+	move := consumedMove(object, packet.Signature)
+	err = s.importArchive(ctx, archive, &move)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// the old domain redirects to here only after the data is in place
+	err = s.outbox.Enqueue(ctx, object.From, "/account/moved", moveRequest{
+		SignedObject: packet.SignedObject,
+		Signature:    packet.Signature,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
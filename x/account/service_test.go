@@ -0,0 +1,207 @@
+package account
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/util"
+	"gorm.io/gorm"
+)
+
+func TestVerifyArchive(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatekey := hex.EncodeToString(crypto.FromECDSA(key))
+	ccid := "CC" + crypto.PubkeyToAddress(key.PublicKey).Hex()[2:]
+
+	payload := fmt.Sprintf(`{"signer":"%s","type":"message","body":"hello"}`, ccid)
+	signature, err := util.SignBytes([]byte(payload), privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := Archive{
+		Entity: core.Entity{ID: ccid},
+		Messages: []core.Message{
+			{ID: "1", Author: ccid, Payload: payload, Signature: signature},
+		},
+	}
+
+	err = verifyArchive(archive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered payload
+	archive.Messages[0].Payload = fmt.Sprintf(`{"signer":"%s","type":"message","body":"bye"}`, ccid)
+	err = verifyArchive(archive, nil)
+	if err == nil {
+		t.Error("expected error for tampered message")
+	}
+
+	// object of another entity
+	archive.Messages[0] = core.Message{ID: "1", Author: "CC0000000000000000000000000000000000000000", Payload: payload, Signature: signature}
+	err = verifyArchive(archive, nil)
+	if err == nil {
+		t.Error("expected error for message of another entity")
+	}
+}
+
+type fakeKey struct {
+	key.Service
+	chains map[string][]key.KeyChainEntry
+}
+
+func (f fakeKey) GetKeyChain(ctx context.Context, ccid string) ([]key.KeyChainEntry, error) {
+	chain, ok := f.chains[ccid]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return chain, nil
+}
+
+func (f fakeKey) VerifySignature(ctx context.Context, objectStr string, signer string, keyID string, signature string, scope string, targets []string) error {
+	return nil
+}
+
+type fakeRepository struct {
+	Repository
+	imported *Archive
+	moves    map[string]time.Time
+}
+
+func (f fakeRepository) Import(ctx context.Context, archive Archive, move *core.Move) error {
+	*f.imported = archive
+	return nil
+}
+
+func (f fakeRepository) ConsumeMove(ctx context.Context, move core.Move) error {
+	if last, ok := f.moves[move.Signer]; ok && !last.Before(move.SignedAt) {
+		return ErrMoveReplayed
+	}
+	f.moves[move.Signer] = move.SignedAt
+	return nil
+}
+
+type fakeEntity struct {
+	entity.Service
+	entities map[string]core.Entity
+}
+
+func (f fakeEntity) Get(ctx context.Context, ccid string) (core.Entity, error) {
+	ent, ok := f.entities[ccid]
+	if !ok {
+		return core.Entity{}, gorm.ErrRecordNotFound
+	}
+	return ent, nil
+}
+
+func (f fakeEntity) Upsert(ctx context.Context, ent *core.Entity) error {
+	f.entities[ent.ID] = *ent
+	return nil
+}
+
+func TestImportArchive(t *testing.T) {
+	owner := "CC0000000000000000000000000000000000000001"
+	other := "CC0000000000000000000000000000000000000002"
+	registered := []key.KeyChainEntry{}
+
+	var imported Archive
+	s := &service{
+		repository: fakeRepository{imported: &imported},
+		key:        fakeKey{chains: map[string][]key.KeyChainEntry{owner: registered}},
+	}
+
+	archive := Archive{
+		Entity:  core.Entity{ID: owner},
+		Streams: []core.Stream{{ID: "stream", Author: owner}},
+		StreamElements: map[string][]StreamElement{
+			"stream": {
+				{Timestamp: "1700000000000-0", ID: "1", Type: "message", Author: owner},
+				{Timestamp: "1700000000001-0", ID: "2", Type: "message", Author: other},
+			},
+		},
+	}
+
+	err := s.importArchive(context.Background(), archive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements := imported.StreamElements["stream"]
+	if len(elements) != 1 || elements[0].Author != owner {
+		t.Errorf("elements of other entities must be left out: %v", elements)
+	}
+	if imported.Entity.KeyChain != "[]" {
+		t.Errorf("expected the registered key chain, got %s", imported.Entity.KeyChain)
+	}
+
+	// malformed element ids are rejected instead of dropped
+	archive.StreamElements["stream"][0].Timestamp = "1700000000000"
+	err = s.importArchive(context.Background(), archive, nil)
+	if err == nil {
+		t.Error("expected error for malformed element id")
+	}
+}
+
+func moveObject(t *testing.T, signer string, from string, to string, signedAt time.Time) string {
+	objectStr, err := json.Marshal(MoveSignedObject{Signer: signer, Type: "move", From: from, To: to, SignedAt: signedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(objectStr)
+}
+
+func TestMovedReplay(t *testing.T) {
+	owner := "CC0000000000000000000000000000000000000001"
+	entities := fakeEntity{entities: map[string]core.Entity{owner: {ID: owner}}}
+	repository := fakeRepository{moves: map[string]time.Time{}}
+	s := &service{
+		repository: repository,
+		entity:     entities,
+		key:        fakeKey{},
+		config:     util.Config{Concurrent: util.Concurrent{FQDN: "a.example.com"}},
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	// stale objects are rejected
+	err := s.Moved(ctx, "b.example.com", moveObject(t, owner, "a.example.com", "b.example.com", now.Add(-moveWindow-time.Hour)), "old")
+	if err == nil {
+		t.Error("expected error for move signed out of the window")
+	}
+
+	moveToB := moveObject(t, owner, "a.example.com", "b.example.com", now.Add(-time.Hour))
+	err = s.Moved(ctx, "b.example.com", moveToB, "toB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entities.entities[owner].Domain != "b.example.com" {
+		t.Fatalf("redirect is not left: %v", entities.entities[owner])
+	}
+
+	// the entity comes back with a newer move
+	err = repository.ConsumeMove(ctx, core.Move{Signature: "toA", Signer: owner, From: "b.example.com", To: "a.example.com", SignedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities.entities[owner] = core.Entity{ID: owner}
+
+	err = s.Moved(ctx, "b.example.com", moveToB, "toB")
+	if !errors.Is(err, ErrMoveReplayed) {
+		t.Errorf("expected replayed move to be rejected, got %v", err)
+	}
+	if entities.entities[owner].Domain != "" {
+		t.Error("replayed move took over the redirect")
+	}
+}
@@ -3,12 +3,16 @@ package agent
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
 	"github.com/totegamma/concurrent/x/core"
 	"github.com/totegamma/concurrent/x/domain"
 	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/outbox"
+	"github.com/totegamma/concurrent/x/stream"
 	"github.com/totegamma/concurrent/x/util"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
@@ -28,6 +32,8 @@ var tracer = otel.Tracer("agent")
 // Agent is the worker that runs scheduled tasks
 // - collect users from other servers
 // - update socket connections
+// - deliver pending outbox items
+// - prune expired stream elements
 type Agent interface {
     Boot()
 }
@@ -37,17 +43,21 @@ type agent struct {
 	config      util.Config
 	domain      domain.Service
 	entity      entity.Service
+	outbox      outbox.Service
+	stream      stream.Service
 	mutex       *sync.Mutex
 	connections map[string]*websocket.Conn
 }
 
 // NewAgent creates a new agent
-func NewAgent(rdb *redis.Client, config util.Config, domain domain.Service, entity entity.Service) Agent {
+func NewAgent(rdb *redis.Client, config util.Config, domain domain.Service, entity entity.Service, outbox outbox.Service, stream stream.Service) Agent {
 	return &agent{
 		rdb,
 		config,
 		domain,
 		entity,
+		outbox,
+		stream,
 		&sync.Mutex{},
 		make(map[string]*websocket.Conn),
 	}
@@ -68,17 +78,27 @@ func (a *agent) Boot() {
 	log.Printf("agent start!")
 	ticker10 := time.NewTicker(10 * time.Second)
 	ticker60 := time.NewTicker(60 * time.Second)
+	ticker3600 := time.NewTicker(3600 * time.Second)
 	go func() {
 		for {
 			select {
 			case <-ticker10.C:
 				a.updateConnections(context.Background())
+				a.outbox.Deliver(context.Background())
 				break
 			case <-ticker60.C:
 				ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 				defer cancel()
 				a.collectUsers(ctx)
 				break
+			case <-ticker3600.C:
+				pruned, err := a.stream.Prune(context.Background())
+				if err != nil {
+					log.Printf("fail to prune stream elements: %v", err)
+				} else if pruned > 0 {
+					log.Printf("pruned %d stream elements", pruned)
+				}
+				break
 			}
 		}
 	}()
@@ -166,13 +186,18 @@ func (a *agent) pullRemoteEntities(ctx context.Context, remote core.Domain) erro
 	defer span.End()
 
 	requestTime := time.Now()
-	req, err := http.NewRequest("GET", "https://"+remote.ID+"/api/v1/entities?since="+strconv.FormatInt(remote.LastScraped.Unix(), 10), nil) // TODO: add except parameter
+	req, err := http.NewRequest("GET", "https://"+remote.ID+"/api/v1/entities/scrape?since="+strconv.FormatInt(remote.LastScraped.Unix(), 10), nil) // TODO: add except parameter
 	if err != nil {
 		span.RecordError(err)
 		return err
 	}
 
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	err = util.SignRequest(req, nil, a.config.Concurrent.CCID, a.config.Concurrent.PrivateKey)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
 	client := new(http.Client)
 	resp, err := client.Do(req)
@@ -204,11 +229,39 @@ func (a *agent) pullRemoteEntities(ctx context.Context, remote core.Domain) erro
 			continue
 		}
 
+		// public key must be derivable to the ccid, otherwise the remote could plant an arbitrary key
+		if entity.Pubkey != "" {
+			pubkey, err := hex.DecodeString(entity.Pubkey)
+			if err != nil || util.PubkeyToCCID(entity.KeyType, pubkey) != entity.ID {
+				log.Printf("invalid pubkey for %v from %v", entity.ID, remote.ID)
+				continue
+			}
+		}
+
+		keyChain := entity.KeyChain
+		if keyChain == "" {
+			keyChain = "null"
+		}
+		if keyChain != "null" {
+			var chain []key.KeyChainEntry
+			err := json.Unmarshal([]byte(keyChain), &chain)
+			if err == nil {
+				_, err = key.ValidateKeyChain(entity.ID, chain)
+			}
+			if err != nil {
+				log.Printf("invalid key chain for %v from %v: %v", entity.ID, remote.ID, err)
+				continue
+			}
+		}
+
 		err := a.entity.Upsert(ctx, &core.Entity{
-			ID:     entity.ID,
-			Domain: hostname,
-			Certs:  certs,
-			Meta:   "null",
+			ID:       entity.ID,
+			Domain:   hostname,
+			Certs:    certs,
+			Meta:     "null",
+			KeyType:  entity.KeyType,
+			Pubkey:   entity.Pubkey,
+			KeyChain: keyChain,
 		})
 
 		if err != nil {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/totegamma/concurrent/x/message"
+	"github.com/totegamma/concurrent/x/tombstone"
 	"github.com/totegamma/concurrent/x/util"
 	"go.opentelemetry.io/otel"
 	"gorm.io/gorm"
@@ -19,6 +20,9 @@ type Handler interface {
     Get(c echo.Context) error
     Post(c echo.Context) error
     Delete(c echo.Context) error
+    Invalidate(c echo.Context) error
+    Forward(c echo.Context) error
+    Retract(c echo.Context) error
 }
 
 type handler struct {
@@ -32,24 +36,47 @@ func NewHandler(service Service, message message.Service) Handler {
 }
 
 // Get returns an association by ID
+// If the association is not stored here and the host query is given, it is fetched from the host.
+// Only associations posted to a stream the requester can read are returned.
 func (h handler) Get(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerGet")
 	defer span.End()
 	id := c.Param("id")
 
 	association, err := h.service.Get(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) && c.QueryParam("host") != "" {
+		association, err = h.service.GetRemote(ctx, c.QueryParam("host"), id, requester(c))
+		if errors.Is(err, util.ErrRemoteNotFound) || errors.Is(err, util.ErrUnknownRemote) {
+			err = gorm.ErrRecordNotFound
+		} else if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusBadGateway, echo.Map{"error": err.Error()})
+		}
+	}
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "association not found"})
 		}
 		return err
 	}
+	if !h.service.CanRead(ctx, association, requester(c)) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not allowed to read this association"})
+	}
 	response := associationResponse{
 		Association: association,
 	}
 	return c.JSON(http.StatusOK, response)
 }
 
+// requester returns the entity of the request, or empty string for anonymous requests
+func requester(c echo.Context) string {
+	claims, ok := c.Get("jwtclaims").(util.JwtClaims)
+	if !ok || claims.Subject != "CONCURRENT_API" {
+		return ""
+	}
+	return claims.Audience
+}
+
 // Post creates a new association
 // returns the created association
 func (h handler) Post(c echo.Context) error {
@@ -61,7 +88,7 @@ func (h handler) Post(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	created, err := h.service.PostAssociation(ctx, request.SignedObject, request.Signature, request.Streams, request.TargetType)
+	created, err := h.service.PostAssociation(ctx, request.SignedObject, request.Signature, request.Streams, request.TargetType, request.TargetHost)
 	if err != nil {
 		return err
 	}
@@ -81,13 +108,18 @@ func (h handler) Delete(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, echo.Map{"error": "target association not found"})
 	}
 
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+	requester := claims.Audience
+
 	message, err := h.message.Get(ctx, association.TargetID)
 	if err == nil { // if target message exists
-		claims := c.Get("jwtclaims").(util.JwtClaims)
-		requester := claims.Audience
 		if (association.Author != requester) && (message.Author != requester) {
 			return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action"})
 		}
+	} else if association.TargetHost != "" { // the target lives on another domain
+		if association.Author != requester {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action"})
+		}
 	}
 
 	deleted, err := h.service.Delete(ctx, associationID)
@@ -96,3 +128,102 @@ func (h handler) Delete(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": deleted})
 }
+
+// Invalidate drops the cached copy of a remote association
+// Called by the home domain of the association when it is deleted
+// Only the copy cached from the requesting domain is dropped.
+func (h handler) Invalidate(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerInvalidate")
+	defer span.End()
+
+	var request invalidateRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	host, ok := c.Get("requesterHost").(string)
+	if !ok {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "requesting domain is not known"})
+	}
+
+	err = h.service.InvalidateRemote(ctx, host, request.ID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
+
+// Forward receives an association whose target is stored here
+// Called by the domain of the association author
+func (h handler) Forward(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerForward")
+	defer span.End()
+
+	var packet forwardPacket
+	err := c.Bind(&packet)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	host, ok := c.Get("requesterHost").(string)
+	if !ok {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "requesting domain is not known"})
+	}
+
+	received, err := h.service.Receive(ctx, packet.ID, packet.SignedObject, packet.Signature, packet.Streams, packet.TargetType, host)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+		if errors.Is(err, ErrConflict) {
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "target not found"})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, echo.Map{"status": "ok", "content": received})
+}
+
+// Retract drops the copy of an association deleted on the domain of its author
+// The tombstone must be signed by the domain sending the request
+func (h handler) Retract(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerRetract")
+	defer span.End()
+
+	var packet retractPacket
+	err := c.Bind(&packet)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	object, err := tombstone.Verify(packet.Tombstone)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	claims, ok := c.Get("jwtclaims").(util.JwtClaims)
+	host, _ := c.Get("requesterHost").(string)
+	if !ok || claims.Issuer != object.Signer || host != object.Host {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "tombstone is not signed by the requesting domain"})
+	}
+
+	deleted, err := h.service.Retract(ctx, packet.Tombstone, host)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// already gone, nothing to retract
+			return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": deleted})
+}
@@ -1,6 +1,7 @@
 package association
 
 import (
+	"github.com/pkg/errors"
 	"github.com/totegamma/concurrent/x/core"
 	"time"
 )
@@ -25,12 +26,37 @@ type postRequest struct {
 	TargetHost   string   `json:"targetHost"`
 }
 
+// ErrPermissionDenied is returned when the requesting domain is not the home domain of the author
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrConflict is returned when an association is received again with a different content
+var ErrConflict = errors.New("association already exists")
+
 type associationResponse struct {
 	Association core.Association `json:"association"`
 }
 
+type invalidateRequest struct {
+	ID string `json:"id"`
+}
+
+// forwardPacket delivers an association to the home domain of its target
+type forwardPacket struct {
+	ID           string   `json:"id"`
+	SignedObject string   `json:"signedObject"`
+	Signature    string   `json:"signature"`
+	Streams      []string `json:"streams"`
+	TargetType   string   `json:"targetType"`
+}
+
+// retractPacket asks the home domain of the target to drop its copy of a deleted association
+type retractPacket struct {
+	Tombstone core.Tombstone `json:"tombstone"`
+}
+
 type SignedObject struct {
 	Signer   string      `json:"signer"`
+	KeyID    string      `json:"keyID,omitempty"`
 	Type     string      `json:"type"`
 	Schema   string      `json:"schema"`
 	Body     interface{} `json:"body"`
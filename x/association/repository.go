@@ -5,14 +5,16 @@ import (
 	"fmt"
 	"github.com/totegamma/concurrent/x/core"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Repository is the interface for association repository
 type Repository interface {
     Create(ctx context.Context, association *core.Association) error
     Get(ctx context.Context, id string) (core.Association, error)
+    GetMulti(ctx context.Context, ids []string) ([]core.Association, error)
     GetOwn(ctx context.Context, author string) ([]core.Association, error)
-    Delete(ctx context.Context, id string) (core.Association, error)
+    Delete(ctx context.Context, id string, bury func(core.Association) (core.Tombstone, error)) (core.Association, core.Tombstone, error)
 }
 
 type repository struct {
@@ -53,19 +55,50 @@ func (r *repository) GetOwn(ctx context.Context, author string) ([]core.Associat
 }
 
 // Delete deletes a association by ID
-func (r *repository) Delete(ctx context.Context, id string) (core.Association, error) {
+// If bury is given, the tombstone made by it is stored in the same transaction.
+func (r *repository) Delete(ctx context.Context, id string, bury func(core.Association) (core.Tombstone, error)) (core.Association, core.Tombstone, error) {
 	ctx, span := tracer.Start(ctx, "RepositoryDelete")
 	defer span.End()
 
 	var deleted core.Association
-	if err := r.db.WithContext(ctx).First(&deleted, "id = ?", id).Error; err != nil {
-		fmt.Printf("Error finding association: %v\n", err)
-		return core.Association{}, err
-	}
-	err := r.db.WithContext(ctx).Where("id = $1", id).Delete(&core.Association{}).Error
+	var tombstone core.Tombstone
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&deleted, "id = ?", id).Error; err != nil {
+			fmt.Printf("Error finding association: %v\n", err)
+			return err
+		}
+
+		if bury != nil {
+			var err error
+			tombstone, err = bury(deleted)
+			if err != nil {
+				return err
+			}
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tombstone).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tx.Where("id = $1", id).Delete(&core.Association{}).Error
+		if err != nil {
+			fmt.Printf("Error deleting association: %v\n", err)
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		fmt.Printf("Error deleting association: %v\n", err)
-		return core.Association{}, err
+		return core.Association{}, core.Tombstone{}, err
 	}
-	return deleted, nil
+	return deleted, tombstone, nil
+}
+
+// GetMulti returns associations by IDs
+func (r *repository) GetMulti(ctx context.Context, ids []string) ([]core.Association, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetMulti")
+	defer span.End()
+
+	var associations []core.Association
+	err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&associations).Error
+	return associations, err
 }
@@ -5,64 +5,65 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
 	"github.com/totegamma/concurrent/x/message"
+	"github.com/totegamma/concurrent/x/outbox"
 	"github.com/totegamma/concurrent/x/stream"
+	"github.com/totegamma/concurrent/x/tombstone"
 	"github.com/totegamma/concurrent/x/util"
 	"log"
 )
 
 // Service is the interface for association service
 type Service interface {
-    PostAssociation(ctx context.Context, objectStr string, signature string, streams []string, targetType string) (core.Association, error)
+    PostAssociation(ctx context.Context, objectStr string, signature string, streams []string, targetType string, targetHost string) (core.Association, error)
+    Receive(ctx context.Context, id string, objectStr string, signature string, streams []string, targetType string, host string) (core.Association, error)
+    Retract(ctx context.Context, tombstone core.Tombstone, host string) (core.Association, error)
     Get(ctx context.Context, id string) (core.Association, error)
+    GetMulti(ctx context.Context, ids []string) ([]core.Association, error)
     GetOwn(ctx context.Context, author string) ([]core.Association, error)
     Delete(ctx context.Context, id string) (core.Association, error)
+    GetRemote(ctx context.Context, host string, id string, requester string) (core.Association, error)
+    InvalidateRemote(ctx context.Context, host string, id string) error
+    CanRead(ctx context.Context, association core.Association, requester string) bool
 }
 
 type service struct {
-	rdb     *redis.Client
-	repo    Repository
-	stream  stream.Service
-	message message.Service
+	rdb       *redis.Client
+	repo      Repository
+	stream    stream.Service
+	message   message.Service
+	key       key.Service
+	outbox    outbox.Service
+	domain    domain.Service
+	entity    entity.Service
+	tombstone tombstone.Service
+	config    util.Config
 }
 
 // NewService creates a new association service
-func NewService(rdb *redis.Client, repo Repository, stream stream.Service, message message.Service) Service {
-	return &service{rdb, repo, stream, message}
+func NewService(rdb *redis.Client, repo Repository, stream stream.Service, message message.Service, key key.Service, outbox outbox.Service, domain domain.Service, entity entity.Service, tombstone tombstone.Service, config util.Config) Service {
+	return &service{rdb, repo, stream, message, key, outbox, domain, entity, tombstone, config}
 }
 
-// PostAssociation creates a new association
-// If targetType is messages, it also posts the association to the target message's streams
-// returns the created association
-func (s *service) PostAssociation(ctx context.Context, objectStr string, signature string, streams []string, targetType string) (core.Association, error) {
-	ctx, span := tracer.Start(ctx, "ServicePostAssociation")
-	defer span.End()
-
+// newAssociation builds an association from the signed object
+// The signature is not verified here
+func newAssociation(objectStr string, signature string, streams []string, targetType string) (core.Association, SignedObject, error) {
 	var object SignedObject
 	err := json.Unmarshal([]byte(objectStr), &object)
 	if err != nil {
-		span.RecordError(err)
-		return core.Association{}, err
+		return core.Association{}, object, err
 	}
 
-	if err := util.VerifySignature(objectStr, object.Signer, signature); err != nil {
-		span.RecordError(err)
-		return core.Association{}, err
-	}
-
-	var content SignedObject
-	err = json.Unmarshal([]byte(objectStr), &content)
+	contentString, err := json.Marshal(object.Body)
 	if err != nil {
-		span.RecordError(err)
-		return core.Association{}, err
-	}
-
-	contentString, err := json.Marshal(content.Body)
-	if err != nil {
-		span.RecordError(err)
-		return core.Association{}, err
+		return core.Association{}, object, err
 	}
 
 	hash := sha256.Sum256(contentString)
@@ -79,6 +80,33 @@ func (s *service) PostAssociation(ctx context.Context, objectStr string, signatu
 		ContentHash: contentHash,
 	}
 
+	return association, object, nil
+}
+
+// PostAssociation creates a new association
+// If targetType is messages, it also posts the association to the target message's streams
+// If the target lives on another domain, the association is forwarded to the target's home domain
+// returns the created association
+func (s *service) PostAssociation(ctx context.Context, objectStr string, signature string, streams []string, targetType string, targetHost string) (core.Association, error) {
+	ctx, span := tracer.Start(ctx, "ServicePostAssociation")
+	defer span.End()
+
+	association, object, err := newAssociation(objectStr, signature, streams, targetType)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	if err := s.key.VerifySignature(ctx, objectStr, object.Signer, object.KeyID, signature, "", nil); err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	if targetHost != "" && targetHost != s.config.Concurrent.FQDN {
+		association.TargetHost = targetHost
+		return s.postRemoteTarget(ctx, association)
+	}
+
 	err = s.repo.Create(ctx, &association)
 	if err != nil {
 		span.RecordError(err)
@@ -103,13 +131,166 @@ func (s *service) PostAssociation(ctx context.Context, objectStr string, signatu
 		}
 	}
 
-	for _, stream := range targetMessage.Streams {
+	s.publishToTarget(ctx, targetMessage.Streams, association.TargetID, "create")
+
+	return association, nil
+}
+
+// postRemoteTarget stores an association whose target lives on another domain
+// The association is posted to its streams from here, and forwarded to the target's home domain
+// so that it is counted on the target and notified to the target's streams
+func (s *service) postRemoteTarget(ctx context.Context, association core.Association) (core.Association, error) {
+	ctx, span := tracer.Start(ctx, "ServicePostRemoteTarget")
+	defer span.End()
+
+	owner := ""
+	if association.TargetType == "messages" {
+		targetMessage, err := s.message.GetRemote(ctx, association.TargetHost, association.TargetID, association.Author)
+		if err != nil {
+			span.RecordError(err)
+			return association, err
+		}
+		owner = targetMessage.Author
+	}
+
+	err := s.repo.Create(ctx, &association)
+	if err != nil {
+		span.RecordError(err)
+		return association, err
+	}
+
+	for _, stream := range association.Streams {
+		err = s.stream.Post(ctx, stream, association.ID, "association", association.Author, "", owner)
+		if err != nil {
+			span.RecordError(err)
+			log.Printf("fail to post stream: %v", err)
+		}
+	}
+
+	err = s.outbox.Enqueue(ctx, association.TargetHost, "/association/forward", forwardPacket{
+		ID:           association.ID,
+		SignedObject: association.Payload,
+		Signature:    association.Signature,
+		Streams:      association.Streams,
+		TargetType:   association.TargetType,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return association, err
+	}
+
+	return association, nil
+}
+
+// Receive stores an association forwarded by the domain of its author
+// host is the requesting domain, which must be the home domain of the author.
+// The sender has already posted it to its streams, so only the streams of the target are notified.
+// Receiving the same association again is a no-op, receiving another one with the same id is a conflict.
+func (s *service) Receive(ctx context.Context, id string, objectStr string, signature string, streams []string, targetType string, host string) (core.Association, error) {
+	ctx, span := tracer.Start(ctx, "ServiceReceive")
+	defer span.End()
+
+	existing, err := s.repo.Get(ctx, id)
+	if err == nil {
+		if existing.Payload != objectStr || existing.Signature != signature {
+			return core.Association{}, errors.Wrapf(ErrConflict, "association %v", id)
+		}
+		return existing, nil
+	}
+
+	association, object, err := newAssociation(objectStr, signature, streams, targetType)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	home, err := s.entity.ResolveHost(ctx, association.Author)
+	if err != nil || home != host {
+		return core.Association{}, errors.Wrap(ErrPermissionDenied, "association is not forwarded by the home domain of the author")
+	}
+
+	err = s.key.VerifyRemoteSignature(ctx, host, objectStr, object.Signer, object.KeyID, signature)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	var targetStreams []string
+	if targetType == "messages" {
+		targetMessage, err := s.message.Get(ctx, association.TargetID)
+		if err != nil {
+			span.RecordError(err)
+			return core.Association{}, err
+		}
+		targetStreams = targetMessage.Streams
+	}
+
+	association.ID = id
+	association.Host = host
+	err = s.repo.Create(ctx, &association)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	s.publishToTarget(ctx, targetStreams, association.TargetID, "create")
+
+	return association, nil
+}
+
+// Retract drops the copy of an association deleted on the domain of its author
+// The tombstone must be verified by the caller.
+// host is the requesting domain, which must be the home domain of the author and the one the association came from.
+func (s *service) Retract(ctx context.Context, tombstone core.Tombstone, host string) (core.Association, error) {
+	ctx, span := tracer.Start(ctx, "ServiceRetract")
+	defer span.End()
+
+	home, err := s.entity.ResolveHost(ctx, tombstone.Author)
+	if err != nil || home != host {
+		return core.Association{}, errors.Wrap(ErrPermissionDenied, "tombstone is not sent by the home domain of the author")
+	}
+
+	association, err := s.repo.Get(ctx, tombstone.ID)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	if association.Author != tombstone.Author {
+		return core.Association{}, fmt.Errorf("tombstone author does not match the association")
+	}
+	if association.Host != host {
+		return core.Association{}, errors.Wrap(ErrPermissionDenied, "association is not received from the requesting domain")
+	}
+
+	deleted, _, err := s.repo.Delete(ctx, association.ID, nil)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	if deleted.TargetType == "messages" {
+		targetMessage, err := s.message.Get(ctx, deleted.TargetID)
+		if err == nil {
+			s.publishToTarget(ctx, targetMessage.Streams, deleted.TargetID, "delete")
+		}
+	}
+
+	return deleted, nil
+}
+
+// publishToTarget notifies the streams of the target that its associations changed
+func (s *service) publishToTarget(ctx context.Context, streams []string, targetID string, action string) {
+	ctx, span := tracer.Start(ctx, "ServicePublishToTarget")
+	defer span.End()
+
+	for _, stream := range streams {
 		jsonstr, _ := json.Marshal(Event{
 			Stream: stream,
 			Type:   "association",
-			Action: "create",
+			Action: action,
 			Body: Element{
-				ID: association.TargetID,
+				ID: targetID,
 			},
 		})
 		err := s.rdb.Publish(context.Background(), stream, jsonstr).Err()
@@ -118,8 +299,15 @@ func (s *service) PostAssociation(ctx context.Context, objectStr string, signatu
 			log.Printf("fail to publish message to Redis: %v", err)
 		}
 	}
+}
 
-	return association, nil
+// CanRead reports whether the requester can read the association
+// An association is readable if one of the streams it is posted to is readable.
+func (s *service) CanRead(ctx context.Context, association core.Association, requester string) bool {
+	ctx, span := tracer.Start(ctx, "ServiceCanRead")
+	defer span.End()
+
+	return s.stream.CanReadAny(ctx, association.Streams, requester)
 }
 
 // Get returns an association by ID
@@ -139,16 +327,47 @@ func (s *service) GetOwn(ctx context.Context, author string) ([]core.Association
 }
 
 // Delete deletes an association by ID
+// The association is retracted from its streams and its tombstone is published for other domains.
 func (s *service) Delete(ctx context.Context, id string) (core.Association, error) {
 	ctx, span := tracer.Start(ctx, "ServiceDelete")
 	defer span.End()
 
-	deleted, err := s.repo.Delete(ctx, id)
+	deleted, tomb, err := s.repo.Delete(ctx, id, func(association core.Association) (core.Tombstone, error) {
+		return s.tombstone.Sign("association", association.ID, association.Author)
+	})
 	if err != nil {
 		span.RecordError(err)
 		return core.Association{}, err
 	}
 
+	for _, deststream := range deleted.Streams {
+		err := s.stream.Retract(ctx, deststream, tomb)
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	// domains holding the association in their streams may have cached it
+	for _, host := range stream.Hosts(deleted.Streams) {
+		if host == s.config.Concurrent.FQDN {
+			continue
+		}
+		err := s.outbox.Enqueue(ctx, host, "/association/invalidate", invalidateRequest{ID: deleted.ID})
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	// the home domain of the target keeps a copy of the association
+	if deleted.TargetHost != "" && deleted.TargetHost != s.config.Concurrent.FQDN {
+		err := s.outbox.Enqueue(ctx, deleted.TargetHost, "/association/retract", retractPacket{Tombstone: tomb})
+		if err != nil {
+			span.RecordError(err)
+			return deleted, err
+		}
+		return deleted, nil
+	}
+
 	if deleted.TargetType != "messages" { // distribute is needed only when targetType is messages
 		return deleted, nil
 	}
@@ -158,21 +377,93 @@ func (s *service) Delete(ctx context.Context, id string) (core.Association, erro
 		span.RecordError(err)
 		return deleted, err
 	}
-	for _, stream := range targetMessage.Streams {
-		jsonstr, _ := json.Marshal(Event{
-			Stream: stream,
-			Type:   "association",
-			Action: "delete",
-			Body: Element{
-				ID: deleted.TargetID,
-			},
-		})
-		err := s.rdb.Publish(context.Background(), stream, jsonstr).Err()
-		if err != nil {
-			log.Printf("fail to publish message to Redis: %v", err)
-			span.RecordError(err)
-			return deleted, err
+	s.publishToTarget(ctx, targetMessage.Streams, deleted.TargetID, "delete")
+
+	return deleted, nil
+}
+
+// GetMulti returns associations by IDs
+func (s *service) GetMulti(ctx context.Context, ids []string) ([]core.Association, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetMulti")
+	defer span.End()
+
+	return s.repo.GetMulti(ctx, ids)
+}
+
+// GetRemote returns an association stored in another domain
+// The association is read with the identity of the requester, so that associations in private streams can be fetched.
+// Fetched associations are verified and cached for util.RemoteCacheTTL
+func (s *service) GetRemote(ctx context.Context, host string, id string, requester string) (core.Association, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetRemote")
+	defer span.End()
+
+	var response associationResponse
+
+	err := s.domain.CheckFetchable(ctx, host)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	cached, err := util.GetRemoteCache(ctx, s.rdb, remoteCacheKey(host, id), requester)
+	if err == nil {
+		err = json.Unmarshal([]byte(cached), &response)
+		if err == nil {
+			return response.Association, nil
 		}
 	}
-	return deleted, nil
+
+	token, err := util.RequesterToken(s.config, requester)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	body, err := util.FetchRemote(ctx, host, "/association/"+id, token, &response)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	association := response.Association
+	if association.ID != id {
+		err = fmt.Errorf("remote returned association %s for %s", association.ID, id)
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	var object SignedObject
+	err = json.Unmarshal([]byte(association.Payload), &object)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+	if object.Signer != association.Author || object.Target != association.TargetID {
+		err = fmt.Errorf("association %s does not match its signed object", id)
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	err = s.key.VerifyRemoteSignature(ctx, host, association.Payload, object.Signer, object.KeyID, association.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return core.Association{}, err
+	}
+
+	util.SetRemoteCache(ctx, s.rdb, remoteCacheKey(host, id), requester, body)
+
+	return association, nil
+}
+
+// InvalidateRemote drops the association cached from the host
+func (s *service) InvalidateRemote(ctx context.Context, host string, id string) error {
+	ctx, span := tracer.Start(ctx, "ServiceInvalidateRemote")
+	defer span.End()
+
+	return util.DeleteRemoteCache(ctx, s.rdb, remoteCacheKey(host, id))
+}
+
+// remoteCacheKey is keyed by the host too, so that a domain cannot overwrite what was fetched from another
+func remoteCacheKey(host string, id string) string {
+	return "association:remote:" + host + ":" + id
 }
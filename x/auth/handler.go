@@ -3,6 +3,7 @@ package auth
 
 import (
 	"github.com/labstack/echo/v4"
+	"github.com/totegamma/concurrent/x/util"
 	"go.opentelemetry.io/otel"
 	"net/http"
 )
@@ -12,6 +13,9 @@ var tracer = otel.Tracer("auth")
 // Handler is the interface for handling HTTP requests
 type Handler interface {
     Claim(c echo.Context) error
+    Refresh(c echo.Context) error
+    Revoke(c echo.Context) error
+    RevokeEntity(c echo.Context) error
 }
 
 type handler struct {
@@ -34,10 +38,58 @@ func (h *handler) Claim(c echo.Context) error {
 		request = c.Request().Header.Get("Authentication")
 	}
 
-	response, err := h.service.IssueJWT(ctx, request)
+	response, refreshToken, err := h.service.IssueJWT(ctx, request)
 	if err != nil {
 		span.RecordError(err)
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
 	}
-	return c.JSON(http.StatusOK, echo.Map{"jwt": response})
+	return c.JSON(http.StatusOK, echo.Map{"jwt": response, "refreshToken": refreshToken})
+}
+
+// Refresh is used for get server signed jwt without client signature
+// input refresh token, the token is rotated on every use
+func (h *handler) Refresh(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerRefresh")
+	defer span.End()
+
+	var request refreshRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	response, refreshToken, err := h.service.Refresh(ctx, request.RefreshToken)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"jwt": response, "refreshToken": refreshToken})
+}
+
+// Revoke invalidates all jwt of the requester
+func (h *handler) Revoke(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerRevoke")
+	defer span.End()
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+	err := h.service.RevokeTokens(ctx, claims.Audience)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
+
+// RevokeEntity invalidates all jwt of the specified entity
+func (h *handler) RevokeEntity(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerRevokeEntity")
+	defer span.End()
+
+	id := c.Param("id")
+	err := h.service.RevokeTokens(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
 }
@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/totegamma/concurrent/x/key"
 	"github.com/totegamma/concurrent/x/util"
 	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/exp/slices"
+	"io/ioutil"
 	"net/http"
 	"strings"
 )
@@ -21,8 +24,19 @@ const (
 	ISUNUNITED
 )
 
+// scopeTargets are the path parameters naming the target of the scope, the first one present is used
+// Scopes not listed here are checked against their target by the services.
+var scopeTargets = map[string][]string{
+	key.ScopeKVRead:          {"key"},
+	key.ScopeKVWrite:         {"key"},
+	key.ScopeCollectionWrite: {"id", "collection"},
+}
+
 // Restrict is a middleware that restricts access to certain routes
-func (s *service) Restrict(principal Principal) echo.MiddlewareFunc {
+// Local and admin routes only accept tokens issued by this domain, and known routes also accept
+// tokens issued by the home domain of the user.
+// Tokens issued for delegated keys are only accepted when one of the given scopes of their grant is granted for the target
+func (s *service) Restrict(principal Principal, scopes ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			ctx, span := tracer.Start(c.Request().Context(), "auth.Restrict")
@@ -33,11 +47,34 @@ func (s *service) Restrict(principal Principal) echo.MiddlewareFunc {
 			}
 			tags := strings.Split(claims.Tag, ",")
 
+			if claims.Subkey != "" {
+				granted := strings.Fields(claims.Scope)
+				allowed := false
+				for _, scope := range scopes {
+					target := ""
+					for _, param := range scopeTargets[scope] {
+						if target = c.Param(param); target != "" {
+							break
+						}
+					}
+					if key.ScopeAllows(granted, scope, target) {
+						allowed = true
+						break
+					}
+				}
+				if !allowed {
+					return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action", "detail": "out of the scope of the key"})
+				}
+			}
+
 			switch principal {
 			case ISADMIN:
 				if claims.Subject != "CONCURRENT_API" {
 					return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid jwt"})
 				}
+				if claims.Issuer != s.config.Concurrent.CCID {
+					return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action", "detail": "token is not issued by this domain"})
+				}
 				if !slices.Contains(tags, "_admin") {
 					return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action", "detail": "you are not admin"})
 				}
@@ -45,6 +82,9 @@ func (s *service) Restrict(principal Principal) echo.MiddlewareFunc {
 				if claims.Subject != "CONCURRENT_API" {
 					return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid jwt"})
 				}
+				if claims.Issuer != s.config.Concurrent.CCID {
+					return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action", "detail": "token is not issued by this domain"})
+				}
 
 				ent, err := s.entity.Get(ctx, claims.Audience)
 				if err != nil {
@@ -63,16 +103,10 @@ func (s *service) Restrict(principal Principal) echo.MiddlewareFunc {
 					return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action", "detail": "you are not known"})
 				}
 
-				// remote user must be checked if it's domain is not blocked
-				if claims.Issuer != s.config.Concurrent.CCID {
-					domain, err := s.domain.GetByCCID(ctx, claims.Issuer)
-					if err != nil {
-						return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action", "detail": "your domain is not known"})
-					}
-					domainTags := strings.Split(domain.Tag, ",")
-					if slices.Contains(domainTags, "_blocked") {
-						return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action", "detail": "your domain is blocked"})
-					}
+				// remote user must be vouched by its home domain which is not blocked
+				err = s.trustIssuer(ctx, claims)
+				if err != nil {
+					return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action", "detail": err.Error()})
 				}
 			case ISUNKNOWN:
 				_, err := s.entity.Get(ctx, claims.Audience)
@@ -107,8 +141,9 @@ func (s *service) Restrict(principal Principal) echo.MiddlewareFunc {
 }
 
 // JWT is middleware which validate jwt
-// error if jwt is missing or invalid
-func JWT(next echo.HandlerFunc) echo.HandlerFunc {
+// error if jwt is missing, invalid, revoked or issued by a domain which is not trusted for the user
+// Tokens signed by users themselves pass only to be used for registration, as Restrict rejects them elsewhere.
+func (s *service) JWT(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		ctx, span := tracer.Start(c.Request().Context(), "auth.JWT")
 		defer span.End()
@@ -128,12 +163,20 @@ func JWT(next echo.HandlerFunc) echo.HandlerFunc {
 			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "only Bearer is acceptable"})
 		}
 
-		claims, err := util.ValidateJWT(jwt)
+		claims, err := s.validateJWT(ctx, jwt)
 		if err != nil {
 			span.RecordError(err)
 			return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
 		}
 
+		if _, err := s.domain.GetByCCID(ctx, claims.Issuer); err == nil || claims.Issuer == s.config.Concurrent.CCID {
+			err = s.trustIssuer(ctx, claims)
+			if err != nil {
+				span.RecordError(err)
+				return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+			}
+		}
+
 		c.Set("jwtclaims", claims)
 		span.SetAttributes(attribute.String("Audience", claims.Audience))
 
@@ -143,9 +186,8 @@ func JWT(next echo.HandlerFunc) echo.HandlerFunc {
 }
 
 // ParseJWT is middleware which validate jwt
-// ignore if jwt is missing
-// error only if jwt is invalid
-func ParseJWT(next echo.HandlerFunc) echo.HandlerFunc {
+// ignore if jwt is missing, invalid, revoked or issued by an unknown domain
+func (s *service) ParseJWT(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		ctx, span := tracer.Start(c.Request().Context(), "auth.ParseJWT")
 		defer span.End()
@@ -166,7 +208,7 @@ func ParseJWT(next echo.HandlerFunc) echo.HandlerFunc {
 				goto skip
 			}
 
-			claims, err := util.ValidateJWT(jwt)
+			claims, err := s.Identify(ctx, jwt)
 			if err != nil {
 				span.RecordError(err)
 				//return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
@@ -182,3 +224,58 @@ skip:
 		return next(c)
 	}
 }
+
+// SignedRequest is middleware which validate server-to-server request signature
+// error if signature is missing, invalid, replayed or not matched to the known domain key
+// on success, it sets jwtclaims of the requesting domain so that Restrict can be used after this
+// and requesterHost to the FQDN of the requesting domain if it is known
+func (s *service) SignedRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, span := tracer.Start(c.Request().Context(), "auth.SignedRequest")
+		defer span.End()
+
+		body, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "failed to read body"})
+		}
+		c.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		signed, err := util.VerifyRequest(c.Request(), body)
+		if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+		}
+
+		// replay protection
+		fresh, err := s.rdb.SetNX(ctx, "reqid:"+signed.KeyID+":"+signed.JTI, "1", 2*util.SignedRequestSkew).Result()
+		if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed to check request id"})
+		}
+		if !fresh {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "request is already used"})
+		}
+
+		// if the domain is known, the signature must be made by the registered key
+		domain, err := s.domain.GetByCCID(ctx, signed.KeyID)
+		if err == nil && domain.Pubkey != signed.Pubkey {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "signature does not match the domain key"})
+		}
+
+		c.Set("signedrequest", signed)
+		if err == nil {
+			c.Set("requesterHost", domain.ID)
+		}
+		c.Set("jwtclaims", util.JwtClaims{
+			Issuer:   signed.KeyID,
+			Subject:  "CONCURRENT_API",
+			Audience: s.config.Concurrent.FQDN,
+			JWTID:    signed.JTI,
+		})
+		span.SetAttributes(attribute.String("Issuer", signed.KeyID))
+
+		c.SetRequest(c.Request().WithContext(ctx))
+		return next(c)
+	}
+}
@@ -0,0 +1,5 @@
+package auth
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
@@ -2,35 +2,57 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/xid"
+	"github.com/totegamma/concurrent/x/core"
 	"github.com/totegamma/concurrent/x/domain"
 	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
 	"github.com/totegamma/concurrent/x/util"
 	"strconv"
+	"strings"
 	"time"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
 )
 
 // Service is the interface for auth service
 type Service interface {
-    IssueJWT(ctx context.Context, request string) (string, error)
-    Restrict(principal Principal) echo.MiddlewareFunc
+    IssueJWT(ctx context.Context, request string) (string, string, error)
+    Refresh(ctx context.Context, refreshToken string) (string, string, error)
+    RevokeTokens(ctx context.Context, ccid string) error
+    Restrict(principal Principal, scopes ...string) echo.MiddlewareFunc
+    JWT(next echo.HandlerFunc) echo.HandlerFunc
+    ParseJWT(next echo.HandlerFunc) echo.HandlerFunc
+    Identify(ctx context.Context, jwt string) (util.JwtClaims, error)
+    SignedRequest(next echo.HandlerFunc) echo.HandlerFunc
 }
 
+// claimJTITTL is how long the jti of a claim without exp is remembered
+const claimJTITTL = 24 * time.Hour
+
 type service struct {
+	rdb    *redis.Client
 	config util.Config
 	entity entity.Service
 	domain domain.Service
+	key    key.Service
 }
 
 // NewService creates a new auth service
-func NewService(config util.Config, entity entity.Service, domain domain.Service) Service {
-	return &service{config, entity, domain}
+func NewService(rdb *redis.Client, config util.Config, entity entity.Service, domain domain.Service, key key.Service) Service {
+	return &service{rdb, config, entity, domain, key}
 }
 
-// IssueJWT takes client signed JWT and returns server signed JWT
-func (s *service) IssueJWT(ctx context.Context, request string) (string, error) {
+// IssueJWT takes client signed JWT and returns server signed JWT and refresh token
+// The client JWT can be signed by the master key of the entity unless it is revoked,
+// by an active subkey of the entity named as the principal, or by a delegated key.
+func (s *service) IssueJWT(ctx context.Context, request string) (string, string, error) {
 	ctx, span := tracer.Start(ctx, "ServiceIssueJWT")
 	defer span.End()
 
@@ -38,50 +60,388 @@ func (s *service) IssueJWT(ctx context.Context, request string) (string, error)
 	claims, err := util.ValidateJWT(request)
 	if err != nil {
 		span.RecordError(err)
-		return "", err
+		return "", "", err
 	}
 
-	// TODO: check jti not used recently
+	// check jti not used recently
+	if claims.JWTID == "" {
+		return "", "", fmt.Errorf("jti is required")
+	}
+	jtiTTL := claimJTITTL
+	if claims.ExpirationTime != "" {
+		exp, err := strconv.ParseInt(claims.ExpirationTime, 10, 64)
+		if err != nil {
+			return "", "", err
+		}
+		jtiTTL = time.Until(time.Unix(exp, 0))
+	}
+	fresh, err := s.rdb.SetNX(ctx, "jti:"+claims.Issuer+":"+claims.JWTID, "1", jtiTTL).Result()
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
+	}
+	if !fresh {
+		return "", "", fmt.Errorf("jwt is already used")
+	}
 
 	// check sub
 	if claims.Subject != "CONCURRENT_APICLAIM" {
-		return "", fmt.Errorf("invalid jwt subject")
+		return "", "", fmt.Errorf("invalid jwt subject")
 	}
 
 	// check aud
 	if claims.Audience != s.config.Concurrent.FQDN {
-		return "", fmt.Errorf("jwt is not for this domain")
+		return "", "", fmt.Errorf("jwt is not for this domain")
+	}
+
+	// delegated keys are limited to their grant even if they claim to act for the principal
+	if grant, err := s.key.GetGrant(ctx, claims.Issuer); err == nil {
+		response, err := s.issueDelegatedToken(ctx, grant)
+		if err != nil {
+			span.RecordError(err)
+			return "", "", err
+		}
+		// delegated keys can sign a new claim by itself, so refresh token is not issued
+		return response, "", nil
+	}
+
+	// the issuer may be a subkey in the key chain of the principal
+	err = s.checkSigner(ctx, claims)
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
+	}
+	if claims.Principal != "" {
+		claims.Issuer = claims.Principal
 	}
 
 	// check if issuer exists in this domain
 	ent, err := s.entity.Get(ctx, claims.Issuer)
 	if err != nil {
 		span.RecordError(err)
-		return "", err
+		return "", "", err
 	}
 
 	// check if the entity is local user
 	if ent.Domain != "" {
-		return "", fmt.Errorf("requester is not a local user")
+		return "", "", fmt.Errorf("requester is not a local user")
+	}
+
+	response, err := s.issueToken(ent)
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
 	}
 
-	// create new jwt
-	response, err := util.CreateJWT(util.JwtClaims{
+	refreshToken, err := s.issueRefreshToken(ctx, ent.ID)
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
+	}
+
+	return response, refreshToken, nil
+}
+
+// Refresh takes refresh token and returns new server signed JWT and rotated refresh token
+// If a refresh token which is already rotated is used again, all tokens of the entity are revoked
+func (s *service) Refresh(ctx context.Context, refreshToken string) (string, string, error) {
+	ctx, span := tracer.Start(ctx, "ServiceRefresh")
+	defer span.End()
+
+	ccid, err := s.rdb.GetDel(ctx, "refresh:"+refreshToken).Result()
+	if err == redis.Nil {
+		// reuse of rotated token means the token may be leaked
+		leaked, err := s.rdb.Get(ctx, "refreshused:"+refreshToken).Result()
+		if err == nil {
+			err = s.RevokeTokens(ctx, leaked)
+			if err != nil {
+				span.RecordError(err)
+			}
+		}
+		return "", "", fmt.Errorf("invalid refresh token")
+	}
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
+	}
+
+	err = s.rdb.Set(ctx, "refreshused:"+refreshToken, ccid, s.config.Server.RefreshTokenLifetime).Err()
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
+	}
+	s.rdb.SRem(ctx, "refreshtokens:"+ccid, refreshToken)
+
+	ent, err := s.entity.Get(ctx, ccid)
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
+	}
+	if ent.Domain != "" {
+		return "", "", fmt.Errorf("requester is not a local user")
+	}
+
+	response, err := s.issueToken(ent)
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
+	}
+
+	rotated, err := s.issueRefreshToken(ctx, ccid)
+	if err != nil {
+		span.RecordError(err)
+		return "", "", err
+	}
+
+	return response, rotated, nil
+}
+
+// issueToken creates new server signed jwt for the entity
+func (s *service) issueToken(ent core.Entity) (string, error) {
+	return util.CreateJWT(util.JwtClaims{
 		Issuer:         s.config.Concurrent.CCID,
 		Subject:        "CONCURRENT_API",
-		Audience:       claims.Issuer,
-		ExpirationTime: strconv.FormatInt(time.Now().Add(6*time.Hour).Unix(), 10),
+		Audience:       ent.ID,
+		ExpirationTime: strconv.FormatInt(time.Now().Add(s.config.Server.TokenLifetime).Unix(), 10),
 		IssuedAt:       strconv.FormatInt(time.Now().Unix(), 10),
 		JWTID:          xid.New().String(),
 		Tag:            ent.Tag,
 	}, s.config.Concurrent.PrivateKey)
+}
 
+// issueDelegatedToken creates new server signed jwt for the owner of the grant, limited to the granted scopes
+func (s *service) issueDelegatedToken(ctx context.Context, grant core.Grant) (string, error) {
+	ent, err := s.entity.Get(ctx, grant.Owner)
+	if err != nil {
+		return "", err
+	}
+	if ent.Domain != "" {
+		return "", fmt.Errorf("owner of the key is not a local user")
+	}
+
+	exp := time.Now().Add(s.config.Server.TokenLifetime)
+	if grant.ExpiresAt.Before(exp) {
+		exp = grant.ExpiresAt
+	}
+
+	return util.CreateJWT(util.JwtClaims{
+		Issuer:         s.config.Concurrent.CCID,
+		Subject:        "CONCURRENT_API",
+		Audience:       ent.ID,
+		ExpirationTime: strconv.FormatInt(exp.Unix(), 10),
+		IssuedAt:       strconv.FormatInt(time.Now().Unix(), 10),
+		JWTID:          xid.New().String(),
+		Scope:          strings.Join(grant.Scopes, " "),
+		Subkey:         grant.Subkey,
+	}, s.config.Concurrent.PrivateKey)
+}
+
+// issueRefreshToken creates new refresh token for the ccid
+func (s *service) issueRefreshToken(ctx context.Context, ccid string) (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
 	if err != nil {
-		span.RecordError(err)
 		return "", err
 	}
+	token := hex.EncodeToString(buf)
 
-	// TODO: register jti
+	lifetime := s.config.Server.RefreshTokenLifetime
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, "refresh:"+token, ccid, lifetime)
+	pipe.SAdd(ctx, "refreshtokens:"+ccid, token)
+	pipe.Expire(ctx, "refreshtokens:"+ccid, lifetime)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RevokeTokens invalidates all server issued jwt and refresh tokens of the ccid issued until now
+func (s *service) RevokeTokens(ctx context.Context, ccid string) error {
+	ctx, span := tracer.Start(ctx, "ServiceRevokeTokens")
+	defer span.End()
+
+	// tokens issued before revocation are expired after TokenLifetime, so the record is no longer needed after that
+	err := s.rdb.Set(ctx, "revoke:"+ccid, strconv.FormatInt(time.Now().UnixMilli(), 10), s.config.Server.TokenLifetime).Err()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	refreshTokens, err := s.rdb.SMembers(ctx, "refreshtokens:"+ccid).Result()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	keys := []string{"refreshtokens:" + ccid}
+	for _, token := range refreshTokens {
+		keys = append(keys, "refresh:"+token)
+	}
+	err = s.rdb.Del(ctx, keys...).Err()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// validateJWT checks jwt with util.ValidateJWT and rejects revoked jwt
+// Tokens of delegated keys are rejected once the grant is revoked or expired,
+// and their scopes are taken from the grant rather than the claims.
+func (s *service) validateJWT(ctx context.Context, jwt string) (util.JwtClaims, error) {
+	ctx, span := tracer.Start(ctx, "ServiceValidateJWT")
+	defer span.End()
+
+	claims, err := util.ValidateJWT(jwt)
+	if err != nil {
+		return claims, err
+	}
+
+	if claims.Subkey != "" || claims.Scope != "" {
+		grant, err := s.key.GetGrant(ctx, claims.Subkey)
+		if err != nil || grant.Owner != claims.Audience {
+			return claims, fmt.Errorf("jwt is revoked")
+		}
+		claims.Scope = strings.Join(grant.Scopes, " ")
+	}
+
+	// tokens signed by users themselves must be signed by a valid key
+	if claims.Issuer != s.config.Concurrent.CCID {
+		err = s.checkSigner(ctx, claims)
+		if err != nil {
+			span.RecordError(err)
+			return claims, err
+		}
+	}
+
+	if claims.Subject != "CONCURRENT_API" {
+		return claims, nil
+	}
+
+	// self signed tokens are also revoked with the tokens of the issuer
+	revokable := []string{claims.Audience}
+	if claims.Issuer != s.config.Concurrent.CCID && claims.Issuer != claims.Audience {
+		revokable = append(revokable, claims.Issuer)
+	}
+	for _, ccid := range revokable {
+		err = s.checkRevoked(ctx, ccid, claims.IssuedAt)
+		if err != nil {
+			span.RecordError(err)
+			return claims, err
+		}
+	}
+
+	return claims, nil
+}
+
+// checkSigner checks the key which signed the jwt can act for the entity
+// A subkey must be active in the key chain of the principal, and a master key must not be revoked.
+func (s *service) checkSigner(ctx context.Context, claims util.JwtClaims) error {
+	if claims.Principal != "" && claims.Principal != claims.Issuer {
+		active, err := s.key.ActiveKeys(ctx, claims.Principal)
+		if err != nil || !slices.Contains(active, claims.Issuer) {
+			return fmt.Errorf("issuer is not an active key of the principal")
+		}
+		return nil
+	}
+
+	chain, err := s.key.GetKeyChain(ctx, claims.Issuer)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// keys not registered here have no key chain
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, revoked := key.MasterRevokedAt(claims.Issuer, chain); revoked {
+		return fmt.Errorf("master key is revoked")
+	}
+	return nil
+}
+
+// checkRevoked returns error if tokens of the ccid issued at issuedAt are revoked
+func (s *service) checkRevoked(ctx context.Context, ccid string, issuedAt string) error {
+	revokedAt, err := s.rdb.Get(ctx, "revoke:"+ccid).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	revokedMilli, err := strconv.ParseInt(revokedAt, 10, 64)
+	if err != nil {
+		return err
+	}
+	issuedUnix, err := strconv.ParseInt(issuedAt, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid iat")
+	}
+	// iat has only second precision, so tokens issued in the same second as the revocation are also revoked
+	if issuedUnix <= revokedMilli/1000 {
+		return fmt.Errorf("jwt is revoked")
+	}
+
+	return nil
+}
+
+// trustIssuer checks the jwt is issued by this domain or by the home domain of the audience
+// The home domain must be known and not blocked.
+func (s *service) trustIssuer(ctx context.Context, claims util.JwtClaims) error {
+	if claims.Issuer == s.config.Concurrent.CCID {
+		return nil
+	}
+
+	domain, err := s.domain.GetByCCID(ctx, claims.Issuer)
+	if err != nil {
+		return fmt.Errorf("jwt is issued by unknown domain")
+	}
+	if slices.Contains(strings.Split(domain.Tag, ","), "_blocked") {
+		return fmt.Errorf("jwt is issued by blocked domain")
+	}
+
+	home, err := s.entity.ResolveHost(ctx, claims.Audience)
+	if err != nil {
+		return fmt.Errorf("audience of jwt is not known")
+	}
+	if home != domain.ID {
+		return fmt.Errorf("jwt is not issued by the home domain of the audience")
+	}
+
+	return nil
+}
+
+// Identify validates the jwt and returns its claims only if the identity can be trusted
+// Tokens must be issued by this domain or by a known domain which is not blocked,
+// so that users of other domains can read with the identity their home domain vouches for.
+// A domain can only vouch for the entities homed on it,
+// and tokens of delegated keys are not trusted for reads as no scope grants them.
+func (s *service) Identify(ctx context.Context, jwt string) (util.JwtClaims, error) {
+	ctx, span := tracer.Start(ctx, "ServiceIdentify")
+	defer span.End()
+
+	claims, err := s.validateJWT(ctx, jwt)
+	if err != nil {
+		span.RecordError(err)
+		return claims, err
+	}
+
+	if claims.Subject != "CONCURRENT_API" {
+		return claims, fmt.Errorf("invalid jwt subject")
+	}
+
+	if claims.Scope != "" {
+		return claims, fmt.Errorf("jwt of delegated key cannot identify the reader")
+	}
+
+	err = s.trustIssuer(ctx, claims)
+	if err != nil {
+		span.RecordError(err)
+		return claims, err
+	}
 
-	return response, nil
+	return claims, nil
 }
@@ -0,0 +1,463 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/util"
+	"gorm.io/gorm"
+)
+
+// memoryRedis answers the commands used by the auth service without a redis server
+type memoryRedis struct {
+	values map[string]string
+	sets   map[string]map[string]bool
+}
+
+func (m *memoryRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, fmt.Errorf("memory redis does not dial")
+	}
+}
+
+func (m *memoryRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		m.process(cmd)
+		return cmd.Err()
+	}
+}
+
+func (m *memoryRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			m.process(cmd)
+		}
+		return nil
+	}
+}
+
+func (m *memoryRedis) process(cmd redis.Cmder) {
+	args := []string{}
+	for _, arg := range cmd.Args() {
+		args = append(args, fmt.Sprint(arg))
+	}
+	switch cmd.Name() {
+	case "set":
+		_, exists := m.values[args[1]]
+		nx := args[len(args)-1] == "nx"
+		if !nx || !exists {
+			m.values[args[1]] = args[2]
+		}
+		switch c := cmd.(type) {
+		case *redis.BoolCmd:
+			c.SetVal(!exists)
+		case *redis.StatusCmd:
+			c.SetVal("OK")
+		}
+	case "get", "getdel":
+		value, ok := m.values[args[1]]
+		if !ok {
+			cmd.SetErr(redis.Nil)
+			return
+		}
+		if cmd.Name() == "getdel" {
+			delete(m.values, args[1])
+		}
+		cmd.(*redis.StringCmd).SetVal(value)
+	case "del":
+		for _, k := range args[1:] {
+			delete(m.values, k)
+			delete(m.sets, k)
+		}
+	case "sadd":
+		if m.sets[args[1]] == nil {
+			m.sets[args[1]] = map[string]bool{}
+		}
+		for _, member := range args[2:] {
+			m.sets[args[1]][member] = true
+		}
+	case "srem":
+		for _, member := range args[2:] {
+			delete(m.sets[args[1]], member)
+		}
+	case "smembers":
+		members := []string{}
+		for member := range m.sets[args[1]] {
+			members = append(members, member)
+		}
+		cmd.(*redis.StringSliceCmd).SetVal(members)
+	}
+}
+
+type fakeEntity struct {
+	entity.Service
+	entities map[string]core.Entity
+}
+
+func (f fakeEntity) Get(ctx context.Context, ccid string) (core.Entity, error) {
+	ent, ok := f.entities[ccid]
+	if !ok {
+		return core.Entity{}, gorm.ErrRecordNotFound
+	}
+	return ent, nil
+}
+
+func (f fakeEntity) ResolveHost(ctx context.Context, ccid string) (string, error) {
+	ent, err := f.Get(ctx, ccid)
+	if err != nil {
+		return "", err
+	}
+	if ent.Domain == "" {
+		return "local.example.com", nil
+	}
+	return ent.Domain, nil
+}
+
+type fakeDomain struct {
+	domain.Service
+	domains map[string]core.Domain
+}
+
+func (f fakeDomain) GetByCCID(ctx context.Context, ccid string) (core.Domain, error) {
+	domain, ok := f.domains[ccid]
+	if !ok {
+		return core.Domain{}, gorm.ErrRecordNotFound
+	}
+	return domain, nil
+}
+
+type fakeKey struct {
+	key.Service
+	grants map[string]core.Grant
+	chains map[string][]key.KeyChainEntry
+}
+
+func (f fakeKey) GetGrant(ctx context.Context, subkey string) (core.Grant, error) {
+	grant, ok := f.grants[subkey]
+	if !ok {
+		return core.Grant{}, gorm.ErrRecordNotFound
+	}
+	return grant, nil
+}
+
+func (f fakeKey) GetKeyChain(ctx context.Context, ccid string) ([]key.KeyChainEntry, error) {
+	chain, ok := f.chains[ccid]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return chain, nil
+}
+
+type testKey struct {
+	privatekey string
+	ccid       string
+}
+
+func newTestKey(t *testing.T) testKey {
+	privatekey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{
+		privatekey: hex.EncodeToString(crypto.FromECDSA(privatekey)),
+		ccid:       "CC" + crypto.PubkeyToAddress(privatekey.PublicKey).Hex()[2:],
+	}
+}
+
+type testEnv struct {
+	service *service
+	redis   *memoryRedis
+	server  testKey
+	user    testKey
+	keys    *fakeKey
+}
+
+func newTestEnv(t *testing.T) testEnv {
+	server := newTestKey(t)
+	user := newTestKey(t)
+
+	memory := &memoryRedis{values: map[string]string{}, sets: map[string]map[string]bool{}}
+	rdb := redis.NewClient(&redis.Options{})
+	rdb.AddHook(memory)
+
+	config := util.Config{
+		Server: util.Server{
+			TokenLifetime:        time.Hour,
+			RefreshTokenLifetime: 24 * time.Hour,
+		},
+		Concurrent: util.Concurrent{
+			FQDN:       "local.example.com",
+			CCID:       server.ccid,
+			PrivateKey: server.privatekey,
+		},
+	}
+
+	entities := fakeEntity{entities: map[string]core.Entity{
+		user.ccid: {ID: user.ccid},
+	}}
+	keys := &fakeKey{grants: map[string]core.Grant{}, chains: map[string][]key.KeyChainEntry{}}
+
+	return testEnv{
+		service: &service{rdb, config, entities, fakeDomain{domains: map[string]core.Domain{}}, keys},
+		redis:   memory,
+		server:  server,
+		user:    user,
+		keys:    keys,
+	}
+}
+
+func claim(t *testing.T, signer testKey, claims util.JwtClaims) string {
+	if claims.Issuer == "" {
+		claims.Issuer = signer.ccid
+	}
+	claims.IssuedAt = strconv.FormatInt(time.Now().Unix(), 10)
+	claims.ExpirationTime = strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	jwt, err := util.CreateJWT(claims, signer.privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwt
+}
+
+func apiClaim(t *testing.T, signer testKey, jti string) string {
+	return claim(t, signer, util.JwtClaims{
+		Subject:  "CONCURRENT_APICLAIM",
+		Audience: "local.example.com",
+		JWTID:    jti,
+	})
+}
+
+func revokeMasterEntry(ccid string, by string, at time.Time) key.KeyChainEntry {
+	return key.KeyChainEntry{
+		SignedObject: fmt.Sprintf(`{"signer":"%s","keyID":"%s","type":"revoke","target":"%s","signedAt":"%s"}`, ccid, by, ccid, at.Format(time.RFC3339)),
+	}
+}
+
+func TestIssueJWTReplay(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	token, refresh, err := env.service.IssueJWT(ctx, apiClaim(t, env.user, "claim1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refresh == "" {
+		t.Error("refresh token is not issued")
+	}
+	claims, err := env.service.validateJWT(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != env.server.ccid || claims.Audience != env.user.ccid {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	_, _, err = env.service.IssueJWT(ctx, apiClaim(t, env.user, "claim1"))
+	if err == nil {
+		t.Error("expected error for replayed jti")
+	}
+
+	// request ids of other issuers do not collide
+	other := newTestKey(t)
+	env.service.entity.(fakeEntity).entities[other.ccid] = core.Entity{ID: other.ccid}
+	_, _, err = env.service.IssueJWT(ctx, apiClaim(t, other, "claim1"))
+	if err != nil {
+		t.Errorf("jti of another issuer is rejected: %v", err)
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	token, refresh, err := env.service.IssueJWT(ctx, apiClaim(t, env.user, "claim1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens signed by the user are revoked with the server issued ones
+	selfSigned := claim(t, env.user, util.JwtClaims{Subject: "CONCURRENT_API", Audience: env.user.ccid})
+	if _, err := env.service.validateJWT(ctx, selfSigned); err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.service.RevokeTokens(ctx, env.user.ccid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, jwt := range []string{token, selfSigned} {
+		_, err = env.service.validateJWT(ctx, jwt)
+		if err == nil {
+			t.Error("revoked jwt is accepted")
+		}
+	}
+	_, _, err = env.service.Refresh(ctx, refresh)
+	if err == nil {
+		t.Error("revoked refresh token is accepted")
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	_, refresh, err := env.service.IssueJWT(ctx, apiClaim(t, env.user, "claim1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, rotated, err := env.service.Refresh(ctx, refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == "" || rotated == refresh {
+		t.Fatalf("refresh token is not rotated: %v", rotated)
+	}
+	if _, err := env.service.validateJWT(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	// reuse of the rotated token revokes every token of the entity
+	_, _, err = env.service.Refresh(ctx, refresh)
+	if err == nil {
+		t.Fatal("rotated refresh token is accepted again")
+	}
+	if _, ok := env.redis.values["revoke:"+env.user.ccid]; !ok {
+		t.Error("tokens are not revoked on reuse")
+	}
+	_, _, err = env.service.Refresh(ctx, rotated)
+	if err == nil {
+		t.Error("refresh token issued before reuse is still accepted")
+	}
+}
+
+func TestRevokedMasterKey(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	sub := newTestKey(t)
+	env.keys.chains[env.user.ccid] = []key.KeyChainEntry{revokeMasterEntry(env.user.ccid, sub.ccid, time.Now())}
+
+	_, _, err := env.service.IssueJWT(ctx, apiClaim(t, env.user, "claim1"))
+	if err == nil {
+		t.Error("claim of revoked master key is accepted")
+	}
+
+	selfSigned := claim(t, env.user, util.JwtClaims{Subject: "CONCURRENT_API", Audience: env.user.ccid})
+	_, err = env.service.validateJWT(ctx, selfSigned)
+	if err == nil {
+		t.Error("jwt of revoked master key is accepted")
+	}
+}
+
+func TestDelegatedToken(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	sub := newTestKey(t)
+	env.keys.grants[sub.ccid] = core.Grant{
+		Owner:     env.user.ccid,
+		Subkey:    sub.ccid,
+		Scopes:    []string{key.ScopeKVRead + ":foo"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	// the grant limits the token even when the sub-key claims to act for the owner
+	request := claim(t, sub, util.JwtClaims{
+		Subject:   "CONCURRENT_APICLAIM",
+		Audience:  "local.example.com",
+		JWTID:     "claim1",
+		Principal: env.user.ccid,
+	})
+	token, refresh, err := env.service.IssueJWT(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refresh != "" {
+		t.Error("refresh token is issued for delegated key")
+	}
+	claims, err := env.service.validateJWT(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subkey != sub.ccid || claims.Scope != key.ScopeKVRead+":foo" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	// scopes are taken from the grant, not from the claims
+	forged := claim(t, env.server, util.JwtClaims{
+		Subject:  "CONCURRENT_API",
+		Audience: env.user.ccid,
+		Subkey:   sub.ccid,
+		Scope:    key.ScopeMessagePost,
+	})
+	claims, err = env.service.validateJWT(ctx, forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Scope != key.ScopeKVRead+":foo" {
+		t.Errorf("scope of the claims is trusted: %v", claims.Scope)
+	}
+
+	delete(env.keys.grants, sub.ccid)
+	_, err = env.service.validateJWT(ctx, token)
+	if err == nil {
+		t.Error("token of revoked grant is accepted")
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	env := newTestEnv(t)
+	other := newTestKey(t)
+
+	cases := []struct {
+		name      string
+		claims    util.JwtClaims
+		principal Principal
+		scopes    []string
+		param     string
+		expect    int
+	}{
+		{"local token", util.JwtClaims{Issuer: env.server.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid}, ISLOCAL, nil, "", http.StatusOK},
+		{"self signed token", util.JwtClaims{Issuer: other.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid}, ISLOCAL, nil, "", http.StatusForbidden},
+		{"self signed admin", util.JwtClaims{Issuer: env.user.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid, Tag: "_admin"}, ISADMIN, nil, "", http.StatusForbidden},
+		{"unknown domain", util.JwtClaims{Issuer: other.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid}, ISKNOWN, nil, "", http.StatusForbidden},
+		{"in scope", util.JwtClaims{Issuer: env.server.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid, Subkey: other.ccid, Scope: key.ScopeKVRead + ":foo"}, ISLOCAL, []string{key.ScopeKVRead}, "foo", http.StatusOK},
+		{"out of target", util.JwtClaims{Issuer: env.server.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid, Subkey: other.ccid, Scope: key.ScopeKVRead + ":foo"}, ISLOCAL, []string{key.ScopeKVRead}, "bar", http.StatusForbidden},
+		{"collection in scope", util.JwtClaims{Issuer: env.server.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid, Subkey: other.ccid, Scope: key.ScopeCollectionWrite + ":foo"}, ISLOCAL, []string{key.ScopeCollectionWrite}, "foo", http.StatusOK},
+		{"collection out of target", util.JwtClaims{Issuer: env.server.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid, Subkey: other.ccid, Scope: key.ScopeCollectionWrite + ":foo"}, ISLOCAL, []string{key.ScopeCollectionWrite}, "bar", http.StatusForbidden},
+		{"unscoped route", util.JwtClaims{Issuer: env.server.ccid, Subject: "CONCURRENT_API", Audience: env.user.ccid, Subkey: other.ccid, Scope: key.ScopeKVRead}, ISLOCAL, nil, "", http.StatusForbidden},
+	}
+
+	e := echo.New()
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		ctx.SetParamNames("key", "collection")
+		ctx.SetParamValues(c.param, c.param)
+		ctx.Set("jwtclaims", c.claims)
+
+		handler := env.service.Restrict(c.principal, c.scopes...)(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		err := handler(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != c.expect {
+			t.Errorf("%s: expected %d, got %d", c.name, c.expect, rec.Code)
+		}
+	}
+}
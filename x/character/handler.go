@@ -4,6 +4,7 @@ package character
 import (
 	"errors"
 	"github.com/labstack/echo/v4"
+	"github.com/totegamma/concurrent/x/util"
 	"go.opentelemetry.io/otel"
 	"gorm.io/gorm"
 	"net/http"
@@ -15,6 +16,7 @@ var tracer = otel.Tracer("character")
 type Handler interface {
     Get(c echo.Context) error
     Put(c echo.Context) error
+    Invalidate(c echo.Context) error
 }
 
 type handler struct {
@@ -27,6 +29,7 @@ func NewHandler(service Service) Handler {
 }
 
 // Get returns a character by ID
+// If no character is stored here and the host query is given, they are fetched from the host.
 func (h handler) Get(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerGet")
 	defer span.End()
@@ -34,6 +37,15 @@ func (h handler) Get(c echo.Context) error {
 	author := c.QueryParam("author")
 	schema := c.QueryParam("schema")
 	characters, err := h.service.GetCharacters(ctx, author, schema)
+	if err == nil && len(characters) == 0 && c.QueryParam("host") != "" {
+		characters, err = h.service.GetRemote(ctx, c.QueryParam("host"), author, schema)
+		if errors.Is(err, util.ErrRemoteNotFound) || errors.Is(err, util.ErrUnknownRemote) {
+			err = gorm.ErrRecordNotFound
+		} else if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusBadGateway, echo.Map{"error": err.Error()})
+		}
+	}
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Character not found"})
@@ -63,3 +75,29 @@ func (h handler) Put(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": updated})
 }
+
+// Invalidate drops the cached copy of remote characters
+// Called by the home domain of the characters when they are updated.
+// Only the copy cached from the requesting domain is dropped.
+func (h handler) Invalidate(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerInvalidate")
+	defer span.End()
+
+	var request invalidateRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	host, ok := c.Get("requesterHost").(string)
+	if !ok {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "requesting domain is not known"})
+	}
+
+	err = h.service.InvalidateRemote(ctx, host, request.Author, request.Schema)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
@@ -10,6 +10,11 @@ type CharactersResponse struct {
 	Characters []core.Character `json:"characters"`
 }
 
+type invalidateRequest struct {
+	Author string `json:"author"`
+	Schema string `json:"schema"`
+}
+
 type postRequest struct {
 	SignedObject string `json:"signedObject"`
 	Signature    string `json:"signature"`
@@ -18,6 +23,7 @@ type postRequest struct {
 
 type signedObject struct {
 	Signer   string      `json:"signer"`
+	KeyID    string      `json:"keyID,omitempty"`
 	Type     string      `json:"type"`
 	Schema   string      `json:"schema"`
 	Body     interface{} `json:"body"`
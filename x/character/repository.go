@@ -10,6 +10,7 @@ import (
 type Repository interface {
     Upsert(ctx context.Context, character core.Character) error
     Get(ctx context.Context, owner string, schema string) ([]core.Character, error)
+    GetByOwners(ctx context.Context, owners []string, schema string) ([]core.Character, error)
 }
 
 type repository struct {
@@ -42,3 +43,13 @@ func (r *repository) Get(ctx context.Context, owner string, schema string) ([]co
 	}
 	return characters, nil
 }
+
+// GetByOwners returns characters of the schema owned by any of owners
+func (r *repository) GetByOwners(ctx context.Context, owners []string, schema string) ([]core.Character, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetByOwners")
+	defer span.End()
+
+	var characters []core.Character
+	err := r.db.WithContext(ctx).Where("author IN ? AND schema = ?", owners, schema).Find(&characters).Error
+	return characters, err
+}
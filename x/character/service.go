@@ -3,23 +3,39 @@ package character
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
 	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/outbox"
 	"github.com/totegamma/concurrent/x/util"
+	"golang.org/x/exp/slices"
 )
 
 // Service is the interface for character service
 type Service interface {
     GetCharacters(ctx context.Context, owner string, schema string) ([]core.Character, error)
+    GetCharactersByOwners(ctx context.Context, owners []string, schema string) ([]core.Character, error)
     PutCharacter(ctx context.Context, objectStr string, signature string, id string) (core.Character, error)
+    GetRemote(ctx context.Context, host string, owner string, schema string) ([]core.Character, error)
+    InvalidateRemote(ctx context.Context, host string, owner string, schema string) error
 }
 
 type service struct {
-	repo Repository
+	rdb    *redis.Client
+	repo   Repository
+	key    key.Service
+	domain domain.Service
+	outbox outbox.Service
 }
 
 // NewService creates a new character service
-func NewService(repo Repository) Service {
-	return &service{repo: repo}
+func NewService(rdb *redis.Client, repo Repository, key key.Service, domain domain.Service, outbox outbox.Service) Service {
+	return &service{rdb: rdb, repo: repo, key: key, domain: domain, outbox: outbox}
 }
 
 // GetCharacters returns characters by owner and schema
@@ -47,7 +63,7 @@ func (s *service) PutCharacter(ctx context.Context, objectStr string, signature
 		return core.Character{}, err
 	}
 
-	if err := util.VerifySignature(objectStr, object.Signer, signature); err != nil {
+	if err := s.key.VerifySignature(ctx, objectStr, object.Signer, object.KeyID, signature, key.ScopeCharacterPut, []string{object.Schema}); err != nil {
 		span.RecordError(err)
 		return core.Character{}, err
 	}
@@ -66,5 +82,104 @@ func (s *service) PutCharacter(ctx context.Context, objectStr string, signature
 		return core.Character{}, err
 	}
 
+	s.notifyRemote(ctx, character)
+
 	return character, nil
 }
+
+// notifyRemote asks other domains to drop their cached copy of the characters
+// Which domains fetched them is not tracked, so every known domain is asked.
+func (s *service) notifyRemote(ctx context.Context, character core.Character) {
+	ctx, span := tracer.Start(ctx, "ServiceNotifyRemote")
+	defer span.End()
+
+	domains, err := s.domain.List(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+	for _, domain := range domains {
+		if slices.Contains(strings.Split(domain.Tag, ","), "_blocked") {
+			continue
+		}
+		err := s.outbox.Enqueue(ctx, domain.ID, "/character/invalidate", invalidateRequest{Author: character.Author, Schema: character.Schema})
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+}
+
+// GetCharactersByOwners returns characters of the schema owned by any of owners
+func (s *service) GetCharactersByOwners(ctx context.Context, owners []string, schema string) ([]core.Character, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetCharactersByOwners")
+	defer span.End()
+
+	return s.repo.GetByOwners(ctx, owners, schema)
+}
+
+// GetRemote returns characters of an entity living in another domain
+// Fetched characters are verified and cached for util.RemoteCacheTTL
+func (s *service) GetRemote(ctx context.Context, host string, owner string, schema string) ([]core.Character, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetRemote")
+	defer span.End()
+
+	err := s.domain.CheckFetchable(ctx, host)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	cacheKey := remoteCacheKey(host, owner, schema)
+
+	var response CharactersResponse
+
+	cached, err := s.rdb.Get(ctx, cacheKey).Result()
+	if err == nil {
+		err = json.Unmarshal([]byte(cached), &response)
+		if err == nil {
+			return response.Characters, nil
+		}
+	}
+
+	query := url.Values{"author": {owner}, "schema": {schema}}
+	body, err := util.FetchRemote(ctx, host, "/characters?"+query.Encode(), "", &response)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	for _, character := range response.Characters {
+		var object signedObject
+		err = json.Unmarshal([]byte(character.Payload), &object)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		if object.Signer != owner || character.Author != owner || object.Schema != schema || character.Schema != schema {
+			err = fmt.Errorf("character %s does not match the query", character.ID)
+			span.RecordError(err)
+			return nil, err
+		}
+		err = s.key.VerifyRemoteSignature(ctx, host, character.Payload, object.Signer, object.KeyID, character.Signature)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	s.rdb.Set(ctx, cacheKey, body, util.RemoteCacheTTL)
+
+	return response.Characters, nil
+}
+
+// InvalidateRemote drops the characters cached from the host
+func (s *service) InvalidateRemote(ctx context.Context, host string, owner string, schema string) error {
+	ctx, span := tracer.Start(ctx, "ServiceInvalidateRemote")
+	defer span.End()
+
+	return s.rdb.Del(ctx, remoteCacheKey(host, owner, schema)).Err()
+}
+
+func remoteCacheKey(host string, owner string, schema string) string {
+	return "character:remote:" + host + ":" + owner + ":" + schema
+}
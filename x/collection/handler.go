@@ -1,10 +1,12 @@
 package collection
 
 import (
+	"errors"
 	"github.com/labstack/echo/v4"
 	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/util"
 	"go.opentelemetry.io/otel"
-	"io/ioutil"
+	"gorm.io/gorm"
 	"log"
 	"net/http"
 )
@@ -15,8 +17,10 @@ var tracer = otel.Tracer("collection")
 type Handler interface {
 	CreateCollection(c echo.Context) error
 	GetCollection(c echo.Context) error
+	ListCollections(c echo.Context) error
 	UpdateCollection(c echo.Context) error
 	DeleteCollection(c echo.Context) error
+	TransferCollection(c echo.Context) error
 
 	CreateItem(c echo.Context) error
 	GetItem(c echo.Context) error
@@ -40,35 +44,71 @@ func (h *handler) CreateCollection(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerCreateCollection")
 	defer span.End()
 
-	var data core.Collection
-	err := c.Bind(&data)
+	var request postRequest
+	err := c.Bind(&request)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
 	}
 
-	created, err := h.service.CreateCollection(ctx, data)
+	created, err := h.service.CreateCollection(ctx, request.SignedObject, request.Signature, requester(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusCreated, echo.Map{"status": "ok", "content": created})
 }
 
 // GetCollection returns a collection by ID
+// If the collection is not stored here and the host query is given, it is fetched from the host.
 func (h *handler) GetCollection(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerGetCollection")
 	defer span.End()
 
 	id := c.Param("id")
 
-	data, err := h.service.GetCollection(ctx, id)
+	data, err := h.service.GetCollection(ctx, id, requester(c))
+	if errors.Is(err, gorm.ErrRecordNotFound) && c.QueryParam("host") != "" {
+		data, err = h.service.GetRemote(ctx, c.QueryParam("host"), id, requester(c))
+		if err != nil {
+			span.RecordError(err)
+			if errors.Is(err, util.ErrRemoteNotFound) || errors.Is(err, util.ErrUnknownRemote) {
+				return c.JSON(http.StatusNotFound, echo.Map{"status": "error", "message": "not found"})
+			}
+			return c.JSON(http.StatusBadGateway, echo.Map{"status": "error", "message": err.Error()})
+		}
+	}
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": data})
 }
 
+// ListCollections returns collections filtered by author or schema
+func (h *handler) ListCollections(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerListCollections")
+	defer span.End()
+
+	author := c.QueryParam("author")
+	schema := c.QueryParam("schema")
+
+	var list []core.Collection
+	var err error
+	switch {
+	case author != "":
+		list, err = h.service.ListCollectionsByAuthor(ctx, author, requester(c))
+	case schema != "":
+		list, err = h.service.ListCollectionsBySchema(ctx, schema, requester(c))
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"status": "error", "message": "author or schema is required"})
+	}
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": list})
+}
+
 // UpdateCollection updates a collection
 func (h *handler) UpdateCollection(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerUpdateCollection")
@@ -76,17 +116,15 @@ func (h *handler) UpdateCollection(c echo.Context) error {
 
 	id := c.Param("id")
 
-	collection := core.Collection{}
-	err := c.Bind(&collection)
+	var request postRequest
+	err := c.Bind(&request)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
 	}
 
-	collection.ID = id
-
-	updated, err := h.service.UpdateCollection(ctx, collection)
+	updated, err := h.service.UpdateCollection(ctx, id, request.SignedObject, request.Signature, requester(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": updated})
@@ -99,14 +137,35 @@ func (h *handler) DeleteCollection(c echo.Context) error {
 
 	id := c.Param("id")
 
-	err := h.service.DeleteCollection(ctx, id)
+	err := h.service.DeleteCollection(ctx, id, requester(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
 }
 
+// TransferCollection hands the collection over to another entity
+func (h *handler) TransferCollection(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerTransferCollection")
+	defer span.End()
+
+	id := c.Param("id")
+
+	var request transferRequest
+	err := c.Bind(&request)
+	if err != nil || request.To == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"status": "error", "message": "Invalid request"})
+	}
+
+	transferred, err := h.service.TransferCollection(ctx, id, request.To, request.SignedObject, request.Signature, requester(c))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": transferred})
+}
+
 // CreateItem creates a new item
 func (h *handler) CreateItem(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerCreateItem")
@@ -114,19 +173,15 @@ func (h *handler) CreateItem(c echo.Context) error {
 
 	collectionID := c.Param("collection")
 
-	body := c.Request().Body
-	bytes, err := ioutil.ReadAll(body)
+	var request postRequest
+	err := c.Bind(&request)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{"status": "error", "message": err.Error()})
 	}
-	value := string(bytes)
 
-	created, err := h.service.CreateItem(ctx, core.CollectionItem{
-		Collection: collectionID,
-		Payload:    value,
-	})
+	created, err := h.service.CreateItem(ctx, collectionID, request.SignedObject, request.Signature, requester(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusCreated, echo.Map{"status": "ok", "content": created})
@@ -138,11 +193,11 @@ func (h *handler) GetItem(c echo.Context) error {
 	defer span.End()
 
 	collectionID := c.Param("collection")
-	id := c.Param("id")
+	itemID := c.Param("item")
 
-	data, err := h.service.GetItem(ctx, collectionID, id)
+	data, err := h.service.GetItem(ctx, collectionID, itemID, requester(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": data})
@@ -156,21 +211,15 @@ func (h *handler) UpdateItem(c echo.Context) error {
 	collectionID := c.Param("collection")
 	itemID := c.Param("item")
 
-	body := c.Request().Body
-	bytes, err := ioutil.ReadAll(body)
+	var request postRequest
+	err := c.Bind(&request)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{"status": "error", "message": err.Error()})
 	}
-	value := string(bytes)
-
-	updated, err := h.service.UpdateItem(ctx, core.CollectionItem{
-		ID:         itemID,
-		Collection: collectionID,
-		Payload:    value,
-	})
 
+	updated, err := h.service.UpdateItem(ctx, collectionID, itemID, request.SignedObject, request.Signature, requester(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": updated})
@@ -186,10 +235,33 @@ func (h *handler) DeleteItem(c echo.Context) error {
 
 	log.Println("Delete item", collectionID, itemID)
 
-	deleted, err := h.service.DeleteItem(ctx, collectionID, itemID)
+	deleted, err := h.service.DeleteItem(ctx, collectionID, itemID, requester(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": deleted})
 }
+
+// requester returns the entity of the request, or empty string for anonymous requests
+func requester(c echo.Context) string {
+	claims, ok := c.Get("jwtclaims").(util.JwtClaims)
+	if !ok || claims.Subject != "CONCURRENT_API" {
+		return ""
+	}
+	return claims.Audience
+}
+
+// errorResponse maps service errors to the status codes
+func errorResponse(c echo.Context, err error) error {
+	if errors.Is(err, ErrPermissionDenied) {
+		return c.JSON(http.StatusForbidden, echo.Map{"status": "error", "message": err.Error()})
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"status": "error", "message": "not found"})
+	}
+	if errors.Is(err, ErrInvalidObject) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"status": "error", "message": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{"status": "error", "message": err.Error()})
+}
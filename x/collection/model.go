@@ -0,0 +1,53 @@
+package collection
+
+import (
+	"time"
+
+	"github.com/totegamma/concurrent/x/core"
+)
+
+type postRequest struct {
+	SignedObject string `json:"signedObject"`
+	Signature    string `json:"signature"`
+}
+
+// signedObject is the user signed content of a collection
+// ID must be empty on creation and be the target collection on update.
+type signedObject struct {
+	Signer     string      `json:"signer"`
+	KeyID      string      `json:"keyID,omitempty"`
+	ID         string      `json:"id,omitempty"`
+	Type       string      `json:"type"`
+	Schema     string      `json:"schema"`
+	Body       interface{} `json:"body"`
+	Meta       interface{} `json:"meta"`
+	SignedAt   time.Time   `json:"signedAt"`
+	Maintainer []string    `json:"maintainer"`
+	Writer     []string    `json:"writer"`
+	Reader     []string    `json:"reader"`
+	Visible    bool        `json:"visible"`
+}
+
+// itemSignedObject is the user signed content of a collection item
+// Collection binds the item to the collection so that it cannot be replayed into another one.
+type itemSignedObject struct {
+	Signer     string      `json:"signer"`
+	KeyID      string      `json:"keyID,omitempty"`
+	ID         string      `json:"id,omitempty"`
+	Collection string      `json:"collection"`
+	Type       string      `json:"type"`
+	Body       interface{} `json:"body"`
+	Meta       interface{} `json:"meta"`
+	SignedAt   time.Time   `json:"signedAt"`
+}
+
+type collectionResponse struct {
+	Content core.Collection `json:"content"`
+}
+
+// transferRequest carries the collection re-signed by the author with the access lists after the transfer
+type transferRequest struct {
+	To           string `json:"to"`
+	SignedObject string `json:"signedObject"`
+	Signature    string `json:"signature"`
+}
@@ -10,6 +10,8 @@ import (
 type Repository interface {
 	CreateCollection(ctx context.Context, obj core.Collection) (core.Collection, error)
 	GetCollection(ctx context.Context, id string) (core.Collection, error)
+	ListCollectionsByAuthor(ctx context.Context, author string) ([]core.Collection, error)
+	ListCollectionsBySchema(ctx context.Context, schema string) ([]core.Collection, error)
 	UpdateCollection(ctx context.Context, obj core.Collection) (core.Collection, error)
 	DeleteCollection(ctx context.Context, id string) error
 
@@ -42,6 +44,26 @@ func (r *repository) GetCollection(ctx context.Context, id string) (core.Collect
 	return obj, r.db.WithContext(ctx).Preload("Items").First(&obj, "id = ?", id).Error
 }
 
+// ListCollectionsByAuthor returns collections of the author without items
+func (r *repository) ListCollectionsByAuthor(ctx context.Context, author string) ([]core.Collection, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryListCollectionsByAuthor")
+	defer span.End()
+
+	var collections []core.Collection
+	err := r.db.WithContext(ctx).Where("author = ?", author).Find(&collections).Error
+	return collections, err
+}
+
+// ListCollectionsBySchema returns visible collections of the schema without items
+func (r *repository) ListCollectionsBySchema(ctx context.Context, schema string) ([]core.Collection, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryListCollectionsBySchema")
+	defer span.End()
+
+	var collections []core.Collection
+	err := r.db.WithContext(ctx).Where("schema = ? and visible = true", schema).Find(&collections).Error
+	return collections, err
+}
+
 // UpdateCollection updates a collection
 func (r *repository) UpdateCollection(ctx context.Context, obj core.Collection) (core.Collection, error) {
 	ctx, span := tracer.Start(ctx, "RepositoryUpdateCollection")
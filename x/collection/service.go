@@ -2,105 +2,565 @@ package collection
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/xid"
 	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/util"
+	"golang.org/x/exp/slices"
 )
 
+// ErrPermissionDenied is returned when the requester is not allowed to perform the operation
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrInvalidObject is returned when the signed object is malformed or its signature is invalid
+var ErrInvalidObject = errors.New("invalid signed object")
+
+// IsMaintainer reports whether the entity can maintain the collection
+// The author is always a maintainer.
+func IsMaintainer(collection core.Collection, entity string) bool {
+	if entity == "" {
+		return false
+	}
+	return collection.Author == entity || slices.Contains(collection.Maintainer, entity)
+}
+
+// CanWrite reports whether the entity can add, update and delete items of the collection
+// Unlike streams, an empty writer list means only the maintainers can write.
+func CanWrite(collection core.Collection, entity string) bool {
+	return IsMaintainer(collection, entity) || (entity != "" && slices.Contains(collection.Writer, entity))
+}
+
+// CanRead reports whether the entity can read the collection
+// An empty reader list means everyone can read. Writers and maintainers can always read.
+func CanRead(collection core.Collection, entity string) bool {
+	if len(collection.Reader) == 0 {
+		return true
+	}
+	return CanWrite(collection, entity) || (entity != "" && slices.Contains(collection.Reader, entity))
+}
+
 // Repository is the interface for collection repository
 type Service interface {
-	CreateCollection(ctx context.Context, obj core.Collection) (core.Collection, error)
-	GetCollection(ctx context.Context, id string) (core.Collection, error)
-	UpdateCollection(ctx context.Context, obj core.Collection) (core.Collection, error)
-	DeleteCollection(ctx context.Context, id string) error
+	CreateCollection(ctx context.Context, objectStr string, signature string, requester string) (core.Collection, error)
+	GetCollection(ctx context.Context, id string, requester string) (core.Collection, error)
+	GetRemote(ctx context.Context, host string, id string, requester string) (core.Collection, error)
+	ListCollectionsByAuthor(ctx context.Context, author string, requester string) ([]core.Collection, error)
+	ListCollectionsBySchema(ctx context.Context, schema string, requester string) ([]core.Collection, error)
+	UpdateCollection(ctx context.Context, id string, objectStr string, signature string, requester string) (core.Collection, error)
+	DeleteCollection(ctx context.Context, id string, requester string) error
+	TransferCollection(ctx context.Context, id string, to string, objectStr string, signature string, requester string) (core.Collection, error)
 
-	CreateItem(ctx context.Context, item core.CollectionItem) (core.CollectionItem, error)
-	GetItem(ctx context.Context, id string, itemId string) (core.CollectionItem, error)
-	UpdateItem(ctx context.Context, item core.CollectionItem) (core.CollectionItem, error)
-	DeleteItem(ctx context.Context, id string, itemId string) (core.CollectionItem, error)
+	CreateItem(ctx context.Context, id string, objectStr string, signature string, requester string) (core.CollectionItem, error)
+	GetItem(ctx context.Context, id string, itemId string, requester string) (core.CollectionItem, error)
+	UpdateItem(ctx context.Context, id string, itemId string, objectStr string, signature string, requester string) (core.CollectionItem, error)
+	DeleteItem(ctx context.Context, id string, itemId string, requester string) (core.CollectionItem, error)
 }
 
 type service struct {
-	repo Repository
+	rdb    *redis.Client
+	repo   Repository
+	key    key.Service
+	domain domain.Service
+	entity entity.Service
+	config util.Config
 }
 
 // NewRepository creates a new collection repository
-func NewService(repo Repository) Service {
-	return &service{repo: repo}
+func NewService(rdb *redis.Client, repo Repository, key key.Service, domain domain.Service, entity entity.Service, config util.Config) Service {
+	return &service{rdb: rdb, repo: repo, key: key, domain: domain, entity: entity, config: config}
+}
+
+// verifyCollection parses the signed object and verifies its signature
+func (s *service) verifyCollection(ctx context.Context, objectStr string, signature string, requester string) (signedObject, error) {
+	var object signedObject
+	err := json.Unmarshal([]byte(objectStr), &object)
+	if err != nil {
+		return object, errors.Wrap(ErrInvalidObject, err.Error())
+	}
+
+	if object.Signer != requester {
+		return object, errors.Wrap(ErrPermissionDenied, "signer must be the requester")
+	}
+
+	var targets []string
+	if object.ID != "" {
+		targets = []string{object.ID}
+	}
+	err = s.key.VerifySignature(ctx, objectStr, object.Signer, object.KeyID, signature, key.ScopeCollectionWrite, targets)
+	if err != nil {
+		return object, errors.Wrap(ErrInvalidObject, err.Error())
+	}
+
+	return object, nil
 }
 
-// CreateCollection creates new collection
-func (s *service) CreateCollection(ctx context.Context, obj core.Collection) (core.Collection, error) {
+// verifyItem parses the signed object of the item and verifies its signature
+func (s *service) verifyItem(ctx context.Context, objectStr string, signature string, requester string) (itemSignedObject, error) {
+	var object itemSignedObject
+	err := json.Unmarshal([]byte(objectStr), &object)
+	if err != nil {
+		return object, errors.Wrap(ErrInvalidObject, err.Error())
+	}
+
+	if object.Signer != requester {
+		return object, errors.Wrap(ErrPermissionDenied, "signer must be the requester")
+	}
+
+	err = s.key.VerifySignature(ctx, objectStr, object.Signer, object.KeyID, signature, key.ScopeCollectionWrite, []string{object.Collection})
+	if err != nil {
+		return object, errors.Wrap(ErrInvalidObject, err.Error())
+	}
+
+	return object, nil
+}
+
+// CreateCollection creates new collection if the signature is valid
+func (s *service) CreateCollection(ctx context.Context, objectStr string, signature string, requester string) (core.Collection, error) {
 	ctx, span := tracer.Start(ctx, "ServiceCreateCollection")
 	defer span.End()
 
-	if obj.ID != "" {
-		return core.Collection{}, fmt.Errorf("id must be empty")
+	object, err := s.verifyCollection(ctx, objectStr, signature, requester)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+
+	if object.ID != "" {
+		return core.Collection{}, errors.Wrap(ErrInvalidObject, "id must be empty")
 	}
 
-	obj.ID = xid.New().String()
+	collection := core.Collection{
+		ID:         xid.New().String(),
+		Visible:    object.Visible,
+		Author:     object.Signer,
+		Maintainer: object.Maintainer,
+		Writer:     object.Writer,
+		Reader:     object.Reader,
+		Schema:     object.Schema,
+		Payload:    objectStr,
+		Signature:  signature,
+	}
 
-	return s.repo.CreateCollection(ctx, obj)
+	return s.repo.CreateCollection(ctx, collection)
 }
 
 // GetCollection returns a Collection by ID
-func (s *service) GetCollection(ctx context.Context, id string) (core.Collection, error) {
+func (s *service) GetCollection(ctx context.Context, id string, requester string) (core.Collection, error) {
 	ctx, span := tracer.Start(ctx, "ServiceGetCollection")
 	defer span.End()
 
-	return s.repo.GetCollection(ctx, id)
+	collection, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+	if !CanRead(collection, requester) {
+		return core.Collection{}, errors.Wrap(ErrPermissionDenied, "you are not a reader of the collection")
+	}
+	return collection, nil
+}
+
+// GetRemote returns a collection living in another domain
+// The collection and its items are verified and cached for util.RemoteCacheTTL.
+// The author is taken from the remote as it changes on transfer without a new signature,
+// while the access lists are rebuilt from the signed object.
+// The collection is read with the identity of the requester, so that collections with readers can be fetched.
+func (s *service) GetRemote(ctx context.Context, host string, id string, requester string) (core.Collection, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetRemote")
+	defer span.End()
+
+	err := s.domain.CheckFetchable(ctx, host)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+
+	cacheKey := "collection:remote:" + host + ":" + id
+
+	var response collectionResponse
+
+	cached, err := util.GetRemoteCache(ctx, s.rdb, cacheKey, requester)
+	if err == nil {
+		err = json.Unmarshal([]byte(cached), &response)
+		if err == nil {
+			return response.Content, nil
+		}
+	}
+
+	token, err := util.RequesterToken(s.config, requester)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+
+	body, err := util.FetchRemote(ctx, host, "/collection/"+id, token, &response)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+
+	collection := response.Content
+	if collection.ID != id {
+		err = fmt.Errorf("remote returned collection %s for %s", collection.ID, id)
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+
+	var object signedObject
+	err = json.Unmarshal([]byte(collection.Payload), &object)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+	if object.Schema != collection.Schema {
+		err = fmt.Errorf("collection %s does not match the signed object", id)
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+	err = s.key.VerifyRemoteSignature(ctx, host, collection.Payload, object.Signer, object.KeyID, collection.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+
+	// access lists are taken from the signed object rather than trusting the remote
+	collection.Visible = object.Visible
+	collection.Maintainer = object.Maintainer
+	collection.Writer = object.Writer
+	collection.Reader = object.Reader
+
+	for _, item := range collection.Items {
+		var itemObject itemSignedObject
+		err = json.Unmarshal([]byte(item.Payload), &itemObject)
+		if err != nil {
+			span.RecordError(err)
+			return core.Collection{}, err
+		}
+		if item.Collection != id || itemObject.Collection != id || itemObject.Signer != item.Author {
+			err = fmt.Errorf("item %s does not match the signed object", item.ID)
+			span.RecordError(err)
+			return core.Collection{}, err
+		}
+		err = s.key.VerifyRemoteSignature(ctx, host, item.Payload, itemObject.Signer, itemObject.KeyID, item.Signature)
+		if err != nil {
+			span.RecordError(err)
+			return core.Collection{}, err
+		}
+	}
+
+	util.SetRemoteCache(ctx, s.rdb, cacheKey, requester, body)
+
+	return collection, nil
+}
+
+// ListCollectionsByAuthor returns collections of the author
+// Invisible collections are listed only to their maintainers.
+func (s *service) ListCollectionsByAuthor(ctx context.Context, author string, requester string) ([]core.Collection, error) {
+	ctx, span := tracer.Start(ctx, "ServiceListCollectionsByAuthor")
+	defer span.End()
+
+	collections, err := s.repo.ListCollectionsByAuthor(ctx, author)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return filterListable(collections, requester), nil
+}
+
+// ListCollectionsBySchema returns visible collections of the schema
+func (s *service) ListCollectionsBySchema(ctx context.Context, schema string, requester string) ([]core.Collection, error) {
+	ctx, span := tracer.Start(ctx, "ServiceListCollectionsBySchema")
+	defer span.End()
+
+	collections, err := s.repo.ListCollectionsBySchema(ctx, schema)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return filterListable(collections, requester), nil
 }
 
-// UpdateCollection updates a collection
-func (s *service) UpdateCollection(ctx context.Context, obj core.Collection) (core.Collection, error) {
+// filterListable drops collections the requester should not see in lists
+func filterListable(collections []core.Collection, requester string) []core.Collection {
+	result := []core.Collection{}
+	for _, collection := range collections {
+		if !collection.Visible && !IsMaintainer(collection, requester) {
+			continue
+		}
+		if !CanRead(collection, requester) {
+			continue
+		}
+		result = append(result, collection)
+	}
+	return result
+}
+
+// UpdateCollection updates a collection if the signature is valid
+// Maintainers can edit the metadata and the writer/reader lists. Only the author can change the maintainers.
+// The stored access lists are always the ones of the signed object.
+func (s *service) UpdateCollection(ctx context.Context, id string, objectStr string, signature string, requester string) (core.Collection, error) {
 	ctx, span := tracer.Start(ctx, "ServiceUpdateCollection")
 	defer span.End()
 
-	return s.repo.UpdateCollection(ctx, obj)
+	object, err := s.verifyCollection(ctx, objectStr, signature, requester)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+
+	if object.ID != id {
+		return core.Collection{}, errors.Wrapf(ErrInvalidObject, "signed object is not for the collection %s", id)
+	}
+
+	current, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+	if !IsMaintainer(current, object.Signer) {
+		return core.Collection{}, errors.Wrap(ErrPermissionDenied, "only maintainers can update the collection")
+	}
+
+	// ownership moves only through TransferCollection
+	if object.Signer != current.Author && !sameMembers(object.Maintainer, current.Maintainer) {
+		return core.Collection{}, errors.Wrap(ErrPermissionDenied, "only the author can change the maintainers")
+	}
+
+	collection := core.Collection{
+		ID:         id,
+		Visible:    object.Visible,
+		Author:     current.Author,
+		Maintainer: object.Maintainer,
+		Writer:     object.Writer,
+		Reader:     object.Reader,
+		Schema:     object.Schema,
+		Payload:    objectStr,
+		Signature:  signature,
+		CDate:      current.CDate,
+	}
+
+	return s.repo.UpdateCollection(ctx, collection)
 }
 
 // DeleteCollection deletes a collection by ID
-func (s *service) DeleteCollection(ctx context.Context, id string) error {
+// Only the author can delete the collection
+func (s *service) DeleteCollection(ctx context.Context, id string, requester string) error {
 	ctx, span := tracer.Start(ctx, "ServiceDeleteCollection")
 	defer span.End()
 
+	current, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if current.Author != requester {
+		return errors.Wrap(ErrPermissionDenied, "only the author can delete the collection")
+	}
+
 	return s.repo.DeleteCollection(ctx, id)
 }
 
-// CreateItem creates new collection item
-func (s *service) CreateItem(ctx context.Context, item core.CollectionItem) (core.CollectionItem, error) {
+// TransferCollection hands the ownership of the collection over to another entity
+// Only the author can transfer the collection, re-signing it with the access lists after the transfer.
+func (s *service) TransferCollection(ctx context.Context, id string, to string, objectStr string, signature string, requester string) (core.Collection, error) {
+	ctx, span := tracer.Start(ctx, "ServiceTransferCollection")
+	defer span.End()
+
+	if to == "" {
+		return core.Collection{}, fmt.Errorf("new author must be specified")
+	}
+
+	object, err := s.verifyCollection(ctx, objectStr, signature, requester)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+	if object.ID != id {
+		return core.Collection{}, errors.Wrapf(ErrInvalidObject, "signed object is not for the collection %s", id)
+	}
+
+	current, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return core.Collection{}, err
+	}
+	if current.Author != requester {
+		return core.Collection{}, errors.Wrap(ErrPermissionDenied, "only the author can transfer the collection")
+	}
+
+	// the new author must be known here, either local or homed on a known domain
+	if _, err := s.entity.ResolveHost(ctx, to); err != nil {
+		return core.Collection{}, errors.Wrap(ErrInvalidObject, "new author is not known")
+	}
+
+	collection := core.Collection{
+		ID:         id,
+		Visible:    object.Visible,
+		Author:     to,
+		Maintainer: object.Maintainer,
+		Writer:     object.Writer,
+		Reader:     object.Reader,
+		Schema:     object.Schema,
+		Payload:    objectStr,
+		Signature:  signature,
+		CDate:      current.CDate,
+	}
+
+	return s.repo.UpdateCollection(ctx, collection)
+}
+
+// sameMembers reports whether both lists contain the same entities regardless of the order
+func sameMembers(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, entity := range a {
+		if !slices.Contains(b, entity) {
+			return false
+		}
+	}
+	for _, entity := range b {
+		if !slices.Contains(a, entity) {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateItem creates new collection item if the signature is valid
+func (s *service) CreateItem(ctx context.Context, id string, objectStr string, signature string, requester string) (core.CollectionItem, error) {
 	ctx, span := tracer.Start(ctx, "ServiceCreateItem")
 	defer span.End()
 
-	if item.ID != "" {
-		return core.CollectionItem{}, fmt.Errorf("id must be empty")
+	object, err := s.verifyItem(ctx, objectStr, signature, requester)
+	if err != nil {
+		span.RecordError(err)
+		return core.CollectionItem{}, err
+	}
+
+	if object.ID != "" {
+		return core.CollectionItem{}, errors.Wrap(ErrInvalidObject, "id must be empty")
+	}
+	if object.Collection != id {
+		return core.CollectionItem{}, errors.Wrapf(ErrInvalidObject, "signed object is not for the collection %s", id)
 	}
 
-	item.ID = xid.New().String()
+	collection, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return core.CollectionItem{}, err
+	}
+	if !CanWrite(collection, object.Signer) {
+		return core.CollectionItem{}, errors.Wrap(ErrPermissionDenied, "you are not a writer of the collection")
+	}
+
+	item := core.CollectionItem{
+		ID:         xid.New().String(),
+		Collection: id,
+		Author:     object.Signer,
+		Payload:    objectStr,
+		Signature:  signature,
+	}
 
 	return s.repo.CreateItem(ctx, item)
 }
 
 // GetItem returns a CollectionItem by ID
-func (s *service) GetItem(ctx context.Context, id string, itemId string) (core.CollectionItem, error) {
+func (s *service) GetItem(ctx context.Context, id string, itemId string, requester string) (core.CollectionItem, error) {
 	ctx, span := tracer.Start(ctx, "ServiceGetItem")
 	defer span.End()
 
+	collection, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return core.CollectionItem{}, err
+	}
+	if !CanRead(collection, requester) {
+		return core.CollectionItem{}, errors.Wrap(ErrPermissionDenied, "you are not a reader of the collection")
+	}
+
 	return s.repo.GetItem(ctx, id, itemId)
 }
 
-// UpdateItem updates a collection item
-func (s *service) UpdateItem(ctx context.Context, item core.CollectionItem) (core.CollectionItem, error) {
+// UpdateItem updates a collection item if the signature is valid
+// Writers can update their own items, maintainers can update any item.
+func (s *service) UpdateItem(ctx context.Context, id string, itemId string, objectStr string, signature string, requester string) (core.CollectionItem, error) {
 	ctx, span := tracer.Start(ctx, "ServiceUpdateItem")
 	defer span.End()
 
+	object, err := s.verifyItem(ctx, objectStr, signature, requester)
+	if err != nil {
+		span.RecordError(err)
+		return core.CollectionItem{}, err
+	}
+
+	if object.ID != itemId || object.Collection != id {
+		return core.CollectionItem{}, errors.Wrapf(ErrInvalidObject, "signed object is not for the item %s", itemId)
+	}
+
+	collection, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return core.CollectionItem{}, err
+	}
+
+	// the item must exist in the collection, Save would create it otherwise
+	current, err := s.repo.GetItem(ctx, id, itemId)
+	if err != nil {
+		span.RecordError(err)
+		return core.CollectionItem{}, err
+	}
+
+	if !canEditItem(collection, current, object.Signer) {
+		return core.CollectionItem{}, errors.Wrap(ErrPermissionDenied, "you are not allowed to edit the item")
+	}
+
+	item := core.CollectionItem{
+		ID:         itemId,
+		Collection: id,
+		Author:     object.Signer,
+		Payload:    objectStr,
+		Signature:  signature,
+	}
+
 	return s.repo.UpdateItem(ctx, item)
 }
 
 // DeleteItem deletes a collection item by ID
-func (s *service) DeleteItem(ctx context.Context, id string, itemId string) (core.CollectionItem, error) {
+// Writers can delete their own items, maintainers can delete any item.
+func (s *service) DeleteItem(ctx context.Context, id string, itemId string, requester string) (core.CollectionItem, error) {
 	ctx, span := tracer.Start(ctx, "ServiceDeleteItem")
 	defer span.End()
 
+	collection, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return core.CollectionItem{}, err
+	}
+
+	current, err := s.repo.GetItem(ctx, id, itemId)
+	if err != nil {
+		span.RecordError(err)
+		return core.CollectionItem{}, err
+	}
+
+	if !canEditItem(collection, current, requester) {
+		return core.CollectionItem{}, errors.Wrap(ErrPermissionDenied, "you are not allowed to delete the item")
+	}
+
 	return s.repo.DeleteItem(ctx, id, itemId)
 }
+
+// canEditItem reports whether the entity can update or delete the item
+func canEditItem(collection core.Collection, item core.CollectionItem, entity string) bool {
+	if IsMaintainer(collection, entity) {
+		return true
+	}
+	return item.Author == entity && CanWrite(collection, entity)
+}
@@ -0,0 +1,158 @@
+package collection
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/util"
+	"gorm.io/gorm"
+)
+
+func TestAccessLists(t *testing.T) {
+	collection := core.Collection{
+		Author:     "CCauthor",
+		Maintainer: []string{"CCmaintainer"},
+		Writer:     []string{"CCwriter"},
+		Reader:     []string{"CCreader"},
+	}
+
+	for _, entity := range []string{"CCauthor", "CCmaintainer", "CCwriter"} {
+		if !CanWrite(collection, entity) || !CanRead(collection, entity) {
+			t.Errorf("%s must be able to read and write", entity)
+		}
+	}
+	if CanWrite(collection, "CCreader") || !CanRead(collection, "CCreader") {
+		t.Error("readers must only be able to read")
+	}
+	if CanRead(collection, "CCother") || CanRead(collection, "") {
+		t.Error("others must not be able to read")
+	}
+
+	// empty reader list is public, empty writer list is maintainers only
+	public := core.Collection{Author: "CCauthor"}
+	if !CanRead(public, "") || !CanRead(public, "CCother") {
+		t.Error("collection without readers must be public")
+	}
+	if CanWrite(public, "CCother") || CanWrite(public, "") {
+		t.Error("collection without writers must be writable only by maintainers")
+	}
+}
+
+func TestCanEditItem(t *testing.T) {
+	collection := core.Collection{Author: "CCauthor", Writer: []string{"CCwriter", "CCother"}}
+	item := core.CollectionItem{Author: "CCwriter"}
+
+	if !canEditItem(collection, item, "CCwriter") || !canEditItem(collection, item, "CCauthor") {
+		t.Error("the item author and maintainers must be able to edit the item")
+	}
+	if canEditItem(collection, item, "CCother") || canEditItem(collection, item, "") {
+		t.Error("other writers must not be able to edit the item")
+	}
+
+	// the item author loses the permission when removed from the writers
+	collection.Writer = []string{"CCother"}
+	if canEditItem(collection, item, "CCwriter") {
+		t.Error("former writers must not be able to edit the item")
+	}
+}
+
+type fakeKeyRepository struct {
+	key.Repository
+}
+
+func (f fakeKeyRepository) GetKeyChain(ctx context.Context, ccid string) (string, error) {
+	return "", gorm.ErrRecordNotFound
+}
+
+func newSigner(t *testing.T) (string, string) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "CC" + crypto.PubkeyToAddress(privateKey.PublicKey).Hex()[2:], hex.EncodeToString(crypto.FromECDSA(privateKey))
+}
+
+func sign(t *testing.T, objectStr string, privatekey string) string {
+	signature, err := util.SignBytes([]byte(objectStr), privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestVerifyCollection(t *testing.T) {
+	s := &service{key: key.NewService(fakeKeyRepository{})}
+	ctx := context.Background()
+	ccid, privatekey := newSigner(t)
+
+	objectStr := fmt.Sprintf(`{"signer":"%s","type":"collection","schema":"https://example.com/schema.json","reader":["%s"]}`, ccid, ccid)
+	signature := sign(t, objectStr, privatekey)
+
+	object, err := s.verifyCollection(ctx, objectStr, signature, ccid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(object.Reader) != 1 || object.Reader[0] != ccid {
+		t.Errorf("unexpected object: %v", object)
+	}
+
+	// signed by someone else than the requester
+	other, _ := newSigner(t)
+	_, err = s.verifyCollection(ctx, objectStr, signature, other)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+
+	// tampered access list
+	tampered := fmt.Sprintf(`{"signer":"%s","type":"collection","schema":"https://example.com/schema.json","reader":[]}`, ccid)
+	_, err = s.verifyCollection(ctx, tampered, signature, ccid)
+	if !errors.Is(err, ErrInvalidObject) {
+		t.Errorf("expected invalid object, got %v", err)
+	}
+}
+
+func TestVerifyItem(t *testing.T) {
+	s := &service{key: key.NewService(fakeKeyRepository{})}
+	ctx := context.Background()
+	ccid, privatekey := newSigner(t)
+
+	objectStr := fmt.Sprintf(`{"signer":"%s","collection":"collection1","type":"item","body":"hello"}`, ccid)
+	signature := sign(t, objectStr, privatekey)
+
+	object, err := s.verifyItem(ctx, objectStr, signature, ccid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Collection != "collection1" {
+		t.Errorf("unexpected collection: %v", object.Collection)
+	}
+
+	// replayed into another collection
+	replayed := fmt.Sprintf(`{"signer":"%s","collection":"collection2","type":"item","body":"hello"}`, ccid)
+	_, err = s.verifyItem(ctx, replayed, signature, ccid)
+	if !errors.Is(err, ErrInvalidObject) {
+		t.Errorf("expected invalid object, got %v", err)
+	}
+
+	// signed by another key
+	_, otherKey := newSigner(t)
+	_, err = s.verifyItem(ctx, objectStr, sign(t, objectStr, otherKey), ccid)
+	if !errors.Is(err, ErrInvalidObject) {
+		t.Errorf("expected invalid object, got %v", err)
+	}
+}
+
+func TestSameMembers(t *testing.T) {
+	if !sameMembers([]string{"a", "b"}, []string{"b", "a"}) {
+		t.Error("order must not matter")
+	}
+	if sameMembers([]string{"a", "b"}, []string{"a"}) || sameMembers([]string{"a", "a"}, []string{"a", "b"}) {
+		t.Error("different members must not match")
+	}
+}
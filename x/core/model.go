@@ -13,13 +13,26 @@ type Association struct {
 	Schema      string         `json:"schema"  gorm:"type:text;uniqueIndex:uniq_association"`
 	TargetID    string         `json:"targetID" gorm:"type:uuid;uniqueIndex:uniq_association"`
 	TargetType  string         `json:"targetType" gorm:"type:string;uniqueIndex:uniq_association"`
+	TargetHost  string         `json:"targetHost,omitempty" gorm:"type:text;default:''"`
+	Host        string         `json:"host,omitempty" gorm:"type:text;default:''"` // domain the association was received from, empty if posted here
 	ContentHash string         `json:"contentHash" gorm:"type:char(64);uniqueIndex:uniq_association"`
 	Payload     string         `json:"payload" gorm:"type:json"`
-	Signature   string         `json:"signature" gorm:"type:char(130)"`
+	Signature   string         `json:"signature" gorm:"type:text"`
 	CDate       time.Time      `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 	Streams     pq.StringArray `json:"streams" gorm:"type:text[]"`
 }
 
+// Tombstone is the domain signed proof that a message or association was deleted
+// immutable
+type Tombstone struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:text"`
+	Type      string    `json:"type" gorm:"type:text"`
+	Author    string    `json:"author" gorm:"type:char(42)"`
+	Payload   string    `json:"payload" gorm:"type:json"`
+	Signature string    `json:"signature" gorm:"type:text"`
+	CDate     time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+}
+
 // Character is one of  a Concurrent base object
 // mutable
 type Character struct {
@@ -27,7 +40,7 @@ type Character struct {
 	Author       string        `json:"author" gorm:"type:char(42)"`
 	Schema       string        `json:"schema" gorm:"type:text"`
 	Payload      string        `json:"payload" gorm:"type:json"`
-	Signature    string        `json:"signature" gorm:"type:char(130)"`
+	Signature    string        `json:"signature" gorm:"type:text"`
 	Associations []Association `json:"associations" gorm:"polymorphic:Target"`
 	CDate        time.Time     `json:"cdate" gorm:"->;<-:create;autoCreateTime"`
 	MDate        time.Time     `json:"mdate" gorm:"autoUpdateTime"`
@@ -36,17 +49,20 @@ type Character struct {
 // Entity is one of a concurrent base object
 // mutable
 type Entity struct {
-	ID      string    `json:"ccid" gorm:"type:char(42)"`
-	Tag     string    `json:"tag" gorm:"type:text;"`
-	Domain  string    `json:"domain" gorm:"type:text"`
-	Certs   string    `json:"certs" gorm:"type:json;default:'null'"`
-	Meta    string    `json:"meta" gorm:"type:json;default:'null'"`
-	Score   int       `json:"score" gorm:"type:integer;default:0"`
-	Inviter string    `json:"inviter" gorm:"type:char(42)"`
-	CDate   time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
-	MDate   time.Time `json:"mdate" gorm:"autoUpdateTime"`
-	Acking  Ack       `json:"acking" gorm:"foreignKey:From"`
-	Acker   Ack       `json:"acker" gorm:"foreignKey:To"`
+	ID       string    `json:"ccid" gorm:"type:char(42)"`
+	Tag      string    `json:"tag" gorm:"type:text;"`
+	Domain   string    `json:"domain" gorm:"type:text"`
+	Certs    string    `json:"certs" gorm:"type:json;default:'null'"`
+	Meta     string    `json:"meta" gorm:"type:json;default:'null'"`
+	Score    int       `json:"score" gorm:"type:integer;default:0"`
+	Inviter  string    `json:"inviter" gorm:"type:char(42)"`
+	KeyType  string    `json:"keyType" gorm:"type:text;default:'ECRECOVER'"`
+	Pubkey   string    `json:"pubkey" gorm:"type:text"`
+	KeyChain string    `json:"keyChain" gorm:"type:json;default:'null'"`
+	CDate    time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+	MDate    time.Time `json:"mdate" gorm:"autoUpdateTime"`
+	Acking   Ack       `json:"acking" gorm:"foreignKey:From"`
+	Acker    Ack       `json:"acker" gorm:"foreignKey:To"`
 }
 
 // Domain is one of a concurrent base object
@@ -63,16 +79,27 @@ type Domain struct {
 }
 
 // Message is one of a concurrent base object
-// immutable
+// The payload can be replaced by its author. Former versions are kept as MessageRevision
 type Message struct {
-	ID           string         `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	Author       string         `json:"author" gorm:"type:char(42)"`
-	Schema       string         `json:"schema" gorm:"type:text"`
-	Payload      string         `json:"payload" gorm:"type:json"`
-	Signature    string         `json:"signature" gorm:"type:char(130)"`
-	CDate        time.Time      `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
-	Associations []Association  `json:"associations" gorm:"polymorphic:Target"`
-	Streams      pq.StringArray `json:"streams" gorm:"type:text[]"`
+	ID           string            `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Author       string            `json:"author" gorm:"type:char(42)"`
+	Schema       string            `json:"schema" gorm:"type:text"`
+	Payload      string            `json:"payload" gorm:"type:json"`
+	Signature    string            `json:"signature" gorm:"type:text"`
+	CDate        time.Time         `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+	Associations []Association     `json:"associations" gorm:"polymorphic:Target"`
+	Streams      pq.StringArray    `json:"streams" gorm:"type:text[]"`
+	Revisions    []MessageRevision `json:"revisions,omitempty" gorm:"foreignKey:Message"`
+}
+
+// MessageRevision is a former version of an edited message
+// immutable
+type MessageRevision struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Message   string    `json:"message" gorm:"type:uuid;index"`
+	Payload   string    `json:"payload" gorm:"type:json"`
+	Signature string    `json:"signature" gorm:"type:text"`
+	CDate     time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 }
 
 // Stream is one of a base object of concurrent
@@ -86,10 +113,28 @@ type Stream struct {
 	Reader     pq.StringArray `json:"reader" gorm:"type:char(42)[];default:'{}'"`
 	Schema     string         `json:"schema" gorm:"type:text"`
 	Payload    string         `json:"payload" gorm:"type:json"`
+	MaxLen     int64          `json:"maxLen" gorm:"type:bigint;default:0"`    // 0 for server default
+	Retention  int64          `json:"retention" gorm:"type:bigint;default:0"` // seconds, 0 for server default
 	CDate      time.Time      `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 	MDate      time.Time      `json:"mdate" gorm:"autoUpdateTime"`
 }
 
+// StreamElement is the durable copy of a stream entry
+// Ms and Seq are the parts of the redis stream ID
+// Owner is the entity the entry belongs to and Host is the domain the object lives on
+// immutable
+type StreamElement struct {
+	Stream   string    `json:"stream" gorm:"primaryKey;type:char(20)"`
+	Ms       int64     `json:"ms" gorm:"primaryKey;type:bigint;autoIncrement:false"`
+	Seq      int64     `json:"seq" gorm:"primaryKey;type:bigint;autoIncrement:false"`
+	ObjectID string    `json:"id" gorm:"type:text;index"`
+	Type     string    `json:"type" gorm:"type:text"`
+	Author   string    `json:"author" gorm:"type:char(42)"`
+	Owner    string    `json:"owner" gorm:"type:char(42)"`
+	Host     string    `json:"host" gorm:"type:text;default:''"`
+	CDate    time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+}
+
 // Collection is one of a base object of concurrent
 // mutable
 type Collection struct {
@@ -100,6 +145,8 @@ type Collection struct {
 	Writer     pq.StringArray   `json:"writer" gorm:"type:char(42)[];default:'{}'"`
 	Reader     pq.StringArray   `json:"reader" gorm:"type:char(42)[];default:'{}'"`
 	Schema     string           `json:"schema" gorm:"type:text"`
+	Payload    string           `json:"payload" gorm:"type:json;default:'{}'"`
+	Signature  string           `json:"signature" gorm:"type:text"`
 	CDate      time.Time        `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 	MDate      time.Time        `json:"mdate" gorm:"autoUpdateTime"`
 	Items      []CollectionItem `json:"items" gorm:"foreignKey:Collection"`
@@ -110,13 +157,56 @@ type Collection struct {
 type CollectionItem struct {
 	ID         string `json:"id" gorm:"primaryKey;type:char(20);"`
 	Collection string `json:"collection" gorm:"type:char(20)"`
+	Author     string `json:"author" gorm:"type:char(42)"`
 	Payload    string `json:"payload" gorm:"type:json;default:'{}'"`
+	Signature  string `json:"signature" gorm:"type:text"`
+}
+
+// Grant is a delegation from an entity to a sub-key with limited scopes
+// immutable
+type Grant struct {
+	ID        string         `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Owner     string         `json:"owner" gorm:"type:char(42);index"`
+	Subkey    string         `json:"subkey" gorm:"type:char(42);uniqueIndex"`
+	Scopes    pq.StringArray `json:"scopes" gorm:"type:text[]"`
+	ExpiresAt time.Time      `json:"expiresAt" gorm:"type:timestamp with time zone"`
+	RevokedAt *time.Time     `json:"revokedAt,omitempty" gorm:"type:timestamp with time zone"`
+	Payload   string         `json:"payload" gorm:"type:json"`
+	Signature string         `json:"signature" gorm:"type:text"`
+	CDate     time.Time      `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 }
 
 type Ack struct {
 	From string `json:"from" gorm:"primaryKey;type:char(42)"`
     To string `json:"to" gorm:"primaryKey;type:char(42)"`
 	Payload    string `json:"payload" gorm:"type:json;default:'{}'"`
-	Signature string `json:"signature" gorm:"type:char(130)"`
+	Signature string `json:"signature" gorm:"type:text"`
+	SignedAt  time.Time `json:"-" gorm:"type:timestamp with time zone"`
+	Unacked   bool      `json:"-" gorm:"default:false"`
+}
+
+// Move is a move of an entity between domains which has been carried out
+// immutable
+type Move struct {
+	Signature string    `json:"signature" gorm:"primaryKey;type:text"`
+	Signer    string    `json:"signer" gorm:"type:char(42)"`
+	From      string    `json:"from" gorm:"type:text"`
+	To        string    `json:"to" gorm:"type:text"`
+	SignedAt  time.Time `json:"signedAt" gorm:"type:timestamp with time zone"`
+	CDate     time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
 }
 
+// OutboxItem is a pending delivery to a remote domain
+// mutable
+type OutboxItem struct {
+	ID          string    `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Domain      string    `json:"domain" gorm:"type:text;index"`
+	Path        string    `json:"path" gorm:"type:text"`
+	Payload     string    `json:"payload" gorm:"type:json"`
+	Status      string    `json:"status" gorm:"type:text;index"` // pending, dead
+	Attempts    int       `json:"attempts" gorm:"type:integer;default:0"`
+	LastError   string    `json:"lastError" gorm:"type:text"`
+	NextAttempt time.Time `json:"nextAttempt" gorm:"type:timestamp with time zone"`
+	CDate       time.Time `json:"cdate" gorm:"->;<-:create;type:timestamp with time zone;not null;default:clock_timestamp()"`
+	MDate       time.Time `json:"mdate" gorm:"autoUpdateTime"`
+}
@@ -1,16 +1,11 @@
 package domain
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
-	"time"
 
-	"github.com/rs/xid"
 	"gorm.io/gorm"
 
 	"github.com/labstack/echo/v4"
@@ -115,6 +110,12 @@ func (h handler) Hello(c echo.Context) error {
 		return err
 	}
 
+	// the request must be signed by the newcomer itself
+	signed, ok := c.Get("signedrequest").(util.SignedRequest)
+	if !ok || signed.KeyID != newcomer.CCID || signed.Pubkey != newcomer.Pubkey {
+		return c.String(http.StatusUnauthorized, "signature does not match the profile")
+	}
+
 	// challenge
 	req, err := http.NewRequest("GET", "https://"+newcomer.ID+"/api/v1/domain", nil)
 	if err != nil {
@@ -156,66 +157,19 @@ func (h handler) Hello(c echo.Context) error {
 }
 
 // SayHello initiates a challenge to a remote host
-// The remote host will respond with a signed JWT
-// If the JWT is valid, the remote host will be added to the database
+// If the remote host accepts, it will be added to the database
 func (h handler) SayHello(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerSayHello")
 	defer span.End()
 
 	target := c.Param("fqdn")
 
-	me := Profile{
-		ID:     h.config.Concurrent.FQDN,
-		CCID:   h.config.Concurrent.CCID,
-		Pubkey: h.config.Concurrent.PublicKey,
-	}
-
-	meStr, err := json.Marshal(me)
-
-	// challenge
-	jwt, err := util.CreateJWT(util.JwtClaims{
-		Issuer:         h.config.Concurrent.CCID,
-		Subject:        "CONCURRENT_API",
-		Audience:       target,
-		ExpirationTime: strconv.FormatInt(time.Now().Add(1*time.Minute).Unix(), 10),
-		IssuedAt:       strconv.FormatInt(time.Now().Unix(), 10),
-		JWTID:          xid.New().String(),
-	}, h.config.Concurrent.PrivateKey)
-
-	req, err := http.NewRequest("POST", "https://"+target+"/api/v1/domains/hello", bytes.NewBuffer(meStr))
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		return c.String(http.StatusBadRequest, err.Error())
-	}
-	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("authorization", "Bearer "+jwt)
-	client := new(http.Client)
-	resp, err := client.Do(req)
+	fetchedProf, err := h.service.SayHello(ctx, target)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		return c.String(http.StatusBadRequest, err.Error())
 	}
-	defer resp.Body.Close()
-
-	body, _ := ioutil.ReadAll(resp.Body)
-
-	var fetchedProf Profile
-	json.Unmarshal(body, &fetchedProf)
-
-	if target != fetchedProf.ID {
-		span.SetStatus(codes.Error, fmt.Sprintf("target does not match fetched profile: %v", fetchedProf.ID))
-		return c.String(http.StatusBadRequest, "validation failed")
-	}
-
-	h.service.Upsert(ctx, &core.Domain{
-		ID:     fetchedProf.ID,
-		CCID:   fetchedProf.CCID,
-		Tag:    "",
-		Pubkey: fetchedProf.Pubkey,
-	})
 
 	return c.JSON(http.StatusOK, fetchedProf)
 }
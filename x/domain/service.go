@@ -1,9 +1,20 @@
 package domain
 
 import (
+	"bytes"
 	"context"
-	"github.com/totegamma/concurrent/x/core"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"time"
+
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/exp/slices"
 )
 
 // Service is the interface for host service
@@ -15,15 +26,18 @@ type Service interface {
     Delete(ctx context.Context, id string) (error)
     Update(ctx context.Context, host *core.Domain) error
     UpdateScrapeTime(ctx context.Context, id string, scrapeTime time.Time) error
+    SayHello(ctx context.Context, target string) (Profile, error)
+    CheckFetchable(ctx context.Context, fqdn string) error
 }
 
 type service struct {
 	repository Repository
+	config     util.Config
 }
 
 // NewService creates a new host service
-func NewService(repository Repository) Service {
-	return &service{repository}
+func NewService(repository Repository, config util.Config) Service {
+	return &service{repository, config}
 }
 
 // Upsert creates new host
@@ -81,3 +95,82 @@ func (s *service) UpdateScrapeTime(ctx context.Context, id string, scrapeTime ti
 
 	return s.repository.UpdateScrapeTime(ctx, id, scrapeTime)
 }
+
+// CheckFetchable returns util.ErrUnknownRemote unless the domain is known and not blocked
+// Hosts given by clients or other domains must pass this before anything is fetched from them.
+func (s *service) CheckFetchable(ctx context.Context, fqdn string) error {
+	ctx, span := tracer.Start(ctx, "ServiceCheckFetchable")
+	defer span.End()
+
+	domain, err := s.repository.GetByFQDN(ctx, fqdn)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("%w: %s", util.ErrUnknownRemote, fqdn)
+	}
+	if slices.Contains(strings.Split(domain.Tag, ","), "_blocked") {
+		return fmt.Errorf("%w: %s is blocked", util.ErrUnknownRemote, fqdn)
+	}
+	return nil
+}
+
+// SayHello initiates a challenge to a remote host
+// The request is signed with the domain key so that the remote host can verify this host
+// If the remote host accepts, it will be added to the database
+func (s *service) SayHello(ctx context.Context, target string) (Profile, error) {
+	ctx, span := tracer.Start(ctx, "ServiceSayHello")
+	defer span.End()
+
+	me := Profile{
+		ID:     s.config.Concurrent.FQDN,
+		CCID:   s.config.Concurrent.CCID,
+		Pubkey: s.config.Concurrent.PublicKey,
+	}
+
+	meStr, err := json.Marshal(me)
+	if err != nil {
+		span.RecordError(err)
+		return Profile{}, err
+	}
+
+	req, err := http.NewRequest("POST", "https://"+target+"/api/v1/domains/hello", bytes.NewBuffer(meStr))
+	if err != nil {
+		span.RecordError(err)
+		return Profile{}, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	req.Header.Add("content-type", "application/json")
+	err = util.SignRequest(req, meStr, s.config.Concurrent.CCID, s.config.Concurrent.PrivateKey)
+	if err != nil {
+		span.RecordError(err)
+		return Profile{}, err
+	}
+	client := new(http.Client)
+	resp, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return Profile{}, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	var fetchedProf Profile
+	json.Unmarshal(body, &fetchedProf)
+
+	if target != fetchedProf.ID {
+		return Profile{}, fmt.Errorf("target does not match fetched profile: %v", fetchedProf.ID)
+	}
+
+	err = s.repository.Upsert(ctx, &core.Domain{
+		ID:     fetchedProf.ID,
+		CCID:   fetchedProf.CCID,
+		Tag:    "",
+		Pubkey: fetchedProf.Pubkey,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return Profile{}, err
+	}
+
+	return fetchedProf, nil
+}
@@ -29,6 +29,9 @@ type Handler interface {
     Delete(c echo.Context) error
     Ack(c echo.Context) error
     Unack(c echo.Context) error
+    ForwardAck(c echo.Context) error
+    Acking(c echo.Context) error
+    Acker(c echo.Context) error
 }
 
 type handler struct {
@@ -56,11 +59,14 @@ func (h handler) Get(c echo.Context) error {
 		return err
 	}
 	publicInfo := SafeEntity{
-		ID:     entity.ID,
-		Tag:    entity.Tag,
-		Domain: entity.Domain,
-		Certs:  entity.Certs,
-		CDate:  entity.CDate,
+		ID:      entity.ID,
+		Tag:     entity.Tag,
+		Domain:  entity.Domain,
+		Certs:   entity.Certs,
+		KeyType:  entity.KeyType,
+		Pubkey:   entity.Pubkey,
+		KeyChain: entity.KeyChain,
+		CDate:    entity.CDate,
 	}
 	return c.JSON(http.StatusOK, publicInfo)
 }
@@ -104,7 +110,7 @@ func (h handler) Register(c echo.Context) error {
 		if claims.Subject != "CONCURRENT_INVITE" {
 			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid token"})
 		}
-		_, err = h.rdb.Get(ctx, "jti:"+claims.JWTID).Result()
+		_, err = h.rdb.Get(ctx, "jti:"+claims.Issuer+":"+claims.JWTID).Result()
 		if err == nil {
 			span.RecordError(err)
 			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "token is already used"})
@@ -115,7 +121,7 @@ func (h handler) Register(c echo.Context) error {
 		expireAt, _ = strconv.ParseInt(claims.ExpirationTime, 10, 64)
 	}
 
-	err = h.service.Register(ctx, request.CCID, request.Meta, inviter)
+	err = h.service.Register(ctx, request.CCID, request.Meta, inviter, request.KeyType, request.Pubkey)
 	if err != nil {
 		span.RecordError(err)
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
@@ -123,7 +129,7 @@ func (h handler) Register(c echo.Context) error {
 
 	if jwtID != "" {
 		expiration := time.Until(time.Unix(int64(expireAt), 0))
-		err = h.rdb.Set(ctx, "jti:"+jwtID, "1", expiration).Err()
+		err = h.rdb.Set(ctx, "jti:"+inviter+":"+jwtID, "1", expiration).Err()
 		if err != nil {
 			span.RecordError(err)
 			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
@@ -214,6 +220,9 @@ func (h handler) Ack(c echo.Context) error {
 
     err = h.service.Ack(ctx, request.SignedObject, request.Signature)
     if err != nil {
+        if errors.Is(err, ErrOutdated) {
+            return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+        }
         return err
     }
 
@@ -233,9 +242,96 @@ func (h handler) Unack(c echo.Context) error {
 
     err = h.service.Unack(ctx, request.SignedObject, request.Signature)
     if err != nil {
+        if errors.Is(err, ErrOutdated) {
+            return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+        }
         return err
     }
 
     return c.String(http.StatusOK, "{\"message\": \"accept\"}")
 }
 
+
+// ForwardAck receives an ack or unack made to a local entity
+// Called by the domain of the ack author
+func (h handler) ForwardAck(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerForwardAck")
+	defer span.End()
+
+	var packet ackPacket
+	err := c.Bind(&packet)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	host, ok := c.Get("requesterHost").(string)
+	if !ok {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "requesting domain is not known"})
+	}
+
+	err = h.service.ReceiveAck(ctx, packet.SignedObject, packet.Signature, host)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+		if errors.Is(err, ErrOutdated) {
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
+
+// Acking returns a page of the entities the entity acks
+// Use the returned next cursor to continue.
+func (h handler) Acking(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerAcking")
+	defer span.End()
+
+	limit, err := ackLimit(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	page, err := h.service.GetAcking(ctx, c.Param("id"), c.QueryParam("cursor"), limit)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": page})
+}
+
+// Acker returns a page of the entities acking the entity
+// Use the returned next cursor to continue.
+func (h handler) Acker(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerAcker")
+	defer span.End()
+
+	limit, err := ackLimit(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	page, err := h.service.GetAcker(ctx, c.Param("id"), c.QueryParam("cursor"), limit)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": page})
+}
+
+func ackLimit(c echo.Context) (int, error) {
+	limit := 50
+	if queryLimit := c.QueryParam("limit"); queryLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(queryLimit)
+		if err != nil || limit <= 0 || limit > 100 {
+			return 0, fmt.Errorf("limit must be between 1 and 100")
+		}
+	}
+	return limit, nil
+}
@@ -2,7 +2,10 @@
 package entity
 
 import (
+	"errors"
 	"time"
+
+	"github.com/totegamma/concurrent/x/core"
 )
 
 type createRequest struct {
@@ -15,22 +18,28 @@ type registerRequest struct {
 	Meta    string `json:"meta"`
 	Token   string `json:"token"`
 	Captcha string `json:"captcha"`
+	KeyType string `json:"keyType"`
+	Pubkey  string `json:"pubkey"`
 }
 
 // SafeEntity is safe verison of entity
 type SafeEntity struct {
-	ID     string    `json:"ccid"`
-	Tag    string    `json:"tag"`
-	Score  int       `json:"score"`
-	Domain string    `json:"domain"`
-	Certs  string    `json:"certs"`
-	CDate  time.Time `json:"cdate"`
-	MDate  time.Time `json:"mdate"`
+	ID       string    `json:"ccid"`
+	Tag      string    `json:"tag"`
+	Score    int       `json:"score"`
+	Domain   string    `json:"domain"`
+	Certs    string    `json:"certs"`
+	KeyType  string    `json:"keyType"`
+	Pubkey   string    `json:"pubkey"`
+	KeyChain string    `json:"keyChain"`
+	CDate    time.Time `json:"cdate"`
+	MDate    time.Time `json:"mdate"`
 }
 
 type AckSignedObject struct {
     Type string `json:"type"`
     From string `json:"from"`
+    KeyID string `json:"keyID,omitempty"`
     To string `json:"to"`
     SignedAt time.Time `json:"signedAt"`
 }
@@ -40,3 +49,21 @@ type ackRequest struct {
     Signature string `json:"signature"`
 }
 
+// ackPacket is an ack or unack delivered to the domain of its destination
+type ackPacket struct {
+	SignedObject string `json:"signedObject"`
+	Signature    string `json:"signature"`
+}
+
+// AckPage is a chunk of acks ordered by the other side of the ack
+// Next is empty when there are no more acks.
+type AckPage struct {
+	Acks []core.Ack `json:"acks"`
+	Next string     `json:"next"`
+}
+
+// ErrPermissionDenied is returned when an ack is delivered by a domain other than the home of its author
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrOutdated is returned when the pair has an ack or unack signed after the given one
+var ErrOutdated = errors.New("outdated ack")
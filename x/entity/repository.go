@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/totegamma/concurrent/x/core"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
@@ -17,7 +18,8 @@ type Repository interface {
     Delete(ctx context.Context, key string) error
     Update(ctx context.Context, entity *core.Entity) error
     Ack(ctx context.Context, ack *core.Ack) error
-    Unack(ctx context.Context, from, to string) error
+    GetAcking(ctx context.Context, from string, after string, limit int) ([]core.Ack, error)
+    GetAcker(ctx context.Context, to string, after string, limit int) ([]core.Ack, error)
 	Total(ctx context.Context) (int64, error)
 }
 
@@ -102,19 +104,45 @@ func (r *repository) Update(ctx context.Context, entity *core.Entity) error {
 	return r.db.WithContext(ctx).Where("id = ?", entity.ID).Updates(&entity).Error
 }
 
-// Ack creates a new ack
+// Ack stores the latest ack or unack of the pair
+// Unacks are kept with the Unacked flag, so that an ack signed before them can not revive the pair.
+// Returns ErrOutdated if the pair has a newer ack or unack.
 func (r *repository) Ack(ctx context.Context, ack *core.Ack) error {
     ctx, span := tracer.Start(ctx, "RepositoryAck")
     defer span.End()
 
-    return r.db.WithContext(ctx).Create(&ack).Error
+    result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+        UpdateAll: true,
+        Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "acks.signed_at < excluded.signed_at"}}},
+    }).Create(ack)
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrOutdated
+    }
+    return nil
 }
 
-// Unack deletes a ack
-func (r *repository) Unack(ctx context.Context, from, to string) error {
-    ctx, span := tracer.Start(ctx, "RepositoryUnack")
-    defer span.End()
+// GetAcking returns acks made by the entity ordered by the destination
+// Only destinations after the given one are returned.
+func (r *repository) GetAcking(ctx context.Context, from string, after string, limit int) ([]core.Ack, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetAcking")
+	defer span.End()
+
+	var acks []core.Ack
+	err := r.db.WithContext(ctx).Where(`"from" = ? AND "to" > ? AND NOT unacked`, from, after).Order(`"to" asc`).Limit(limit).Find(&acks).Error
+	return acks, err
+}
+
+// GetAcker returns acks made to the entity ordered by the source
+// Only sources after the given one are returned.
+func (r *repository) GetAcker(ctx context.Context, to string, after string, limit int) ([]core.Ack, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetAcker")
+	defer span.End()
 
-    return r.db.WithContext(ctx).Delete(&core.Ack{}, "from = ? AND to = ?", from, to).Error
+	var acks []core.Ack
+	err := r.db.WithContext(ctx).Where(`"to" = ? AND "from" > ? AND NOT unacked`, to, after).Order(`"from" asc`).Limit(limit).Find(&acks).Error
+	return acks, err
 }
 
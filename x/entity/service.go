@@ -6,8 +6,12 @@ import (
 	"strings"
 	"time"
     "encoding/json"
+	"encoding/hex"
 
+	"github.com/pkg/errors"
 	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/outbox"
 	"github.com/totegamma/concurrent/x/util"
 	"golang.org/x/exp/slices"
 )
@@ -15,7 +19,7 @@ import (
 // Service is the interface for entity service
 type Service interface {
     Create(ctx context.Context, ccid string, meta string) error
-    Register(ctx context.Context, ccid string, meta string, inviterID string) error
+    Register(ctx context.Context, ccid string, meta string, inviterID string, keyType string, pubkey string) error
     Get(ctx context.Context, ccid string) (core.Entity, error)
     List(ctx context.Context) ([]SafeEntity, error)
     ListModified(ctx context.Context, modified time.Time) ([]SafeEntity, error)
@@ -24,19 +28,24 @@ type Service interface {
     Upsert(ctx context.Context, entity *core.Entity) error
     IsUserExists(ctx context.Context, user string) bool
     Delete(ctx context.Context, id string) error
-    Ack(ctx context.Context, from, to string) error
-    Unack(ctx context.Context, from, to string) error
+    Ack(ctx context.Context, objectStr string, signature string) error
+    Unack(ctx context.Context, objectStr string, signature string) error
+    ReceiveAck(ctx context.Context, objectStr string, signature string, host string) error
+    GetAcking(ctx context.Context, ccid string, cursor string, limit int) (AckPage, error)
+    GetAcker(ctx context.Context, ccid string, cursor string, limit int) (AckPage, error)
 	Total(ctx context.Context) (int64, error)
 }
 
 type service struct {
 	repository Repository
 	config     util.Config
+	key        key.Service
+	outbox     outbox.Service
 }
 
 // NewService creates a new entity service
-func NewService(repository Repository, config util.Config) Service {
-	return &service{repository, config}
+func NewService(repository Repository, config util.Config, key key.Service, outbox outbox.Service) Service {
+	return &service{repository, config, key, outbox}
 }
 
 // Total returns the total number of entities
@@ -61,16 +70,25 @@ func (s *service) Create(ctx context.Context, ccid string, meta string) error {
 
 // Register creates new entity
 // check if registration is open
-func (s *service) Register(ctx context.Context, ccid string, meta string, inviterID string) error {
+// the public key must be given if the key type can not recover it from signatures
+func (s *service) Register(ctx context.Context, ccid string, meta string, inviterID string, keyType string, pubkey string) error {
 	ctx, span := tracer.Start(ctx, "ServiceCreate")
 	defer span.End()
 
+	keyType, err := checkPubkey(ccid, keyType, pubkey)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
 	if s.config.Concurrent.Registration == "open" {
 		return s.repository.Create(ctx, &core.Entity{
 			ID:      ccid,
 			Tag:     "",
 			Meta:    meta,
 			Inviter: "",
+			KeyType: keyType,
+			Pubkey:  pubkey,
 		})
 	} else if s.config.Concurrent.Registration == "invite" {
 		if inviterID == "" {
@@ -93,12 +111,42 @@ func (s *service) Register(ctx context.Context, ccid string, meta string, invite
 			Tag:     "",
 			Meta:    meta,
 			Inviter: inviterID,
+			KeyType: keyType,
+			Pubkey:  pubkey,
 		})
 	} else {
 		return fmt.Errorf("registration is not open")
 	}
 }
 
+// checkPubkey checks the public key belongs to the ccid and returns normalized key type
+func checkPubkey(ccid string, keyType string, pubkey string) (string, error) {
+	if keyType == "" {
+		keyType = util.KeyTypeSecp256k1
+	}
+	keyType = strings.ToUpper(keyType)
+	if _, err := util.GetSignatureAlgorithm(keyType); err != nil {
+		return "", err
+	}
+
+	if pubkey == "" {
+		if keyType != util.KeyTypeSecp256k1 {
+			return "", fmt.Errorf("pubkey is required for %v", keyType)
+		}
+		return keyType, nil
+	}
+
+	pubkeyBytes, err := hex.DecodeString(pubkey)
+	if err != nil {
+		return "", err
+	}
+	if util.PubkeyToCCID(keyType, pubkeyBytes) != ccid {
+		return "", fmt.Errorf("pubkey does not match ccid")
+	}
+
+	return keyType, nil
+}
+
 // Get returns entity by ccid
 func (s *service) Get(ctx context.Context, key string) (core.Entity, error) {
 	ctx, span := tracer.Start(ctx, "ServiceGet")
@@ -183,64 +231,182 @@ func (s *service) Delete(ctx context.Context, id string) error {
 }
 
 // Ack creates new Ack
+// If the destination lives on another domain, the ack is also delivered to it.
 func (s *service) Ack(ctx context.Context, objectStr string, signature string) error {
-    ctx, span := tracer.Start(ctx, "ServiceAck")
-    defer span.End()
-
-    var object AckSignedObject
-    err := json.Unmarshal([]byte(objectStr), &object)
-    if err != nil {
-        span.RecordError(err)
-        return err
-    }
+	ctx, span := tracer.Start(ctx, "ServiceAck")
+	defer span.End()
 
-    if object.Type != "ack" {
-        return fmt.Errorf("object is not ack")
-    }
+	object, err := s.verifyAck(ctx, objectStr, signature, "ack")
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-    err = util.VerifySignature(objectStr, object.From, signature)
-    if err != nil {
-        span.RecordError(err)
-        return err
-    }
+	host, err := s.ResolveHost(ctx, object.To)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-    // TODO: if ack destination is remote, forward ack to remote
+	err = s.storeAck(ctx, object, objectStr, signature)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-    return s.repository.Ack(ctx, &core.Ack{
-        From: object.From,
-        To: object.To,
-        Signature: signature,
-        Payload: objectStr,
-    })
+	return s.forwardAck(ctx, host, objectStr, signature)
 }
 
-// Unack creates new Unack
+// Unack deletes an Ack
+// If the destination lives on another domain, the unack is also delivered to it.
 func (s *service) Unack(ctx context.Context, objectStr string, signature string) error {
-    ctx, span := tracer.Start(ctx, "ServiceUnack")
-    defer span.End()
+	ctx, span := tracer.Start(ctx, "ServiceUnack")
+	defer span.End()
 
-    var object AckSignedObject
-    err := json.Unmarshal([]byte(objectStr), &object)
-    if err != nil {
-        span.RecordError(err)
-        return err
-    }
+	object, err := s.verifyAck(ctx, objectStr, signature, "unack")
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-    // TODO: if ack destination is remote, forward ack to remote
+	host, err := s.ResolveHost(ctx, object.To)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-    if object.Type != "unack" {
-        return fmt.Errorf("object is not unack")
-    }
+	err = s.storeAck(ctx, object, objectStr, signature)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-    err = util.VerifySignature(objectStr, object.From, signature)
-    if err != nil {
-        span.RecordError(err)
-        return err
-    }
+	return s.forwardAck(ctx, host, objectStr, signature)
+}
 
-    // TODO: if unack destination is remote, forward unack to remote
+// ReceiveAck stores an ack or unack delivered by the domain of its author
+// host is the domain delivering the object, which must be the home of the author.
+// The destination must be a local entity.
+func (s *service) ReceiveAck(ctx context.Context, objectStr string, signature string, host string) error {
+	ctx, span := tracer.Start(ctx, "ServiceReceiveAck")
+	defer span.End()
 
-    return s.repository.Unack(ctx, object.From, object.To)
+	var object AckSignedObject
+	err := json.Unmarshal([]byte(objectStr), &object)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if object.Type != "ack" && object.Type != "unack" {
+		return fmt.Errorf("object is not ack or unack")
+	}
+	if object.SignedAt.IsZero() {
+		return fmt.Errorf("signedAt is required")
+	}
+
+	if !s.IsUserExists(ctx, object.To) {
+		return fmt.Errorf("ack destination is not local")
+	}
+
+	home, err := s.ResolveHost(ctx, object.From)
+	if err != nil || home != host {
+		return errors.Wrap(ErrPermissionDenied, "ack author does not live on the requesting domain")
+	}
+
+	err = s.key.VerifyRemoteSignature(ctx, host, objectStr, object.From, object.KeyID, signature)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	err = s.storeAck(ctx, object, objectStr, signature)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// storeAck stores the verified ack or unack
+func (s *service) storeAck(ctx context.Context, object AckSignedObject, objectStr string, signature string) error {
+	return s.repository.Ack(ctx, &core.Ack{
+		From:      object.From,
+		To:        object.To,
+		Signature: signature,
+		Payload:   objectStr,
+		SignedAt:  object.SignedAt,
+		Unacked:   object.Type == "unack",
+	})
+}
+
+// GetAcking returns a page of the acks made by the entity
+func (s *service) GetAcking(ctx context.Context, ccid string, cursor string, limit int) (AckPage, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetAcking")
+	defer span.End()
+
+	acks, err := s.repository.GetAcking(ctx, ccid, cursor, limit+1)
+	if err != nil {
+		span.RecordError(err)
+		return AckPage{}, err
+	}
+
+	page := AckPage{Acks: acks}
+	if len(acks) > limit {
+		page.Acks = acks[:limit]
+		page.Next = page.Acks[limit-1].To
+	}
+	return page, nil
+}
+
+// GetAcker returns a page of the acks made to the entity
+func (s *service) GetAcker(ctx context.Context, ccid string, cursor string, limit int) (AckPage, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetAcker")
+	defer span.End()
+
+	acks, err := s.repository.GetAcker(ctx, ccid, cursor, limit+1)
+	if err != nil {
+		span.RecordError(err)
+		return AckPage{}, err
+	}
+
+	page := AckPage{Acks: acks}
+	if len(acks) > limit {
+		page.Acks = acks[:limit]
+		page.Next = page.Acks[limit-1].From
+	}
+	return page, nil
 }
 
+// verifyAck parses the signed object and checks its type and signature
+func (s *service) verifyAck(ctx context.Context, objectStr string, signature string, ackType string) (AckSignedObject, error) {
+	var object AckSignedObject
+	err := json.Unmarshal([]byte(objectStr), &object)
+	if err != nil {
+		return object, err
+	}
 
+	if object.Type != ackType {
+		return object, fmt.Errorf("object is not %v", ackType)
+	}
+	if object.SignedAt.IsZero() {
+		return object, fmt.Errorf("signedAt is required")
+	}
+
+	err = s.key.VerifySignature(ctx, objectStr, object.From, object.KeyID, signature, "", nil)
+	if err != nil {
+		return object, err
+	}
+
+	return object, nil
+}
+
+// forwardAck delivers the signed ack to the domain of the destination if it is not here
+func (s *service) forwardAck(ctx context.Context, host string, objectStr string, signature string) error {
+	if host == s.config.Concurrent.FQDN {
+		return nil
+	}
+	return s.outbox.Enqueue(ctx, host, "/ack/forward", ackPacket{
+		SignedObject: objectStr,
+		Signature:    signature,
+	})
+}
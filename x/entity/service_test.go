@@ -0,0 +1,239 @@
+package entity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/outbox"
+	"github.com/totegamma/concurrent/x/util"
+	"gorm.io/gorm"
+)
+
+type fakeRepository struct {
+	Repository
+	entities map[string]core.Entity
+	acks     map[string]core.Ack
+}
+
+func (f *fakeRepository) Get(ctx context.Context, id string) (core.Entity, error) {
+	entity, ok := f.entities[id]
+	if !ok {
+		return core.Entity{}, gorm.ErrRecordNotFound
+	}
+	return entity, nil
+}
+
+func (f *fakeRepository) Ack(ctx context.Context, ack *core.Ack) error {
+	key := ack.From + ":" + ack.To
+	if current, ok := f.acks[key]; ok && !current.SignedAt.Before(ack.SignedAt) {
+		return ErrOutdated
+	}
+	f.acks[key] = *ack
+	return nil
+}
+
+func (f *fakeRepository) list(match func(core.Ack) (string, bool), after string, limit int) []core.Ack {
+	acks := []core.Ack{}
+	for _, ack := range f.acks {
+		if other, ok := match(ack); ok && !ack.Unacked && other > after {
+			acks = append(acks, ack)
+		}
+	}
+	sort.Slice(acks, func(i, j int) bool {
+		a, _ := match(acks[i])
+		b, _ := match(acks[j])
+		return a < b
+	})
+	if len(acks) > limit {
+		acks = acks[:limit]
+	}
+	return acks
+}
+
+func (f *fakeRepository) GetAcking(ctx context.Context, from string, after string, limit int) ([]core.Ack, error) {
+	return f.list(func(ack core.Ack) (string, bool) { return ack.To, ack.From == from }, after, limit), nil
+}
+
+func (f *fakeRepository) GetAcker(ctx context.Context, to string, after string, limit int) ([]core.Ack, error) {
+	return f.list(func(ack core.Ack) (string, bool) { return ack.From, ack.To == to }, after, limit), nil
+}
+
+type fakeKey struct {
+	key.Service
+}
+
+func (f fakeKey) VerifySignature(ctx context.Context, objectStr string, signer string, keyID string, signature string, scope string, targets []string) error {
+	return nil
+}
+
+func (f fakeKey) VerifyRemoteSignature(ctx context.Context, host string, objectStr string, signer string, keyID string, signature string) error {
+	return nil
+}
+
+type delivery struct {
+	host string
+	path string
+}
+
+type fakeOutbox struct {
+	outbox.Service
+	deliveries *[]delivery
+}
+
+func (f fakeOutbox) Enqueue(ctx context.Context, host string, path string, payload interface{}) error {
+	*f.deliveries = append(*f.deliveries, delivery{host, path})
+	return nil
+}
+
+const (
+	localUser  = "CC0000000000000000000000000000000000000001"
+	localUser2 = "CC0000000000000000000000000000000000000002"
+	remoteUser = "CC0000000000000000000000000000000000000003"
+)
+
+func newTestService() (*service, *fakeRepository, *[]delivery) {
+	repo := &fakeRepository{
+		entities: map[string]core.Entity{
+			localUser:  {ID: localUser},
+			localUser2: {ID: localUser2},
+			remoteUser: {ID: remoteUser, Domain: "remote.example.com"},
+		},
+		acks: map[string]core.Ack{},
+	}
+	deliveries := &[]delivery{}
+	config := util.Config{Concurrent: util.Concurrent{FQDN: "local.example.com"}}
+	return &service{repo, config, fakeKey{}, fakeOutbox{deliveries: deliveries}}, repo, deliveries
+}
+
+func ackObject(t *testing.T, ackType string, from string, to string, signedAt time.Time) string {
+	objectStr, err := json.Marshal(AckSignedObject{Type: ackType, From: from, To: to, SignedAt: signedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(objectStr)
+}
+
+func TestAckForward(t *testing.T) {
+	s, _, deliveries := newTestService()
+	ctx := context.Background()
+	now := time.Now()
+
+	err := s.Ack(ctx, ackObject(t, "ack", localUser, localUser2, now), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*deliveries) != 0 {
+		t.Errorf("acks between local entities must not be forwarded: %v", *deliveries)
+	}
+
+	err = s.Ack(ctx, ackObject(t, "ack", localUser, remoteUser, now), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Unack(ctx, ackObject(t, "unack", localUser, remoteUser, now.Add(time.Second)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []delivery{{"remote.example.com", "/ack/forward"}, {"remote.example.com", "/ack/forward"}}
+	if fmt.Sprint(*deliveries) != fmt.Sprint(expected) {
+		t.Errorf("unexpected deliveries: %v", *deliveries)
+	}
+}
+
+func TestReceiveAck(t *testing.T) {
+	s, repo, _ := newTestService()
+	ctx := context.Background()
+	now := time.Now()
+
+	// only the home domain of the author can deliver its acks
+	err := s.ReceiveAck(ctx, ackObject(t, "ack", remoteUser, localUser, now), "", "other.example.com")
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+
+	err = s.ReceiveAck(ctx, ackObject(t, "ack", remoteUser, localUser, now), "", "remote.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the destination must live here
+	err = s.ReceiveAck(ctx, ackObject(t, "ack", localUser, remoteUser, now), "", "local.example.com")
+	if err == nil {
+		t.Error("expected error for remote destination")
+	}
+
+	err = s.ReceiveAck(ctx, ackObject(t, "unack", remoteUser, localUser, now.Add(time.Second)), "", "remote.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a replayed ack can not undo the newer unack
+	err = s.ReceiveAck(ctx, ackObject(t, "ack", remoteUser, localUser, now), "", "remote.example.com")
+	if !errors.Is(err, ErrOutdated) {
+		t.Errorf("expected outdated, got %v", err)
+	}
+	if !repo.acks[remoteUser+":"+localUser].Unacked {
+		t.Error("unack was overwritten by an older ack")
+	}
+}
+
+func TestAckPaging(t *testing.T) {
+	s, repo, _ := newTestService()
+	ctx := context.Background()
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		to := fmt.Sprintf("CC00000000000000000000000000000000000001%02d", i)
+		repo.acks[localUser+":"+to] = core.Ack{From: localUser, To: to, SignedAt: now}
+	}
+	// unacked pairs are not listed
+	repo.acks[localUser+":"+localUser2] = core.Ack{From: localUser, To: localUser2, SignedAt: now, Unacked: true}
+
+	collected := []string{}
+	cursor := ""
+	pages := 0
+	for {
+		page, err := s.GetAcking(ctx, localUser, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, ack := range page.Acks {
+			collected = append(collected, ack.To)
+		}
+		if page.Next == "" {
+			break
+		}
+		if page.Next != page.Acks[len(page.Acks)-1].To {
+			t.Fatalf("next must be the last returned destination, got %v", page.Next)
+		}
+		cursor = page.Next
+	}
+	if pages != 3 || len(collected) != 5 || !sort.StringsAreSorted(collected) {
+		t.Errorf("unexpected pages %d: %v", pages, collected)
+	}
+
+	// a page exactly filling the limit has no next page
+	page, err := s.GetAcking(ctx, localUser, "", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Acks) != 5 || page.Next != "" {
+		t.Errorf("unexpected page: %d acks, next %q", len(page.Acks), page.Next)
+	}
+
+	page, err = s.GetAcker(ctx, localUser2, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Acks) != 0 || page.Next != "" {
+		t.Errorf("unacked pair was listed: %v", page.Acks)
+	}
+}
@@ -0,0 +1,154 @@
+// Package key handles delegation and rotation of user keys
+package key
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/totegamma/concurrent/x/util"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+)
+
+var tracer = otel.Tracer("key")
+
+// Handler is the interface for handling HTTP requests
+type Handler interface {
+	Grant(c echo.Context) error
+	ListGrants(c echo.Context) error
+	Revoke(c echo.Context) error
+	GetKeyChain(c echo.Context) error
+	AppendKeyChain(c echo.Context) error
+}
+
+type handler struct {
+	service Service
+}
+
+// NewHandler creates a new handler
+func NewHandler(service Service) Handler {
+	return &handler{service: service}
+}
+
+// Grant creates a new delegation to a sub-key
+func (h handler) Grant(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerGrant")
+	defer span.End()
+
+	var request grantRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	var object GrantSignedObject
+	err = json.Unmarshal([]byte(request.SignedObject), &object)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+	if object.Signer != claims.Audience {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action"})
+	}
+
+	created, err := h.service.Grant(ctx, request.SignedObject, request.Signature, request.SubkeySignature)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{"status": "ok", "content": created})
+}
+
+// ListGrants returns grants of the requester
+func (h handler) ListGrants(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerListGrants")
+	defer span.End()
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+
+	grants, err := h.service.ListGrants(ctx, claims.Audience)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": grants})
+}
+
+// Revoke revokes a grant of the requester
+func (h handler) Revoke(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerRevoke")
+	defer span.End()
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+	id := c.Param("id")
+
+	err := h.service.Revoke(ctx, claims.Audience, id)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "grant not found"})
+		}
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
+
+// GetKeyChain returns the key chain of the entity
+func (h handler) GetKeyChain(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerGetKeyChain")
+	defer span.End()
+
+	id := c.Param("id")
+
+	chain, err := h.service.GetKeyChain(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "entity not found"})
+		}
+		return err
+	}
+
+	active, err := ValidateKeyChain(id, chain)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": echo.Map{"chain": chain, "active": active}})
+}
+
+// AppendKeyChain enacts or revokes a subkey of the requester
+func (h handler) AppendKeyChain(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerAppendKeyChain")
+	defer span.End()
+
+	var request keyChainRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	var object KeyChainSignedObject
+	err = json.Unmarshal([]byte(request.SignedObject), &object)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	claims := c.Get("jwtclaims").(util.JwtClaims)
+	if object.Signer != claims.Audience {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action"})
+	}
+
+	chain, err := h.service.AppendKeyChain(ctx, request.SignedObject, request.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request", "message": err.Error()})
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{"status": "ok", "content": chain})
+}
@@ -0,0 +1,61 @@
+package key
+
+import (
+	"time"
+)
+
+// Scopes which can be delegated to a sub-key
+// A scope can be narrowed to a specific target by appending ":<target>"
+// (e.g. "message:post:<streamID>", "character:put:<schema>", "collection:write:<collectionID>")
+const (
+	ScopeMessagePost     = "message:post"
+	ScopeCharacterPut    = "character:put"
+	ScopeKVRead          = "kv:read"
+	ScopeKVWrite         = "kv:write"
+	ScopeCollectionWrite = "collection:write"
+)
+
+var knownScopes = []string{ScopeMessagePost, ScopeCharacterPut, ScopeKVRead, ScopeKVWrite, ScopeCollectionWrite}
+
+type grantRequest struct {
+	SignedObject    string `json:"signedObject"`
+	Signature       string `json:"signature"`
+	SubkeySignature string `json:"subkeySignature"` // proof that the requester holds the sub-key
+}
+
+type keyChainRequest struct {
+	SignedObject string `json:"signedObject"`
+	Signature    string `json:"signature"`
+}
+
+// GrantSignedObject is user signed delegation
+type GrantSignedObject struct {
+	Signer    string    `json:"signer"`
+	Type      string    `json:"type"`
+	Subkey    string    `json:"subkey"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	SignedAt  time.Time `json:"signedAt"`
+}
+
+// Key chain operations
+const (
+	KeyChainEnact  = "enact"
+	KeyChainRevoke = "revoke"
+)
+
+// KeyChainEntry is one signed operation of the key chain
+type KeyChainEntry struct {
+	SignedObject string `json:"signedObject"`
+	Signature    string `json:"signature"`
+}
+
+// KeyChainSignedObject enacts or revokes a subkey of the entity
+// it must be signed by the master key or another subkey which is active at that point
+type KeyChainSignedObject struct {
+	Signer   string    `json:"signer"`
+	KeyID    string    `json:"keyID"`
+	Type     string    `json:"type"`
+	Target   string    `json:"target"`
+	SignedAt time.Time `json:"signedAt"`
+}
@@ -0,0 +1,112 @@
+package key
+
+import (
+	"context"
+	"time"
+
+	"github.com/totegamma/concurrent/x/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Repository is the interface for key repository
+type Repository interface {
+	CreateGrant(ctx context.Context, grant *core.Grant) error
+	GetGrant(ctx context.Context, id string) (core.Grant, error)
+	GetActiveGrantBySubkey(ctx context.Context, subkey string) (core.Grant, error)
+	ListGrants(ctx context.Context, owner string) ([]core.Grant, error)
+	RevokeGrant(ctx context.Context, id string) error
+	GetKeyChain(ctx context.Context, ccid string) (string, error)
+	AppendKeyChain(ctx context.Context, ccid string, update func(chain string) (string, error)) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+// NewRepository creates a new key repository
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// CreateGrant creates new grant
+func (r *repository) CreateGrant(ctx context.Context, grant *core.Grant) error {
+	ctx, span := tracer.Start(ctx, "RepositoryCreateGrant")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Create(&grant).Error
+}
+
+// GetGrant returns a grant by ID
+func (r *repository) GetGrant(ctx context.Context, id string) (core.Grant, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetGrant")
+	defer span.End()
+
+	var grant core.Grant
+	err := r.db.WithContext(ctx).First(&grant, "id = ?", id).Error
+	return grant, err
+}
+
+// GetActiveGrantBySubkey returns a not expired and not revoked grant of the sub-key
+func (r *repository) GetActiveGrantBySubkey(ctx context.Context, subkey string) (core.Grant, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetActiveGrantBySubkey")
+	defer span.End()
+
+	var grant core.Grant
+	err := r.db.WithContext(ctx).First(&grant, "subkey = ? AND expires_at > ? AND revoked_at IS NULL", subkey, time.Now()).Error
+	return grant, err
+}
+
+// ListGrants returns all grants of the owner
+func (r *repository) ListGrants(ctx context.Context, owner string) ([]core.Grant, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryListGrants")
+	defer span.End()
+
+	var grants []core.Grant
+	err := r.db.WithContext(ctx).Where("owner = ?", owner).Order("c_date desc").Find(&grants).Error
+	return grants, err
+}
+
+// RevokeGrant records the revocation time of a grant
+// The grant is kept so that the sub-key can not be granted again.
+func (r *repository) RevokeGrant(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "RepositoryRevokeGrant")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Model(&core.Grant{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now()).Error
+}
+
+// GetKeyChain returns the key chain of the entity
+func (r *repository) GetKeyChain(ctx context.Context, ccid string) (string, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetKeyChain")
+	defer span.End()
+
+	var entity core.Entity
+	err := r.db.WithContext(ctx).Select("key_chain").First(&entity, "id = ?", ccid).Error
+	return entity.KeyChain, err
+}
+
+// AppendKeyChain replaces the key chain of the entity with the result of update
+// The entity is locked until the update is stored.
+func (r *repository) AppendKeyChain(ctx context.Context, ccid string, update func(chain string) (string, error)) error {
+	ctx, span := tracer.Start(ctx, "RepositoryAppendKeyChain")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var entity core.Entity
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "key_chain").First(&entity, "id = ?", ccid).Error
+		if err != nil {
+			return err
+		}
+
+		chain, err := update(entity.KeyChain)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&core.Entity{}).Where("id = ?", ccid).Updates(map[string]interface{}{
+			"key_chain": chain,
+			"m_date":    time.Now(),
+		}).Error
+	})
+}
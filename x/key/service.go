@@ -0,0 +1,449 @@
+package key
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/util"
+	"golang.org/x/exp/slices"
+	"gorm.io/gorm"
+)
+
+// Service is the interface for key service
+type Service interface {
+	Grant(ctx context.Context, objectStr string, signature string, subkeySignature string) (core.Grant, error)
+	GetGrant(ctx context.Context, subkey string) (core.Grant, error)
+	ListGrants(ctx context.Context, owner string) ([]core.Grant, error)
+	Revoke(ctx context.Context, owner string, id string) error
+	Authorize(ctx context.Context, owner string, subkey string, scope string, target string) error
+	VerifySignature(ctx context.Context, objectStr string, signer string, keyID string, signature string, scope string, targets []string) error
+	GetKeyChain(ctx context.Context, ccid string) ([]KeyChainEntry, error)
+	AppendKeyChain(ctx context.Context, objectStr string, signature string) ([]KeyChainEntry, error)
+	ActiveKeys(ctx context.Context, ccid string) ([]string, error)
+	VerifyRemoteSignature(ctx context.Context, host string, objectStr string, signer string, keyID string, signature string) error
+}
+
+type service struct {
+	repository Repository
+}
+
+// NewService creates a new key service
+func NewService(repository Repository) Service {
+	return &service{repository}
+}
+
+// ScopeAllows returns true if the granted scopes contain the scope for the target
+// A scope without target allows every target
+// If the target is empty, any granted scope of the same kind allows it
+func ScopeAllows(granted []string, scope string, target string) bool {
+	for _, g := range granted {
+		if g == scope {
+			return true
+		}
+		if !strings.HasPrefix(g, scope+":") {
+			continue
+		}
+		if target == "" || g == scope+":"+target {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant creates new delegation if the signature of the owner is valid
+// The same object must also be signed by the sub-key, so that keys of others can not be claimed.
+func (s *service) Grant(ctx context.Context, objectStr string, signature string, subkeySignature string) (core.Grant, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGrant")
+	defer span.End()
+
+	var object GrantSignedObject
+	err := json.Unmarshal([]byte(objectStr), &object)
+	if err != nil {
+		span.RecordError(err)
+		return core.Grant{}, err
+	}
+
+	if object.Type != "grant" {
+		return core.Grant{}, fmt.Errorf("object is not grant")
+	}
+
+	if err := util.VerifySignedObject(objectStr, object.Signer, signature); err != nil {
+		span.RecordError(err)
+		return core.Grant{}, err
+	}
+
+	chain, err := s.GetKeyChain(ctx, object.Signer)
+	if err != nil {
+		span.RecordError(err)
+		return core.Grant{}, err
+	}
+	if _, revoked := MasterRevokedAt(object.Signer, chain); revoked {
+		return core.Grant{}, fmt.Errorf("master key of %v is revoked", object.Signer)
+	}
+
+	if object.Subkey == "" || object.Subkey == object.Signer {
+		return core.Grant{}, fmt.Errorf("invalid subkey")
+	}
+
+	if err := util.VerifySignedObject(objectStr, object.Subkey, subkeySignature); err != nil {
+		span.RecordError(err)
+		return core.Grant{}, fmt.Errorf("subkey signature is invalid")
+	}
+
+	if object.ExpiresAt.Before(time.Now()) {
+		return core.Grant{}, fmt.Errorf("grant is already expired")
+	}
+
+	if len(object.Scopes) == 0 {
+		return core.Grant{}, fmt.Errorf("scopes are required")
+	}
+	for _, scope := range object.Scopes {
+		split := strings.SplitN(scope, ":", 3)
+		if len(split) < 2 || !slices.Contains(knownScopes, split[0]+":"+split[1]) {
+			return core.Grant{}, fmt.Errorf("unknown scope: %v", scope)
+		}
+	}
+
+	grant := core.Grant{
+		Owner:     object.Signer,
+		Subkey:    object.Subkey,
+		Scopes:    object.Scopes,
+		ExpiresAt: object.ExpiresAt,
+		Payload:   objectStr,
+		Signature: signature,
+	}
+
+	err = s.repository.CreateGrant(ctx, &grant)
+	if err != nil {
+		span.RecordError(err)
+		return core.Grant{}, err
+	}
+
+	return grant, nil
+}
+
+// GetGrant returns the active grant of the sub-key
+func (s *service) GetGrant(ctx context.Context, subkey string) (core.Grant, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetGrant")
+	defer span.End()
+
+	return s.repository.GetActiveGrantBySubkey(ctx, subkey)
+}
+
+// ListGrants returns all grants of the owner
+func (s *service) ListGrants(ctx context.Context, owner string) ([]core.Grant, error) {
+	ctx, span := tracer.Start(ctx, "ServiceListGrants")
+	defer span.End()
+
+	return s.repository.ListGrants(ctx, owner)
+}
+
+// Revoke revokes the grant
+// only the owner of the grant can revoke it. Tokens issued for the sub-key are rejected from then on.
+func (s *service) Revoke(ctx context.Context, owner string, id string) error {
+	ctx, span := tracer.Start(ctx, "ServiceRevoke")
+	defer span.End()
+
+	grant, err := s.repository.GetGrant(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if grant.Owner != owner {
+		return fmt.Errorf("you are not owner of this grant")
+	}
+
+	return s.repository.RevokeGrant(ctx, id)
+}
+
+// Authorize checks the sub-key is allowed to act for the owner within the scope
+func (s *service) Authorize(ctx context.Context, owner string, subkey string, scope string, target string) error {
+	ctx, span := tracer.Start(ctx, "ServiceAuthorize")
+	defer span.End()
+
+	grant, err := s.repository.GetActiveGrantBySubkey(ctx, subkey)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("no active grant for the key")
+	}
+
+	if grant.Owner != owner {
+		return fmt.Errorf("the key is not granted by the signer")
+	}
+
+	if !ScopeAllows(grant.Scopes, scope, target) {
+		return fmt.Errorf("the key is not allowed to %v %v", scope, target)
+	}
+
+	return nil
+}
+
+// VerifySignature verifies signature of the signed object
+// If keyID is the signer itself or empty, the signature must be made by the signer whose master key is not revoked
+// Otherwise the signature must be made by keyID, and keyID must be an active subkey of the signer,
+// or be granted the scope for every target by the signer
+func (s *service) VerifySignature(ctx context.Context, objectStr string, signer string, keyID string, signature string, scope string, targets []string) error {
+	ctx, span := tracer.Start(ctx, "ServiceVerifySignature")
+	defer span.End()
+
+	chain, err := s.GetKeyChain(ctx, signer)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// entities not registered here have no key chain
+		chain = []KeyChainEntry{}
+	} else if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if keyID == "" || keyID == signer {
+		if _, revoked := MasterRevokedAt(signer, chain); revoked {
+			return fmt.Errorf("master key of %v is revoked", signer)
+		}
+		return util.VerifySignedObject(objectStr, signer, signature)
+	}
+
+	err = util.VerifySignedObject(objectStr, keyID, signature)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// subkeys in the key chain have full authority of the signer
+	active, err := ValidateKeyChain(signer, chain)
+	if err == nil && slices.Contains(active, keyID) {
+		return nil
+	}
+
+	if len(targets) == 0 {
+		return s.Authorize(ctx, signer, keyID, scope, "")
+	}
+	for _, target := range targets {
+		err = s.Authorize(ctx, signer, keyID, scope, target)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateKeyChain verifies every entry of the key chain in order and returns the active subkeys
+// Each entry must be signed by the master key or a subkey which is active at that point.
+// A subkey can revoke the master key to recover the identity, after which the master key can not sign
+// and at least one subkey must stay active.
+func ValidateKeyChain(ccid string, chain []KeyChainEntry) ([]string, error) {
+	active := []string{}
+	masterRevoked := false
+	var last time.Time
+	for i, entry := range chain {
+		var object KeyChainSignedObject
+		err := json.Unmarshal([]byte(entry.SignedObject), &object)
+		if err != nil {
+			return nil, err
+		}
+
+		if object.Signer != ccid {
+			return nil, fmt.Errorf("entry %d is not signed for %v", i, ccid)
+		}
+		if object.KeyID == ccid && masterRevoked {
+			return nil, fmt.Errorf("entry %d is signed by revoked master key", i)
+		}
+		if object.KeyID != ccid && !slices.Contains(active, object.KeyID) {
+			return nil, fmt.Errorf("entry %d is signed by inactive key", i)
+		}
+		if object.SignedAt.Before(last) {
+			return nil, fmt.Errorf("entry %d is out of order", i)
+		}
+		last = object.SignedAt
+
+		err = util.VerifySignedObject(entry.SignedObject, object.KeyID, entry.Signature)
+		if err != nil {
+			return nil, err
+		}
+
+		switch object.Type {
+		case KeyChainEnact:
+			if object.Target == "" || object.Target == ccid {
+				return nil, fmt.Errorf("entry %d has invalid target", i)
+			}
+			if !slices.Contains(active, object.Target) {
+				active = append(active, object.Target)
+			}
+		case KeyChainRevoke:
+			if object.Target == ccid {
+				if masterRevoked || object.KeyID == ccid {
+					return nil, fmt.Errorf("entry %d revokes master key without a subkey", i)
+				}
+				masterRevoked = true
+				continue
+			}
+			idx := slices.Index(active, object.Target)
+			if idx < 0 {
+				return nil, fmt.Errorf("entry %d revokes inactive key", i)
+			}
+			active = slices.Delete(active, idx, idx+1)
+			if masterRevoked && len(active) == 0 {
+				return nil, fmt.Errorf("entry %d revokes the last key", i)
+			}
+		default:
+			return nil, fmt.Errorf("entry %d has unknown type: %v", i, object.Type)
+		}
+	}
+	return active, nil
+}
+
+// MasterRevokedAt returns when the master key was revoked in the validated key chain
+func MasterRevokedAt(ccid string, chain []KeyChainEntry) (time.Time, bool) {
+	for _, entry := range chain {
+		var object KeyChainSignedObject
+		err := json.Unmarshal([]byte(entry.SignedObject), &object)
+		if err != nil {
+			continue
+		}
+		if object.Type == KeyChainRevoke && object.Target == ccid {
+			return object.SignedAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// MasterKeyValid reports whether the object signed by the master key was signed before its revocation
+// Objects signed by the master key are kept valid after recovery, as older content is signed by it.
+func MasterKeyValid(ccid string, chain []KeyChainEntry, objectStr string) bool {
+	revokedAt, revoked := MasterRevokedAt(ccid, chain)
+	if !revoked {
+		return true
+	}
+	var object struct {
+		SignedAt time.Time `json:"signedAt"`
+	}
+	err := json.Unmarshal([]byte(objectStr), &object)
+	if err != nil || object.SignedAt.IsZero() {
+		return false
+	}
+	return object.SignedAt.Before(revokedAt)
+}
+
+// GetKeyChain returns the key chain of the entity
+func (s *service) GetKeyChain(ctx context.Context, ccid string) ([]KeyChainEntry, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetKeyChain")
+	defer span.End()
+
+	chainStr, err := s.repository.GetKeyChain(ctx, ccid)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	chain := []KeyChainEntry{}
+	if chainStr == "" || chainStr == "null" {
+		return chain, nil
+	}
+	err = json.Unmarshal([]byte(chainStr), &chain)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return chain, nil
+}
+
+// ActiveKeys returns the subkeys of the entity which are enacted and not revoked
+func (s *service) ActiveKeys(ctx context.Context, ccid string) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "ServiceActiveKeys")
+	defer span.End()
+
+	chain, err := s.GetKeyChain(ctx, ccid)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return ValidateKeyChain(ccid, chain)
+}
+
+// AppendKeyChain appends signed enact or revoke operation to the key chain of the signer
+func (s *service) AppendKeyChain(ctx context.Context, objectStr string, signature string) ([]KeyChainEntry, error) {
+	ctx, span := tracer.Start(ctx, "ServiceAppendKeyChain")
+	defer span.End()
+
+	var object KeyChainSignedObject
+	err := json.Unmarshal([]byte(objectStr), &object)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	// the chain is locked while the entry is validated, so that concurrent entries do not overwrite each other
+	var chain []KeyChainEntry
+	err = s.repository.AppendKeyChain(ctx, object.Signer, func(chainStr string) (string, error) {
+		chain = []KeyChainEntry{}
+		if chainStr != "" && chainStr != "null" {
+			err := json.Unmarshal([]byte(chainStr), &chain)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		chain = append(chain, KeyChainEntry{
+			SignedObject: objectStr,
+			Signature:    signature,
+		})
+
+		_, err := ValidateKeyChain(object.Signer, chain)
+		if err != nil {
+			return "", err
+		}
+
+		updated, err := json.Marshal(chain)
+		return string(updated), err
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// VerifyRemoteSignature verifies the signature of an object fetched from the signer's domain
+// Keys are checked against the key chain served by the domain, which is validated here.
+func (s *service) VerifyRemoteSignature(ctx context.Context, host string, objectStr string, signer string, keyID string, signature string) error {
+	ctx, span := tracer.Start(ctx, "ServiceVerifyRemoteSignature")
+	defer span.End()
+
+	var response struct {
+		Content struct {
+			Chain []KeyChainEntry `json:"chain"`
+		} `json:"content"`
+	}
+	_, err := util.FetchRemote(ctx, host, "/key/chain/"+signer, "", &response)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	active, err := ValidateKeyChain(signer, response.Content.Chain)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if keyID == "" || keyID == signer {
+		if !MasterKeyValid(signer, response.Content.Chain, objectStr) {
+			return fmt.Errorf("object is signed after the master key of %v is revoked", signer)
+		}
+		return util.VerifySignedObject(objectStr, signer, signature)
+	}
+
+	if !slices.Contains(active, keyID) {
+		return fmt.Errorf("key %v is not active for %v", keyID, signer)
+	}
+
+	return util.VerifySignedObject(objectStr, keyID, signature)
+}
@@ -0,0 +1,202 @@
+package key
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/totegamma/concurrent/x/util"
+	"gorm.io/gorm"
+)
+
+type testKey struct {
+	privatekey string
+	ccid       string
+}
+
+func newTestKey(t *testing.T) testKey {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{
+		privatekey: hex.EncodeToString(crypto.FromECDSA(key)),
+		ccid:       "CC" + crypto.PubkeyToAddress(key.PublicKey).Hex()[2:],
+	}
+}
+
+func chainEntry(t *testing.T, signer string, by testKey, op string, target string, at time.Time) KeyChainEntry {
+	object := fmt.Sprintf(`{"signer":"%s","keyID":"%s","type":"%s","target":"%s","signedAt":"%s"}`, signer, by.ccid, op, target, at.Format(time.RFC3339))
+	signature, err := util.SignBytes([]byte(object), by.privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return KeyChainEntry{SignedObject: object, Signature: signature}
+}
+
+func TestValidateKeyChain(t *testing.T) {
+	master := newTestKey(t)
+	sub1 := newTestKey(t)
+	sub2 := newTestKey(t)
+	now := time.Now()
+
+	// master enacts sub1, sub1 recovers the identity by enacting sub2 and revoking itself
+	chain := []KeyChainEntry{
+		chainEntry(t, master.ccid, master, KeyChainEnact, sub1.ccid, now),
+		chainEntry(t, master.ccid, sub1, KeyChainEnact, sub2.ccid, now.Add(time.Minute)),
+		chainEntry(t, master.ccid, sub2, KeyChainRevoke, sub1.ccid, now.Add(2*time.Minute)),
+	}
+
+	active, err := ValidateKeyChain(master.ccid, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0] != sub2.ccid {
+		t.Errorf("expected only %s to be active, got %v", sub2.ccid, active)
+	}
+
+	// revoked key can not sign anymore
+	chain = append(chain, chainEntry(t, master.ccid, sub1, KeyChainEnact, sub1.ccid, now.Add(3*time.Minute)))
+	_, err = ValidateKeyChain(master.ccid, chain)
+	if err == nil {
+		t.Error("expected error for entry signed by revoked key")
+	}
+
+	// unknown key can not enact
+	_, err = ValidateKeyChain(master.ccid, []KeyChainEntry{
+		chainEntry(t, master.ccid, sub1, KeyChainEnact, sub2.ccid, now),
+	})
+	if err == nil {
+		t.Error("expected error for entry signed by unknown key")
+	}
+}
+
+func TestRevokeMasterKey(t *testing.T) {
+	master := newTestKey(t)
+	sub1 := newTestKey(t)
+	sub2 := newTestKey(t)
+	now := time.Now()
+
+	// sub1 recovers the identity by revoking the master key
+	chain := []KeyChainEntry{
+		chainEntry(t, master.ccid, master, KeyChainEnact, sub1.ccid, now),
+		chainEntry(t, master.ccid, sub1, KeyChainRevoke, master.ccid, now.Add(time.Minute)),
+	}
+	active, err := ValidateKeyChain(master.ccid, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0] != sub1.ccid {
+		t.Errorf("expected only %s to be active, got %v", sub1.ccid, active)
+	}
+	revokedAt, revoked := MasterRevokedAt(master.ccid, chain)
+	if !revoked || !revokedAt.Equal(now.Add(time.Minute).Truncate(time.Second)) {
+		t.Errorf("unexpected revocation: %v %v", revokedAt, revoked)
+	}
+
+	// revoked master key can not sign anymore
+	_, err = ValidateKeyChain(master.ccid, append(chain, chainEntry(t, master.ccid, master, KeyChainEnact, sub2.ccid, now.Add(2*time.Minute))))
+	if err == nil {
+		t.Error("expected error for entry signed by revoked master key")
+	}
+
+	// the last subkey can not be revoked once the master key is revoked
+	_, err = ValidateKeyChain(master.ccid, append(chain, chainEntry(t, master.ccid, sub1, KeyChainRevoke, sub1.ccid, now.Add(2*time.Minute))))
+	if err == nil {
+		t.Error("expected error for revoking the last key")
+	}
+
+	// master key can not revoke itself
+	_, err = ValidateKeyChain(master.ccid, []KeyChainEntry{
+		chainEntry(t, master.ccid, master, KeyChainRevoke, master.ccid, now),
+	})
+	if err == nil {
+		t.Error("expected error for master key revoking itself")
+	}
+
+	// objects signed by the master key before the revocation stay valid
+	before := fmt.Sprintf(`{"signer":"%s","signedAt":"%s"}`, master.ccid, now.Format(time.RFC3339))
+	after := fmt.Sprintf(`{"signer":"%s","signedAt":"%s"}`, master.ccid, now.Add(2*time.Minute).Format(time.RFC3339))
+	if !MasterKeyValid(master.ccid, chain, before) {
+		t.Error("object signed before the revocation must be valid")
+	}
+	if MasterKeyValid(master.ccid, chain, after) {
+		t.Error("object signed after the revocation must be invalid")
+	}
+}
+
+type fakeRepository struct {
+	Repository
+	chains map[string]string
+}
+
+func (f *fakeRepository) GetKeyChain(ctx context.Context, ccid string) (string, error) {
+	chain, ok := f.chains[ccid]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	return chain, nil
+}
+
+func (f *fakeRepository) AppendKeyChain(ctx context.Context, ccid string, update func(chain string) (string, error)) error {
+	chain, err := f.GetKeyChain(ctx, ccid)
+	if err != nil {
+		return err
+	}
+	chain, err = update(chain)
+	if err != nil {
+		return err
+	}
+	f.chains[ccid] = chain
+	return nil
+}
+
+func TestVerifySignatureWithRevokedMaster(t *testing.T) {
+	master := newTestKey(t)
+	sub := newTestKey(t)
+	now := time.Now()
+	ctx := context.Background()
+
+	repo := &fakeRepository{chains: map[string]string{master.ccid: ""}}
+	s := NewService(repo)
+
+	enact := chainEntry(t, master.ccid, master, KeyChainEnact, sub.ccid, now)
+	_, err := s.AppendKeyChain(ctx, enact.SignedObject, enact.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object := fmt.Sprintf(`{"signer":"%s","signedAt":"%s"}`, master.ccid, now.Format(time.RFC3339))
+	signature, err := util.SignBytes([]byte(object), master.privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.VerifySignature(ctx, object, master.ccid, "", signature, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoke := chainEntry(t, master.ccid, sub, KeyChainRevoke, master.ccid, now.Add(time.Minute))
+	_, err = s.AppendKeyChain(ctx, revoke.SignedObject, revoke.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.VerifySignature(ctx, object, master.ccid, "", signature, "", nil)
+	if err == nil {
+		t.Error("expected error for the revoked master key")
+	}
+
+	subObject := fmt.Sprintf(`{"signer":"%s","keyID":"%s"}`, master.ccid, sub.ccid)
+	subSignature, err := util.SignBytes([]byte(subObject), sub.privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.VerifySignature(ctx, subObject, master.ccid, sub.ccid, subSignature, "", nil)
+	if err != nil {
+		t.Errorf("subkey must keep signing for the entity: %v", err)
+	}
+}
@@ -17,7 +17,9 @@ var tracer = otel.Tracer("message")
 type Handler interface {
     Get(c echo.Context) error
     Post(c echo.Context) error
+    Update(c echo.Context) error
     Delete(c echo.Context) error
+    Invalidate(c echo.Context) error
 }
 
 type handler struct {
@@ -30,6 +32,8 @@ func NewHandler(service Service) Handler {
 }
 
 // Get returns an message by ID
+// If the message is not stored here and the host query is given, it is fetched from the host.
+// Only messages posted to a stream the requester can read are returned.
 func (h handler) Get(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerGet")
 	defer span.End()
@@ -37,15 +41,63 @@ func (h handler) Get(c echo.Context) error {
 	id := c.Param("id")
 
 	message, err := h.service.Get(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) && c.QueryParam("host") != "" {
+		message, err = h.service.GetRemote(ctx, c.QueryParam("host"), id, requester(c))
+		if errors.Is(err, util.ErrRemoteNotFound) || errors.Is(err, util.ErrUnknownRemote) {
+			err = gorm.ErrRecordNotFound
+		} else if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusBadGateway, echo.Map{"error": err.Error()})
+		}
+	}
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.JSON(http.StatusNotFound, echo.Map{"error": "Message not found"})
 		}
 		return err
 	}
+	if !h.service.CanRead(ctx, message, requester(c)) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "You are not allowed to read this message"})
+	}
 	return c.JSON(http.StatusOK, message)
 }
 
+// requester returns the entity of the request, or empty string for anonymous requests
+func requester(c echo.Context) string {
+	claims, ok := c.Get("jwtclaims").(util.JwtClaims)
+	if !ok || claims.Subject != "CONCURRENT_API" {
+		return ""
+	}
+	return claims.Audience
+}
+
+// Invalidate drops the cached copy of a remote message
+// Called by the home domain of the message when it is edited or deleted.
+// On edits, the streams of this domain holding the message are notified of the update.
+// Only the copy cached from the requesting domain is dropped.
+func (h handler) Invalidate(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerInvalidate")
+	defer span.End()
+
+	var request invalidateRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	host, ok := c.Get("requesterHost").(string)
+	if !ok {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "requesting domain is not known"})
+	}
+
+	err = h.service.InvalidateRemote(ctx, host, request.ID, request.Streams)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
+
 // Post creates a new message
 // returns the created message
 func (h handler) Post(c echo.Context) error {
@@ -64,6 +116,37 @@ func (h handler) Post(c echo.Context) error {
 	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": message})
 }
 
+// Update edits a message with the signed edit object
+// returns the updated message with its revisions
+func (h handler) Update(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerUpdate")
+	defer span.End()
+
+	messageID := c.Param("id")
+
+	var request updateRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	updated, err := h.service.UpdateMessage(ctx, messageID, request.SignedObject, request.Signature)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "you are not authorized to perform this action"})
+		}
+		if errors.Is(err, ErrOutdated) {
+			return c.JSON(http.StatusConflict, echo.Map{"error": "the message has been edited after this edit was signed"})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "target message not found"})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": updated})
+}
+
 // Delete deletes a message
 // returns the deleted message
 func (h handler) Delete(c echo.Context) error {
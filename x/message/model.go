@@ -1,8 +1,11 @@
 package message
 
 import (
-	"github.com/totegamma/concurrent/x/core"
+	"encoding/json"
+	"errors"
 	"time"
+
+	"github.com/totegamma/concurrent/x/core"
 )
 
 type streamEvent struct {
@@ -22,12 +25,41 @@ type postRequest struct {
 	Streams      []string `json:"streams"`
 }
 
+type updateRequest struct {
+	SignedObject string `json:"signedObject"`
+	Signature    string `json:"signature"`
+}
+
 // SignedObject is user sign unit
+// Target is the ID of the edited message, and must be empty for new messages.
 type SignedObject struct {
 	Signer   string      `json:"signer"`
+	KeyID    string      `json:"keyID,omitempty"`
+	Target   string      `json:"target,omitempty"`
 	Type     string      `json:"type"`
 	Schema   string      `json:"schema"`
 	Body     interface{} `json:"body"`
 	Meta     interface{} `json:"meta"`
 	SignedAt time.Time   `json:"signedAt"`
 }
+
+type invalidateRequest struct {
+	ID      string   `json:"id"`
+	Streams []string `json:"streams"` // streams of the receiving domain to notify of the update
+}
+
+// ErrPermissionDenied is returned when the signer is not the author of the message
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrOutdated is returned when the edit is not signed after the current version of the message
+var ErrOutdated = errors.New("outdated edit")
+
+// signedAt returns the signing time of the payload, or the zero time if it is not readable
+func signedAt(payload string) time.Time {
+	var object SignedObject
+	err := json.Unmarshal([]byte(payload), &object)
+	if err != nil {
+		return time.Time{}
+	}
+	return object.SignedAt
+}
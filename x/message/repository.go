@@ -4,13 +4,16 @@ import (
 	"context"
 	"github.com/totegamma/concurrent/x/core"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Repository is the interface for message repository
 type Repository interface {
     Create(ctx context.Context, message *core.Message) (string, error)
     Get(ctx context.Context, key string) (core.Message, error)
-    Delete(ctx context.Context, key string) (core.Message, error)
+    GetMulti(ctx context.Context, keys []string) ([]core.Message, error)
+    Update(ctx context.Context, message core.Message) (core.Message, error)
+    Delete(ctx context.Context, key string, bury func(core.Message) ([]core.Tombstone, error)) (core.Message, []core.Tombstone, error)
 	Total(ctx context.Context) (int64, error)
 }
 
@@ -48,16 +51,101 @@ func (r *repository) Get(ctx context.Context, key string) (core.Message, error)
 	defer span.End()
 
 	var message core.Message
-	err := r.db.WithContext(ctx).Preload("Associations").First(&message, "id = ?", key).Error
+	err := r.db.WithContext(ctx).
+		Preload("Associations").
+		Preload("Revisions", func(db *gorm.DB) *gorm.DB { return db.Order("c_date asc") }).
+		First(&message, "id = ?", key).Error
 	return message, err
 }
 
+// Update replaces the payload of the message
+// The current version is archived as a revision in the same transaction
+func (r *repository) Update(ctx context.Context, message core.Message) (core.Message, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryUpdate")
+	defer span.End()
+
+	var updated core.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current core.Message
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, "id = ?", message.ID).Error
+		if err != nil {
+			return err
+		}
+
+		// a replayed or reordered edit must not roll the message back
+		if !signedAt(message.Payload).After(signedAt(current.Payload)) {
+			return ErrOutdated
+		}
+
+		err = tx.Create(&core.MessageRevision{
+			Message:   current.ID,
+			Payload:   current.Payload,
+			Signature: current.Signature,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&current).Updates(map[string]interface{}{
+			"schema":    message.Schema,
+			"payload":   message.Payload,
+			"signature": message.Signature,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.
+			Preload("Associations").
+			Preload("Revisions", func(db *gorm.DB) *gorm.DB { return db.Order("c_date asc") }).
+			First(&updated, "id = ?", message.ID).Error
+	})
+	return updated, err
+}
+
 // Delete deletes an message
-func (r *repository) Delete(ctx context.Context, id string) (core.Message, error) {
+// Associations targeting the message are deleted with it and returned in the deleted message
+// The tombstones made by bury are stored in the same transaction.
+func (r *repository) Delete(ctx context.Context, id string, bury func(core.Message) ([]core.Tombstone, error)) (core.Message, []core.Tombstone, error) {
 	ctx, span := tracer.Start(ctx, "RepositoryDelete")
 	defer span.End()
 
 	var deleted core.Message
-	err := r.db.WithContext(ctx).Where("id = $1", id).Delete(&deleted).Error
-	return deleted, err
+	var tombstones []core.Tombstone
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Preload("Associations").First(&deleted, "id = ?", id).Error
+		if err != nil {
+			return err
+		}
+
+		// tombstones are stored with the deletion, so that a deleted object always has one
+		tombstones, err = bury(deleted)
+		if err != nil {
+			return err
+		}
+		if len(tombstones) > 0 {
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tombstones).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Where("target_id = ? AND target_type = ?", id, "messages").Delete(&core.Association{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("id = ?", id).Delete(&core.Message{}).Error
+	})
+	return deleted, tombstones, err
+}
+
+// GetMulti returns messages by IDs
+func (r *repository) GetMulti(ctx context.Context, keys []string) ([]core.Message, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetMulti")
+	defer span.End()
+
+	var messages []core.Message
+	err := r.db.WithContext(ctx).Preload("Associations").Where("id IN ?", keys).Find(&messages).Error
+	return messages, err
 }
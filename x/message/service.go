@@ -3,10 +3,17 @@ package message
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/domain"
+	"github.com/totegamma/concurrent/x/key"
+	"github.com/totegamma/concurrent/x/outbox"
 	"github.com/totegamma/concurrent/x/stream"
+	"github.com/totegamma/concurrent/x/tombstone"
 	"github.com/totegamma/concurrent/x/util"
 )
 
@@ -14,20 +21,30 @@ import (
 // Provides methods for message CRUD
 type Service interface {
     Get(ctx context.Context, id string) (core.Message, error)
+    GetMulti(ctx context.Context, ids []string) ([]core.Message, error)
+    GetRemote(ctx context.Context, host string, id string, requester string) (core.Message, error)
+    InvalidateRemote(ctx context.Context, host string, id string, streams []string) error
+    CanRead(ctx context.Context, message core.Message, requester string) bool
     PostMessage(ctx context.Context, objectStr string, signature string, streams []string) (core.Message, error)
+    UpdateMessage(ctx context.Context, id string, objectStr string, signature string) (core.Message, error)
     Delete(ctx context.Context, id string) (core.Message, error)
 	Total(ctx context.Context) (int64, error)
 }
 
 type service struct {
-	rdb    *redis.Client
-	repo   Repository
-	stream stream.Service
+	rdb       *redis.Client
+	repo      Repository
+	stream    stream.Service
+	key       key.Service
+	outbox    outbox.Service
+	domain    domain.Service
+	tombstone tombstone.Service
+	config    util.Config
 }
 
 // NewService creates a new message service
-func NewService(rdb *redis.Client, repo Repository, stream stream.Service) Service {
-	return &service{rdb, repo, stream}
+func NewService(rdb *redis.Client, repo Repository, stream stream.Service, key key.Service, outbox outbox.Service, domain domain.Service, tombstone tombstone.Service, config util.Config) Service {
+	return &service{rdb, repo, stream, key, outbox, domain, tombstone, config}
 }
 
 // Total returns the total number of messages
@@ -38,6 +55,15 @@ func (s *service) Total(ctx context.Context) (int64, error) {
 	return s.repo.Total(ctx)
 }
 
+// CanRead reports whether the requester can read the message
+// A message is readable if one of the streams it is posted to is readable.
+func (s *service) CanRead(ctx context.Context, message core.Message, requester string) bool {
+	ctx, span := tracer.Start(ctx, "ServiceCanRead")
+	defer span.End()
+
+	return s.stream.CanReadAny(ctx, message.Streams, requester)
+}
+
 // Get returns a message by ID
 func (s *service) Get(ctx context.Context, id string) (core.Message, error) {
 	ctx, span := tracer.Start(ctx, "ServiceGet")
@@ -46,6 +72,129 @@ func (s *service) Get(ctx context.Context, id string) (core.Message, error) {
 	return s.repo.Get(ctx, id)
 }
 
+// GetMulti returns messages by IDs
+// Messages not found are omitted from the result
+func (s *service) GetMulti(ctx context.Context, ids []string) ([]core.Message, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetMulti")
+	defer span.End()
+
+	return s.repo.GetMulti(ctx, ids)
+}
+
+// GetRemote returns a message stored in another domain
+// The message is read with the identity of the requester, so that messages in private streams can be fetched.
+// Fetched messages are verified and cached for util.RemoteCacheTTL
+func (s *service) GetRemote(ctx context.Context, host string, id string, requester string) (core.Message, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetRemote")
+	defer span.End()
+
+	var message core.Message
+
+	err := s.domain.CheckFetchable(ctx, host)
+	if err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	cached, err := util.GetRemoteCache(ctx, s.rdb, remoteCacheKey(host, id), requester)
+	if err == nil {
+		err = json.Unmarshal([]byte(cached), &message)
+		if err == nil {
+			return message, nil
+		}
+	}
+
+	token, err := util.RequesterToken(s.config, requester)
+	if err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	body, err := util.FetchRemote(ctx, host, "/message/"+id, token, &message)
+	if err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	if message.ID != id {
+		err = fmt.Errorf("remote returned message %s for %s", message.ID, id)
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	var object SignedObject
+	err = json.Unmarshal([]byte(message.Payload), &object)
+	if err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+	if object.Signer != message.Author {
+		err = fmt.Errorf("signer of message %s does not match the author", id)
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	err = s.key.VerifyRemoteSignature(ctx, host, message.Payload, object.Signer, object.KeyID, message.Signature)
+	if err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	for _, revision := range message.Revisions {
+		var revisionObject SignedObject
+		err = json.Unmarshal([]byte(revision.Payload), &revisionObject)
+		if err != nil {
+			span.RecordError(err)
+			return core.Message{}, err
+		}
+		if revision.Message != id || revisionObject.Signer != message.Author {
+			err = fmt.Errorf("revision %s does not belong to message %s", revision.ID, id)
+			span.RecordError(err)
+			return core.Message{}, err
+		}
+		err = s.key.VerifyRemoteSignature(ctx, host, revision.Payload, revisionObject.Signer, revisionObject.KeyID, revision.Signature)
+		if err != nil {
+			span.RecordError(err)
+			return core.Message{}, err
+		}
+	}
+
+	util.SetRemoteCache(ctx, s.rdb, remoteCacheKey(host, id), requester, body)
+
+	return message, nil
+}
+
+// InvalidateRemote drops the message cached from the host
+// The message is edited on the host, so the given local streams holding it are notified of the update.
+func (s *service) InvalidateRemote(ctx context.Context, host string, id string, streams []string) error {
+	ctx, span := tracer.Start(ctx, "ServiceInvalidateRemote")
+	defer span.End()
+
+	err := util.DeleteRemoteCache(ctx, s.rdb, remoteCacheKey(host, id))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	for _, deststream := range streams {
+		_, streamHost, _ := strings.Cut(deststream, "@")
+		if streamHost != s.config.Concurrent.FQDN {
+			continue
+		}
+		err = s.stream.NotifyUpdate(ctx, deststream, id, host)
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	return nil
+}
+
+// remoteCacheKey is keyed by the host too, so that a domain cannot overwrite what was fetched from another
+func remoteCacheKey(host string, id string) string {
+	return "message:remote:" + host + ":" + id
+}
+
 // PostMessage creates a new message
 // It also posts the message to the streams
 func (s *service) PostMessage(ctx context.Context, objectStr string, signature string, streams []string) (core.Message, error) {
@@ -59,7 +208,11 @@ func (s *service) PostMessage(ctx context.Context, objectStr string, signature s
 		return core.Message{}, err
 	}
 
-	if err := util.VerifySignature(objectStr, object.Signer, signature); err != nil {
+	if object.Target != "" {
+		return core.Message{}, fmt.Errorf("target must be empty for new messages")
+	}
+
+	if err := s.key.VerifySignature(ctx, objectStr, object.Signer, object.KeyID, signature, key.ScopeMessagePost, streams); err != nil {
 		span.RecordError(err)
 		return core.Message{}, err
 	}
@@ -85,33 +238,168 @@ func (s *service) PostMessage(ctx context.Context, objectStr string, signature s
 	return message, nil
 }
 
-// Delete deletes a message by ID
-// It also emits a delete event to the sockets
-func (s *service) Delete(ctx context.Context, id string) (core.Message, error) {
-	ctx, span := tracer.Start(ctx, "ServiceDelete")
+// UpdateMessage replaces the message with the signed edit object
+// Only the author can edit the message, and the edit must be signed after the current version.
+// The former version is kept as a revision, and an update event is emitted to the sockets.
+func (s *service) UpdateMessage(ctx context.Context, id string, objectStr string, signature string) (core.Message, error) {
+	ctx, span := tracer.Start(ctx, "ServiceUpdateMessage")
 	defer span.End()
 
-	deleted, err := s.repo.Delete(ctx, id)
+	var object SignedObject
+	err := json.Unmarshal([]byte(objectStr), &object)
 	if err != nil {
 		span.RecordError(err)
 		return core.Message{}, err
 	}
 
-	for _, deststream := range deleted.Streams {
+	if object.Target != id {
+		return core.Message{}, fmt.Errorf("signed object is not for the message %s", id)
+	}
+
+	if object.SignedAt.IsZero() {
+		return core.Message{}, fmt.Errorf("signedAt is required for edits")
+	}
+
+	current, err := s.repo.Get(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	if object.Signer != current.Author {
+		return core.Message{}, errors.Wrap(ErrPermissionDenied, "only the author can edit the message")
+	}
+
+	if err := s.key.VerifySignature(ctx, objectStr, object.Signer, object.KeyID, signature, key.ScopeMessagePost, current.Streams); err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	updated, err := s.repo.Update(ctx, core.Message{
+		ID:        id,
+		Schema:    object.Schema,
+		Payload:   objectStr,
+		Signature: signature,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	for _, deststream := range updated.Streams {
 		jsonstr, _ := json.Marshal(stream.Event{
 			Stream: deststream,
 			Type:   "message",
-			Action: "delete",
+			Action: "update",
 			Body: stream.Element{
-				ID: deleted.ID,
+				ID:     updated.ID,
+				Type:   "message",
+				Author: updated.Author,
 			},
 		})
 		err := s.rdb.Publish(context.Background(), deststream, jsonstr).Err()
 		if err != nil {
 			span.RecordError(err)
-			return deleted, err
 		}
 	}
 
-	return deleted, err
+	// domains holding the message in their streams may have cached the former version
+	s.notifyRemote(ctx, updated, true)
+
+	return updated, nil
+}
+
+// notifyRemote asks the domains of the streams to drop their cached copy of the message
+// If the message is edited, they also notify their streams of the update. Deletions are notified by the retraction.
+func (s *service) notifyRemote(ctx context.Context, message core.Message, edited bool) {
+	ctx, span := tracer.Start(ctx, "ServiceNotifyRemote")
+	defer span.End()
+
+	for _, host := range stream.Hosts(message.Streams) {
+		if host == s.config.Concurrent.FQDN {
+			continue
+		}
+		var streams []string
+		for _, deststream := range message.Streams {
+			if edited && strings.HasSuffix(deststream, "@"+host) {
+				streams = append(streams, deststream)
+			}
+		}
+		err := s.outbox.Enqueue(ctx, host, "/message/invalidate", invalidateRequest{ID: message.ID, Streams: streams})
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+}
+
+// Delete deletes a message by ID
+// The message and the associations targeting it are retracted from every stream,
+// and their tombstones are published for other domains.
+func (s *service) Delete(ctx context.Context, id string) (core.Message, error) {
+	ctx, span := tracer.Start(ctx, "ServiceDelete")
+	defer span.End()
+
+	deleted, tombs, err := s.repo.Delete(ctx, id, s.bury)
+	if err != nil {
+		span.RecordError(err)
+		return core.Message{}, err
+	}
+
+	// the first tombstone is the message's, followed by the ones of its associations
+	tomb := tombs[0]
+	for _, deststream := range deleted.Streams {
+		err := s.stream.Retract(ctx, deststream, tomb)
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	// domains holding the message in their streams may have cached it
+	s.notifyRemote(ctx, deleted, false)
+
+	for i, association := range deleted.Associations {
+		s.retractAssociation(ctx, association, tombs[i+1])
+	}
+
+	return deleted, nil
+}
+
+// bury signs the tombstones of the message and the associations targeting it
+func (s *service) bury(message core.Message) ([]core.Tombstone, error) {
+	tomb, err := s.tombstone.Sign("message", message.ID, message.Author)
+	if err != nil {
+		return nil, err
+	}
+	tombs := []core.Tombstone{tomb}
+	for _, association := range message.Associations {
+		tomb, err := s.tombstone.Sign("association", association.ID, association.Author)
+		if err != nil {
+			return nil, err
+		}
+		tombs = append(tombs, tomb)
+	}
+	return tombs, nil
+}
+
+// retractAssociation retracts an association deleted along with its target message
+func (s *service) retractAssociation(ctx context.Context, association core.Association, tomb core.Tombstone) {
+	ctx, span := tracer.Start(ctx, "ServiceRetractAssociation")
+	defer span.End()
+
+	for _, deststream := range association.Streams {
+		err := s.stream.Retract(ctx, deststream, tomb)
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	for _, host := range stream.Hosts(association.Streams) {
+		if host == s.config.Concurrent.FQDN {
+			continue
+		}
+		err := s.outbox.Enqueue(ctx, host, "/association/invalidate", invalidateRequest{ID: association.ID})
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
 }
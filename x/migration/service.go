@@ -0,0 +1,270 @@
+// Package migration applies versioned schema migrations embedded in the binary
+package migration
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+)
+
+var tracer = otel.Tracer("migration")
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey is the pg_advisory_lock key shared by every process migrating the same database
+const lockKey = 0x636f6e63 // "conc"
+
+const createSchemaVersion = `CREATE TABLE IF NOT EXISTS schema_version (
+    version integer PRIMARY KEY,
+    name text,
+    applied_at timestamp with time zone NOT NULL DEFAULT clock_timestamp()
+)`
+
+var filenamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer binary
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// ErrSchemaOutdated is returned when there are migrations not applied yet
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// Migration is a pair of up and down scripts
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaVersion is a row of applied migration
+type SchemaVersion struct {
+	Version   int       `gorm:"primaryKey"`
+	Name      string    `gorm:"type:text"`
+	AppliedAt time.Time `gorm:"type:timestamp with time zone;not null;default:clock_timestamp()"`
+}
+
+// TableName overrides the table name
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// Service is the interface for migration service
+type Service interface {
+	Current(ctx context.Context) (int, error)
+	Latest() int
+	Migrations() []Migration
+	Check(ctx context.Context) error
+	Up(ctx context.Context, target int) error
+	Down(ctx context.Context, target int) error
+}
+
+type service struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewService creates a new migration service
+func NewService(db *gorm.DB) Service {
+	migrations, err := load(files)
+	if err != nil {
+		// embedded files are fixed at build time
+		panic(err)
+	}
+	return &service{db, migrations}
+}
+
+// load reads and pairs up/down scripts ordered by version
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, path := range entries {
+		name := path[len("sql/"):]
+		match := filenamePattern.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration filename: %s", name)
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("conflicting names for version %d: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down scripts", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be sequential: expected %d, got %d", i+1, migration.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrations returns all known migrations
+func (s *service) Migrations() []Migration {
+	return s.migrations
+}
+
+// Latest returns the version this binary expects
+func (s *service) Latest() int {
+	return len(s.migrations)
+}
+
+// Current returns the version of the database. 0 means nothing is applied.
+func (s *service) Current(ctx context.Context) (int, error) {
+	ctx, span := tracer.Start(ctx, "ServiceCurrent")
+	defer span.End()
+
+	return current(s.db.WithContext(ctx))
+}
+
+func current(db *gorm.DB) (int, error) {
+	if !db.Migrator().HasTable(&SchemaVersion{}) {
+		return 0, nil
+	}
+	var version int
+	err := db.Model(&SchemaVersion{}).Select("coalesce(max(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// Check returns ErrSchemaTooNew or ErrSchemaOutdated if the database does not match this binary
+func (s *service) Check(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "ServiceCheck")
+	defer span.End()
+
+	version, err := s.Current(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if version > s.Latest() {
+		return errors.Wrapf(ErrSchemaTooNew, "database is at %d, binary knows up to %d", version, s.Latest())
+	}
+	if version < s.Latest() {
+		return errors.Wrapf(ErrSchemaOutdated, "database is at %d, binary expects %d", version, s.Latest())
+	}
+	return nil
+}
+
+// Up applies pending migrations up to target. 0 means the latest.
+func (s *service) Up(ctx context.Context, target int) error {
+	ctx, span := tracer.Start(ctx, "ServiceUp")
+	defer span.End()
+
+	if target == 0 {
+		target = s.Latest()
+	}
+	if target > s.Latest() {
+		return fmt.Errorf("unknown version %d", target)
+	}
+
+	return s.withLock(ctx, func(db *gorm.DB) error {
+		version, err := current(db)
+		if err != nil {
+			return err
+		}
+		if version > s.Latest() {
+			return errors.Wrapf(ErrSchemaTooNew, "database is at %d, binary knows up to %d", version, s.Latest())
+		}
+		for _, migration := range s.migrations[version:target] {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Up).Error; err != nil {
+					return err
+				}
+				return tx.Create(&SchemaVersion{Version: migration.Version, Name: migration.Name}).Error
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to apply %d_%s", migration.Version, migration.Name)
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts applied migrations until the database is at target
+func (s *service) Down(ctx context.Context, target int) error {
+	ctx, span := tracer.Start(ctx, "ServiceDown")
+	defer span.End()
+
+	if target < 0 {
+		return fmt.Errorf("invalid version %d", target)
+	}
+
+	return s.withLock(ctx, func(db *gorm.DB) error {
+		version, err := current(db)
+		if err != nil {
+			return err
+		}
+		if version > s.Latest() {
+			return errors.Wrapf(ErrSchemaTooNew, "database is at %d, binary knows up to %d", version, s.Latest())
+		}
+		for i := version; i > target; i-- {
+			migration := s.migrations[i-1]
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Down).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&SchemaVersion{}, "version = ?", migration.Version).Error
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to revert %d_%s", migration.Version, migration.Name)
+			}
+		}
+		return nil
+	})
+}
+
+// withLock runs fn on a single connection holding the advisory lock
+// so that concurrently starting processes do not migrate twice
+func (s *service) withLock(ctx context.Context, fn func(db *gorm.DB) error) error {
+	return s.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error
+		if err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", lockKey)
+
+		err = conn.Exec(createSchemaVersion).Error
+		if err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
@@ -0,0 +1,63 @@
+package migration
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("unexpected version %d at %d", migration.Version, i)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := load(fstest.MapFS{
+		"sql/0002_second.up.sql":   {Data: []byte("up2")},
+		"sql/0002_second.down.sql": {Data: []byte("down2")},
+		"sql/0001_first.up.sql":    {Data: []byte("up1")},
+		"sql/0001_first.down.sql":  {Data: []byte("down1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "first" || migrations[1].Up != "up2" || migrations[1].Down != "down2" {
+		t.Errorf("unexpected migrations: %v", migrations)
+	}
+
+	// missing down script
+	_, err = load(fstest.MapFS{
+		"sql/0001_first.up.sql": {Data: []byte("up1")},
+	})
+	if err == nil {
+		t.Error("expected error for missing down script")
+	}
+
+	// gap in versions
+	_, err = load(fstest.MapFS{
+		"sql/0001_first.up.sql":   {Data: []byte("up1")},
+		"sql/0001_first.down.sql": {Data: []byte("down1")},
+		"sql/0003_third.up.sql":   {Data: []byte("up3")},
+		"sql/0003_third.down.sql": {Data: []byte("down3")},
+	})
+	if err == nil {
+		t.Error("expected error for version gap")
+	}
+
+	// invalid filename
+	_, err = load(fstest.MapFS{
+		"sql/first.sql": {Data: []byte("up1")},
+	})
+	if err == nil {
+		t.Error("expected error for invalid filename")
+	}
+}
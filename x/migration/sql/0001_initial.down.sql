@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS grants;
+DROP TABLE IF EXISTS outbox_items;
+DROP TABLE IF EXISTS acks;
+DROP TABLE IF EXISTS collection_items;
+DROP TABLE IF EXISTS collections;
+DROP TABLE IF EXISTS entities;
+DROP TABLE IF EXISTS domains;
+DROP TABLE IF EXISTS streams;
+DROP TABLE IF EXISTS associations;
+DROP TABLE IF EXISTS characters;
+DROP TABLE IF EXISTS messages;
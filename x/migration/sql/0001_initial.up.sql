@@ -0,0 +1,129 @@
+-- Baseline schema. Statements are idempotent so that databases
+-- created by the former AutoMigrate can be adopted as is.
+
+CREATE TABLE IF NOT EXISTS messages (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    author char(42),
+    schema text,
+    payload json,
+    signature char(130),
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    streams text[]
+);
+
+CREATE TABLE IF NOT EXISTS characters (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    author char(42),
+    schema text,
+    payload json,
+    signature char(130),
+    c_date timestamptz,
+    m_date timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS associations (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    author char(42),
+    schema text,
+    target_id uuid,
+    target_type text,
+    content_hash char(64),
+    payload json,
+    signature char(130),
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    streams text[]
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_association ON associations (author, schema, target_id, target_type, content_hash);
+
+CREATE TABLE IF NOT EXISTS streams (
+    id char(20) PRIMARY KEY,
+    visible boolean DEFAULT false,
+    author char(42),
+    maintainer char(42)[] DEFAULT '{}',
+    writer char(42)[] DEFAULT '{}',
+    reader char(42)[] DEFAULT '{}',
+    schema text,
+    payload json,
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    m_date timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS domains (
+    id text PRIMARY KEY,
+    cc_id char(42),
+    tag text DEFAULT 'default',
+    score integer DEFAULT 0,
+    pubkey text,
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    m_date timestamptz,
+    last_scraped timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS entities (
+    id char(42) PRIMARY KEY,
+    tag text,
+    domain text,
+    certs json DEFAULT 'null',
+    meta json DEFAULT 'null',
+    score integer DEFAULT 0,
+    inviter char(42),
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    m_date timestamptz
+);
+ALTER TABLE entities ADD COLUMN IF NOT EXISTS key_type text DEFAULT 'ECRECOVER';
+ALTER TABLE entities ADD COLUMN IF NOT EXISTS pubkey text;
+ALTER TABLE entities ADD COLUMN IF NOT EXISTS key_chain json DEFAULT 'null';
+
+CREATE TABLE IF NOT EXISTS collections (
+    id char(20) PRIMARY KEY,
+    visible boolean DEFAULT false,
+    author char(42),
+    maintainer char(42)[] DEFAULT '{}',
+    writer char(42)[] DEFAULT '{}',
+    reader char(42)[] DEFAULT '{}',
+    schema text,
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    m_date timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS collection_items (
+    id char(20) PRIMARY KEY,
+    collection char(20),
+    payload json DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS acks (
+    "from" char(42),
+    "to" char(42),
+    payload json DEFAULT '{}',
+    signature char(130),
+    PRIMARY KEY ("from", "to")
+);
+
+CREATE TABLE IF NOT EXISTS outbox_items (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    domain text,
+    path text,
+    payload json,
+    status text,
+    attempts integer DEFAULT 0,
+    last_error text,
+    next_attempt timestamp with time zone,
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    m_date timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_items_domain ON outbox_items (domain);
+CREATE INDEX IF NOT EXISTS idx_outbox_items_status ON outbox_items (status);
+
+CREATE TABLE IF NOT EXISTS grants (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner char(42),
+    subkey char(42),
+    scopes text[],
+    expires_at timestamp with time zone,
+    payload json,
+    signature char(130),
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp()
+);
+CREATE INDEX IF NOT EXISTS idx_grants_owner ON grants (owner);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_grants_subkey ON grants (subkey);
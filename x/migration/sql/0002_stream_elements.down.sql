@@ -0,0 +1,4 @@
+ALTER TABLE streams DROP COLUMN retention;
+ALTER TABLE streams DROP COLUMN max_len;
+
+DROP TABLE stream_elements;
@@ -0,0 +1,14 @@
+CREATE TABLE stream_elements (
+    stream char(20),
+    ms bigint,
+    seq bigint,
+    object_id text,
+    type text,
+    author char(42),
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    PRIMARY KEY (stream, ms, seq)
+);
+CREATE INDEX idx_stream_elements_object_id ON stream_elements (object_id);
+
+ALTER TABLE streams ADD COLUMN max_len bigint DEFAULT 0;
+ALTER TABLE streams ADD COLUMN retention bigint DEFAULT 0;
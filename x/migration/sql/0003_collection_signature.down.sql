@@ -0,0 +1,5 @@
+ALTER TABLE collection_items DROP COLUMN signature;
+ALTER TABLE collection_items DROP COLUMN author;
+
+ALTER TABLE collections DROP COLUMN signature;
+ALTER TABLE collections DROP COLUMN payload;
@@ -0,0 +1,5 @@
+ALTER TABLE collections ADD COLUMN payload json DEFAULT '{}';
+ALTER TABLE collections ADD COLUMN signature char(130);
+
+ALTER TABLE collection_items ADD COLUMN author char(42);
+ALTER TABLE collection_items ADD COLUMN signature char(130);
@@ -0,0 +1,8 @@
+CREATE TABLE message_revisions (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    message uuid REFERENCES messages(id) ON DELETE CASCADE,
+    payload json,
+    signature char(130),
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp()
+);
+CREATE INDEX idx_message_revisions_message ON message_revisions (message);
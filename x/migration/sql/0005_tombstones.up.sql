@@ -0,0 +1,8 @@
+CREATE TABLE tombstones (
+    id text PRIMARY KEY,
+    type text,
+    author char(42),
+    payload json,
+    signature char(130),
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp()
+);
@@ -0,0 +1 @@
+ALTER TABLE associations ADD COLUMN target_host text DEFAULT '';
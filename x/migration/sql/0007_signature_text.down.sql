@@ -0,0 +1,9 @@
+ALTER TABLE messages ALTER COLUMN signature TYPE char(130);
+ALTER TABLE message_revisions ALTER COLUMN signature TYPE char(130);
+ALTER TABLE characters ALTER COLUMN signature TYPE char(130);
+ALTER TABLE associations ALTER COLUMN signature TYPE char(130);
+ALTER TABLE tombstones ALTER COLUMN signature TYPE char(130);
+ALTER TABLE collections ALTER COLUMN signature TYPE char(130);
+ALTER TABLE collection_items ALTER COLUMN signature TYPE char(130);
+ALTER TABLE grants ALTER COLUMN signature TYPE char(130);
+ALTER TABLE acks ALTER COLUMN signature TYPE char(130);
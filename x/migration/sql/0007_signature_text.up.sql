@@ -0,0 +1,10 @@
+-- Signatures made by WebAuthn assertions are longer than secp256k1 ones
+ALTER TABLE messages ALTER COLUMN signature TYPE text;
+ALTER TABLE message_revisions ALTER COLUMN signature TYPE text;
+ALTER TABLE characters ALTER COLUMN signature TYPE text;
+ALTER TABLE associations ALTER COLUMN signature TYPE text;
+ALTER TABLE tombstones ALTER COLUMN signature TYPE text;
+ALTER TABLE collections ALTER COLUMN signature TYPE text;
+ALTER TABLE collection_items ALTER COLUMN signature TYPE text;
+ALTER TABLE grants ALTER COLUMN signature TYPE text;
+ALTER TABLE acks ALTER COLUMN signature TYPE text;
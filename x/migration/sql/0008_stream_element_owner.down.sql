@@ -0,0 +1,3 @@
+UPDATE stream_elements SET author = owner WHERE owner IS NOT NULL;
+ALTER TABLE stream_elements DROP COLUMN host;
+ALTER TABLE stream_elements DROP COLUMN owner;
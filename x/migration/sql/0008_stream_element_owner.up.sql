@@ -0,0 +1,8 @@
+ALTER TABLE stream_elements ADD COLUMN owner char(42);
+ALTER TABLE stream_elements ADD COLUMN host text DEFAULT '';
+
+-- author used to hold the owner of the entry
+UPDATE stream_elements SET owner = author;
+UPDATE stream_elements SET author = associations.author
+    FROM associations
+    WHERE stream_elements.type = 'association' AND stream_elements.object_id = associations.id::text;
@@ -0,0 +1 @@
+ALTER TABLE associations DROP COLUMN host;
@@ -0,0 +1 @@
+ALTER TABLE associations ADD COLUMN host text DEFAULT '';
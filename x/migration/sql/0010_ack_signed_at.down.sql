@@ -0,0 +1,3 @@
+DELETE FROM acks WHERE unacked;
+ALTER TABLE acks DROP COLUMN unacked;
+ALTER TABLE acks DROP COLUMN signed_at;
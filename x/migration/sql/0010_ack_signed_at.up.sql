@@ -0,0 +1,2 @@
+ALTER TABLE acks ADD COLUMN signed_at timestamptz DEFAULT 'epoch';
+ALTER TABLE acks ADD COLUMN unacked boolean DEFAULT false;
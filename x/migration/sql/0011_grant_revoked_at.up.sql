@@ -0,0 +1 @@
+ALTER TABLE grants ADD COLUMN revoked_at timestamp with time zone;
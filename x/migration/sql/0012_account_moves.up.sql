@@ -0,0 +1,9 @@
+CREATE TABLE moves (
+    signature text PRIMARY KEY,
+    signer char(42) NOT NULL,
+    "from" text,
+    "to" text,
+    signed_at timestamp with time zone NOT NULL,
+    c_date timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+    UNIQUE (signer, signed_at)
+);
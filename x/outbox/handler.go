@@ -0,0 +1,75 @@
+// Package outbox stores and retries deliveries to remote domains
+package outbox
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+)
+
+var tracer = otel.Tracer("outbox")
+
+// Handler is the interface for handling HTTP requests
+type Handler interface {
+	List(c echo.Context) error
+	Replay(c echo.Context) error
+	Delete(c echo.Context) error
+}
+
+type handler struct {
+	service Service
+}
+
+// NewHandler creates a new handler
+func NewHandler(service Service) Handler {
+	return &handler{service: service}
+}
+
+// List returns outbox items
+// filter by status with the status query parameter (pending, dead)
+func (h handler) List(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerList")
+	defer span.End()
+
+	status := c.QueryParam("status")
+	items, err := h.service.List(ctx, status)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": items})
+}
+
+// Replay retries delivery of an outbox item
+func (h handler) Replay(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerReplay")
+	defer span.End()
+
+	id := c.Param("id")
+	err := h.service.Replay(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "outbox item not found"})
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
+
+// Delete discards an outbox item
+func (h handler) Delete(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerDelete")
+	defer span.End()
+
+	id := c.Param("id")
+	err := h.service.Delete(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok"})
+}
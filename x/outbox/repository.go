@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/totegamma/concurrent/x/core"
+	"gorm.io/gorm"
+)
+
+// Repository is the interface for outbox repository
+type Repository interface {
+	Create(ctx context.Context, item *core.OutboxItem) error
+	Get(ctx context.Context, id string) (core.OutboxItem, error)
+	List(ctx context.Context, status string) ([]core.OutboxItem, error)
+	ListDue(ctx context.Context, domain string, now time.Time, limit int) ([]core.OutboxItem, error)
+	DueDomains(ctx context.Context, now time.Time) ([]string, error)
+	Update(ctx context.Context, item *core.OutboxItem) error
+	Postpone(ctx context.Context, domain string, until time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+// NewRepository creates a new outbox repository
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// Create creates new outbox item
+func (r *repository) Create(ctx context.Context, item *core.OutboxItem) error {
+	ctx, span := tracer.Start(ctx, "RepositoryCreate")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Create(&item).Error
+}
+
+// Get returns an outbox item by ID
+func (r *repository) Get(ctx context.Context, id string) (core.OutboxItem, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGet")
+	defer span.End()
+
+	var item core.OutboxItem
+	err := r.db.WithContext(ctx).First(&item, "id = ?", id).Error
+	return item, err
+}
+
+// List returns outbox items filtered by status
+// returns all items if status is empty
+func (r *repository) List(ctx context.Context, status string) ([]core.OutboxItem, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryList")
+	defer span.End()
+
+	var items []core.OutboxItem
+	query := r.db.WithContext(ctx).Order("c_date asc")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Find(&items).Error
+	return items, err
+}
+
+// ListDue returns pending items of the domain which should be delivered now, oldest first
+func (r *repository) ListDue(ctx context.Context, domain string, now time.Time, limit int) ([]core.OutboxItem, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryListDue")
+	defer span.End()
+
+	var items []core.OutboxItem
+	err := r.db.WithContext(ctx).
+		Where("domain = ? AND status = ? AND next_attempt <= ?", domain, StatusPending, now).
+		Order("c_date asc").
+		Limit(limit).
+		Find(&items).Error
+	return items, err
+}
+
+// DueDomains returns domains which have pending items to be delivered now
+func (r *repository) DueDomains(ctx context.Context, now time.Time) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryDueDomains")
+	defer span.End()
+
+	var domains []string
+	err := r.db.WithContext(ctx).
+		Model(&core.OutboxItem{}).
+		Where("status = ? AND next_attempt <= ?", StatusPending, now).
+		Distinct().
+		Pluck("domain", &domains).Error
+	return domains, err
+}
+
+// Update updates an outbox item
+func (r *repository) Update(ctx context.Context, item *core.OutboxItem) error {
+	ctx, span := tracer.Start(ctx, "RepositoryUpdate")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Save(&item).Error
+}
+
+// Postpone delays all pending items of the domain until the given time
+func (r *repository) Postpone(ctx context.Context, domain string, until time.Time) error {
+	ctx, span := tracer.Start(ctx, "RepositoryPostpone")
+	defer span.End()
+
+	return r.db.WithContext(ctx).
+		Model(&core.OutboxItem{}).
+		Where("domain = ? AND status = ? AND next_attempt < ?", domain, StatusPending, until).
+		Update("next_attempt", until).Error
+}
+
+// Delete deletes an outbox item
+func (r *repository) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "RepositoryDelete")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Delete(&core.OutboxItem{}, "id = ?", id).Error
+}
@@ -0,0 +1,303 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/xid"
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	// StatusPending is the status of items waiting for delivery
+	StatusPending = "pending"
+	// StatusDead is the status of items which exceeded the retry limit
+	StatusDead = "dead"
+
+	baseDelay   = 30 * time.Second
+	maxDelay    = 6 * time.Hour
+	maxAttempts = 16
+	batchSize   = 64
+	lockTTL     = 15 * time.Minute
+
+	// bulkTimeout is the timeout of deliveries carrying large payloads, shorter than lockTTL
+	bulkTimeout = 10 * time.Minute
+)
+
+// bulkPaths are the paths whose payloads can be too large for the default timeout
+var bulkPaths = map[string]bool{
+	"/account/import": true,
+}
+
+// releaseLock deletes the lock only if it is still held with the token
+var releaseLock = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// extendLock renews the ttl of the lock only if it is still held with the token
+var extendLock = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Service is the interface for outbox service
+type Service interface {
+	Enqueue(ctx context.Context, domain string, path string, payload interface{}) error
+	Deliver(ctx context.Context)
+	List(ctx context.Context, status string) ([]core.OutboxItem, error)
+	Replay(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string) error
+}
+
+type service struct {
+	rdb        *redis.Client
+	repository Repository
+	config     util.Config
+	client     *http.Client
+	bulkClient *http.Client
+}
+
+// NewService creates a new outbox service
+func NewService(rdb *redis.Client, repository Repository, config util.Config) Service {
+	return &service{
+		rdb,
+		repository,
+		config,
+		&http.Client{Timeout: 10 * time.Second},
+		&http.Client{Timeout: bulkTimeout},
+	}
+}
+
+// backoff returns the delay before the next attempt
+func backoff(attempts int) time.Duration {
+	if attempts <= 0 {
+		return baseDelay
+	}
+	if attempts > 20 {
+		return maxDelay
+	}
+	delay := baseDelay << (attempts - 1)
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// Enqueue persists a delivery to the remote domain and tries to send it immediately.
+// If the remote domain is unreachable, the delivery will be retried by Deliver.
+func (s *service) Enqueue(ctx context.Context, domain string, path string, payload interface{}) error {
+	ctx, span := tracer.Start(ctx, "ServiceEnqueue")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("domain", domain), attribute.String("path", path))
+
+	payloadStr, err := json.Marshal(payload)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	item := core.OutboxItem{
+		Domain:      domain,
+		Path:        path,
+		Payload:     string(payloadStr),
+		Status:      StatusPending,
+		NextAttempt: time.Now(),
+	}
+
+	err = s.repository.Create(ctx, &item)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	go s.flushDomain(context.Background(), domain)
+
+	return nil
+}
+
+// Deliver sends all pending items which are due
+func (s *service) Deliver(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "ServiceDeliver")
+	defer span.End()
+
+	domains, err := s.repository.DueDomains(ctx, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		log.Printf("fail to list outbox domains: %v", err)
+		return
+	}
+
+	for _, domain := range domains {
+		s.flushDomain(ctx, domain)
+	}
+}
+
+// flushDomain sends due items of the domain in order.
+// Only one worker flushes a domain at the same time. The lock is renewed before each delivery,
+// and the flush stops if the lock has been lost so that two workers never send concurrently.
+// If a delivery fails, the rest of the queue is postponed so that the order is kept.
+func (s *service) flushDomain(ctx context.Context, domain string) {
+	ctx, span := tracer.Start(ctx, "ServiceFlushDomain")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("domain", domain))
+
+	lockKey := "outbox:lock:" + domain
+	token := xid.New().String()
+	locked, err := s.rdb.SetNX(ctx, lockKey, token, lockTTL).Result()
+	if err != nil || !locked {
+		return
+	}
+	defer releaseLock.Run(context.Background(), s.rdb, []string{lockKey}, token)
+
+	for {
+		items, err := s.repository.ListDue(ctx, domain, time.Now(), batchSize)
+		if err != nil {
+			span.RecordError(err)
+			log.Printf("fail to list outbox items: %v", err)
+			return
+		}
+		if len(items) == 0 {
+			return
+		}
+
+		for _, item := range items {
+			held, err := extendLock.Run(ctx, s.rdb, []string{lockKey}, token, lockTTL.Milliseconds()).Int()
+			if err != nil || held == 0 {
+				log.Printf("lost outbox lock of %v", domain)
+				return
+			}
+
+			err = s.send(ctx, item)
+			if err == nil {
+				err = s.repository.Delete(ctx, item.ID)
+				if err != nil {
+					span.RecordError(err)
+					log.Printf("fail to delete delivered outbox item: %v", err)
+					return
+				}
+				continue
+			}
+
+			span.RecordError(err)
+			log.Printf("fail to deliver to %v (attempt %d): %v", domain, item.Attempts+1, err)
+
+			item.Attempts++
+			item.LastError = err.Error()
+			next := time.Now().Add(backoff(item.Attempts))
+			item.NextAttempt = next
+			if item.Attempts >= maxAttempts {
+				item.Status = StatusDead
+			}
+			err = s.repository.Update(ctx, &item)
+			if err != nil {
+				span.RecordError(err)
+				log.Printf("fail to update outbox item: %v", err)
+			}
+
+			err = s.repository.Postpone(ctx, domain, next)
+			if err != nil {
+				span.RecordError(err)
+				log.Printf("fail to postpone outbox: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// send delivers an item to the remote domain
+func (s *service) send(ctx context.Context, item core.OutboxItem) error {
+	ctx, span := tracer.Start(ctx, "ServiceSend")
+	defer span.End()
+
+	req, err := http.NewRequest("POST", "https://"+item.Domain+"/api/v1"+item.Path, bytes.NewBufferString(item.Payload))
+	if err != nil {
+		return err
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	req.Header.Add("content-type", "application/json")
+	err = util.SignRequest(req, []byte(item.Payload), s.config.Concurrent.CCID, s.config.Concurrent.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	client := s.client
+	if bulkPaths[item.Path] {
+		client = s.bulkClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	span.SetAttributes(attribute.Int("status", resp.StatusCode))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("remote responded %v: %s", resp.Status, body)
+	}
+
+	return nil
+}
+
+// List returns outbox items filtered by status
+func (s *service) List(ctx context.Context, status string) ([]core.OutboxItem, error) {
+	ctx, span := tracer.Start(ctx, "ServiceList")
+	defer span.End()
+
+	return s.repository.List(ctx, status)
+}
+
+// Replay resets the retry state of an item and delivers it again
+func (s *service) Replay(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "ServiceReplay")
+	defer span.End()
+
+	item, err := s.repository.Get(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	item.Status = StatusPending
+	item.Attempts = 0
+	item.NextAttempt = time.Now()
+
+	err = s.repository.Update(ctx, &item)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	go s.flushDomain(context.Background(), item.Domain)
+
+	return nil
+}
+
+// Delete discards an item
+func (s *service) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "ServiceDelete")
+	defer span.End()
+
+	return s.repository.Delete(ctx, id)
+}
@@ -0,0 +1,27 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{0, baseDelay},
+		{1, baseDelay},
+		{2, 2 * baseDelay},
+		{3, 4 * baseDelay},
+		{15, maxDelay},
+		{100, maxDelay},
+	}
+
+	for _, c := range cases {
+		result := backoff(c.attempts)
+		if result != c.expected {
+			t.Errorf("backoff(%d): expected %v, got %v", c.attempts, c.expected, result)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/totegamma/concurrent/x/stream"
+)
+
+const (
+	// sendQueueSize is the number of messages buffered for a connection
+	// A connection which falls further behind is considered slow and closed.
+	sendQueueSize = 256
+	// maxChannels is the number of channels a connection can subscribe at once
+	maxChannels = 256
+	// writeWait is the time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// pongWait is the time allowed to read the next pong or request from the client
+	pongWait = 60 * time.Second
+	// pingPeriod is the interval of pings, shorter than pongWait
+	pingPeriod = pongWait * 9 / 10
+	// replayLimit is the number of events replayed for a channel on subscription
+	replayLimit = 200
+)
+
+// subscriber is the part of redis.PubSub used by a connection
+type subscriber interface {
+	Subscribe(ctx context.Context, channels ...string) error
+	Unsubscribe(ctx context.Context, channels ...string) error
+	ChannelWithSubscriptions(opts ...redis.ChannelOption) <-chan interface{}
+	Close() error
+}
+
+// replayRequest is a replay waiting for the subscription of its channel to be confirmed
+type replayRequest struct {
+	since     string
+	requester string
+}
+
+// replayState holds live messages of a channel received while its replay is running
+type replayState struct {
+	buffer    []string
+	cancelled bool
+}
+
+// connection is the state of a single websocket connection
+// Only the write pump writes to the socket, others queue messages with enqueue.
+// channels and requester are owned by the goroutine reading requests,
+// pending, replaying and marks are shared with the read pump and the replays.
+type connection struct {
+	ws        *websocket.Conn
+	pubsub    subscriber
+	service   Service
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	channels  map[string]bool
+	requester string
+
+	mutex     sync.Mutex
+	pending   map[string]replayRequest
+	replaying map[string]*replayState
+	marks     map[string]string
+}
+
+func newConnection(ws *websocket.Conn, pubsub subscriber, service Service) *connection {
+	return &connection{
+		ws:        ws,
+		pubsub:    pubsub,
+		service:   service,
+		send:      make(chan []byte, sendQueueSize),
+		done:      make(chan struct{}),
+		channels:  map[string]bool{},
+		pending:   map[string]replayRequest{},
+		replaying: map[string]*replayState{},
+		marks:     map[string]string{},
+	}
+}
+
+// enqueue queues the message for the write pump
+// If the queue is full, the connection is closed so that a slow client does not hold back the publisher.
+func (c *connection) enqueue(message []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+		log.Println("Closing slow connection")
+		c.close()
+		return false
+	}
+}
+
+// enqueueWait queues the message, waiting up to writeWait for the write pump to catch up
+// Used for replays, which can be larger than the queue.
+func (c *connection) enqueueWait(message []byte) bool {
+	timer := time.NewTimer(writeWait)
+	defer timer.Stop()
+
+	select {
+	case c.send <- message:
+		return true
+	case <-c.done:
+		return false
+	case <-timer.C:
+		log.Println("Closing slow connection")
+		c.close()
+		return false
+	}
+}
+
+func (c *connection) sendError(message string, channels []string) {
+	jsonstr, _ := json.Marshal(ErrorEvent{Type: "error", Error: message, Channels: channels})
+	c.enqueue(jsonstr)
+}
+
+// close releases the pubsub and the socket
+// It is safe to call more than once.
+func (c *connection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.pubsub != nil {
+			c.pubsub.Close()
+		}
+		if c.ws != nil {
+			c.ws.Close()
+		}
+	})
+}
+
+// writePump writes queued messages and pings to the socket until the connection is closed
+func (c *connection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			if err != nil {
+				log.Println("Error writing ping: ", err)
+				c.close()
+				return
+			}
+		case message := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			err := c.ws.WriteMessage(websocket.TextMessage, message)
+			if err != nil {
+				log.Println("Error writing message: ", err)
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// readPump queues messages of the subscribed channels until the connection is closed
+// Replays start once redis confirms the subscription, so that nothing published in between is missed.
+// Live messages of a channel being replayed are held back until the replay is queued.
+func (c *connection) readPump() {
+	messages := c.pubsub.ChannelWithSubscriptions()
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				switch msg.Kind {
+				case "subscribe":
+					c.startReplay(msg.Channel)
+				case "unsubscribe":
+					c.mutex.Lock()
+					delete(c.marks, msg.Channel)
+					if state, ok := c.replaying[msg.Channel]; ok {
+						state.cancelled = true
+					}
+					c.mutex.Unlock()
+				}
+			case *redis.Message:
+				c.deliver(msg.Channel, msg.Payload)
+			}
+		}
+	}
+}
+
+// deliver queues a live message unless it is held back by a replay or already replayed
+func (c *connection) deliver(channel string, payload string) {
+	c.mutex.Lock()
+	if state, ok := c.replaying[channel]; ok {
+		state.buffer = append(state.buffer, payload)
+		overflow := len(state.buffer) > sendQueueSize
+		c.mutex.Unlock()
+		if overflow {
+			log.Println("Closing slow connection")
+			c.close()
+		}
+		return
+	}
+	duplicate := c.duplicate(channel, payload)
+	c.mutex.Unlock()
+
+	if !duplicate {
+		c.enqueue([]byte(payload))
+	}
+}
+
+// startReplay runs the replay requested for the channel in its own goroutine
+// so that the other channels keep being delivered meanwhile.
+func (c *connection) startReplay(channel string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, running := c.replaying[channel]; running {
+		return
+	}
+
+	request, ok := c.pending[channel]
+	delete(c.pending, channel)
+	if !ok {
+		delete(c.marks, channel)
+		return
+	}
+
+	c.replaying[channel] = &replayState{}
+	go c.replay(channel, request)
+}
+
+// replay queues the events missed since the element id requested for the channel,
+// followed by the live messages held back meanwhile.
+// The last replayed element is kept as the mark to drop live messages already replayed.
+func (c *connection) replay(channel string, request replayRequest) {
+	events, truncated, err := c.service.Replay(context.Background(), channel, request.since, request.requester)
+	if err != nil {
+		log.Println("Error replaying channel: ", err)
+		c.sendError("failed to replay", []string{channel})
+	}
+
+	mark := request.since
+	for _, event := range events {
+		jsonstr, _ := json.Marshal(event)
+		if !c.enqueueWait(jsonstr) {
+			return
+		}
+		mark = event.Body.Timestamp
+	}
+	if truncated {
+		c.sendError("replay truncated", []string{channel})
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state := c.replaying[channel]
+	delete(c.replaying, channel)
+	if state.cancelled {
+		return
+	}
+	if err == nil {
+		c.marks[channel] = mark
+	}
+	for _, payload := range state.buffer {
+		if c.duplicate(channel, payload) {
+			continue
+		}
+		c.enqueue([]byte(payload))
+	}
+}
+
+// duplicate reports whether the live message is an element already replayed
+// Only creations carry the element id, other actions are always delivered.
+// The caller must hold the mutex.
+func (c *connection) duplicate(channel string, payload string) bool {
+	mark, ok := c.marks[channel]
+	if !ok {
+		return false
+	}
+
+	var event stream.Event
+	err := json.Unmarshal([]byte(payload), &event)
+	if err != nil || event.Action != "create" || event.Body.Timestamp == "" {
+		return false
+	}
+	return !stream.After(event.Body.Timestamp, mark)
+}
+
+// subscribe adds the channels to the connection
+// Channels with an element id in since are replayed from the element once subscribed.
+// Channels beyond the limit are not subscribed and returned.
+func (c *connection) subscribe(ctx context.Context, channels []string, since map[string]string) ([]string, error) {
+	added := []string{}
+	rejected := []string{}
+	for _, channel := range channels {
+		if c.channels[channel] {
+			continue
+		}
+		if len(c.channels) >= maxChannels {
+			rejected = append(rejected, channel)
+			continue
+		}
+		c.channels[channel] = true
+		added = append(added, channel)
+	}
+	if len(added) == 0 {
+		return rejected, nil
+	}
+
+	c.mutex.Lock()
+	for _, channel := range added {
+		if id := since[channel]; id != "" {
+			c.pending[channel] = replayRequest{since: id, requester: c.requester}
+		}
+	}
+	c.mutex.Unlock()
+
+	err := c.pubsub.Subscribe(ctx, added...)
+	if err != nil {
+		c.mutex.Lock()
+		for _, channel := range added {
+			delete(c.channels, channel)
+			delete(c.pending, channel)
+		}
+		c.mutex.Unlock()
+		return rejected, err
+	}
+	return rejected, nil
+}
+
+// unsubscribe removes the channels from the connection
+// Channels not subscribed are ignored.
+func (c *connection) unsubscribe(ctx context.Context, channels []string) error {
+	removed := []string{}
+	c.mutex.Lock()
+	for _, channel := range channels {
+		if !c.channels[channel] {
+			continue
+		}
+		delete(c.channels, channel)
+		delete(c.pending, channel)
+		removed = append(removed, channel)
+	}
+	c.mutex.Unlock()
+	if len(removed) == 0 {
+		// calling Unsubscribe without channels drops every subscription
+		return nil
+	}
+	return c.pubsub.Unsubscribe(ctx, removed...)
+}
+
+// subscribed returns the channels the connection is subscribing
+func (c *connection) subscribed() []string {
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
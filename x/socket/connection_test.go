@@ -0,0 +1,125 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/totegamma/concurrent/x/stream"
+)
+
+func TestEnqueueClosesSlowConnection(t *testing.T) {
+	conn := newConnection(nil, nil, nil)
+
+	for i := 0; i < sendQueueSize; i++ {
+		if !conn.enqueue([]byte("message")) {
+			t.Fatalf("message %d was rejected before the queue is full", i)
+		}
+	}
+
+	if conn.enqueue([]byte("overflow")) {
+		t.Error("message was queued beyond the queue size")
+	}
+
+	select {
+	case <-conn.done:
+	default:
+		t.Error("slow connection was not closed")
+	}
+
+	if conn.enqueue([]byte("after close")) {
+		t.Error("message was queued after close")
+	}
+
+	// closing again must not panic
+	conn.close()
+}
+
+func TestDuplicate(t *testing.T) {
+	conn := newConnection(nil, nil, nil)
+	conn.marks["stream@example.com"] = "1700000000000-2"
+
+	cases := []struct {
+		channel string
+		payload string
+		expect  bool
+	}{
+		{"stream@example.com", `{"action":"create","body":{"timestamp":"1700000000000-1"}}`, true},
+		{"stream@example.com", `{"action":"create","body":{"timestamp":"1700000000000-2"}}`, true},
+		{"stream@example.com", `{"action":"create","body":{"timestamp":"1700000000000-3"}}`, false},
+		{"stream@example.com", `{"action":"delete","body":{"id":"abc"}}`, false},
+		{"other@example.com", `{"action":"create","body":{"timestamp":"1700000000000-1"}}`, false},
+	}
+	for _, c := range cases {
+		if conn.duplicate(c.channel, c.payload) != c.expect {
+			t.Errorf("%s %s: expected %v", c.channel, c.payload, c.expect)
+		}
+	}
+}
+
+type fakeService struct {
+	Service
+	release chan struct{}
+	events  []stream.Event
+}
+
+func (f fakeService) Replay(ctx context.Context, channel string, since string, requester string) ([]stream.Event, bool, error) {
+	<-f.release
+	return f.events, false, nil
+}
+
+func createEvent(timestamp string) string {
+	jsonstr, _ := json.Marshal(stream.Event{Stream: "stream@example.com", Action: "create", Body: stream.Element{Timestamp: timestamp}})
+	return string(jsonstr)
+}
+
+func TestReplayHoldsBackLiveMessages(t *testing.T) {
+	service := fakeService{
+		release: make(chan struct{}),
+		events: []stream.Event{
+			{Stream: "stream@example.com", Action: "create", Body: stream.Element{Timestamp: "1700000000000-1"}},
+			{Stream: "stream@example.com", Action: "create", Body: stream.Element{Timestamp: "1700000000000-2"}},
+		},
+	}
+	conn := newConnection(nil, nil, service)
+	conn.pending["stream@example.com"] = replayRequest{since: "1700000000000-0"}
+
+	conn.startReplay("stream@example.com")
+
+	// delivered while the replay is running
+	conn.deliver("stream@example.com", createEvent("1700000000000-2"))
+	conn.deliver("stream@example.com", createEvent("1700000000000-3"))
+	conn.deliver("other@example.com", "other")
+
+	close(service.release)
+	for {
+		conn.mutex.Lock()
+		running := len(conn.replaying) > 0
+		conn.mutex.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	expected := []string{
+		"other",
+		createEvent("1700000000000-1"),
+		createEvent("1700000000000-2"),
+		createEvent("1700000000000-3"),
+	}
+	for i, want := range expected {
+		select {
+		case got := <-conn.send:
+			if string(got) != want {
+				t.Errorf("message %d: expected %s, got %s", i, want, got)
+			}
+		default:
+			t.Fatalf("message %d: expected %s, got nothing", i, want)
+		}
+	}
+	if len(conn.send) != 0 {
+		t.Error("replayed element must not be delivered twice")
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
+	"github.com/totegamma/concurrent/x/util"
+	"golang.org/x/exp/slices"
 	"log"
 	"net/http"
-	"sync"
+	"time"
 )
 
 // Handler is the interface for handling websocket
@@ -19,7 +21,6 @@ type Handler interface {
 type handler struct {
 	service Service
 	rdb     *redis.Client
-	mutex   *sync.Mutex
 }
 
 // NewHandler creates a new handler
@@ -27,7 +28,6 @@ func NewHandler(service Service, rdb *redis.Client) Handler {
 	return &handler{
 		service,
 		rdb,
-		&sync.Mutex{},
 	}
 }
 
@@ -39,24 +39,38 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func (h handler) send(ws *websocket.Conn, message string) error {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
-	return ws.WriteMessage(websocket.TextMessage, []byte(message))
-}
-
 // Connect is used for start websocket connection
+// The requester is identified by the authorization header or the token sent in a request,
+// and only channels the requester can read are subscribed.
+// "subscribe" and "unsubscribe" requests add and remove channels,
+// other requests with channels replace the whole subscription.
+// A channel subscribed with its last seen element id in since is replayed from the element
+// before live delivery, so that a client resuming after a disconnection misses nothing.
 func (h handler) Connect(c echo.Context) error {
 	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		log.Println("Failed to upgrade WebSocket:", err)
 		c.Logger().Error(err)
+		return nil
 	}
-	defer ws.Close()
 
-	var ctx context.Context
-	var cancel context.CancelFunc
-	var pubsub *redis.PubSub
+	ctx := c.Request().Context()
+
+	conn := newConnection(ws, h.rdb.Subscribe(context.Background()), h.service)
+	defer conn.close()
+
+	if claims, ok := c.Get("jwtclaims").(util.JwtClaims); ok && claims.Subject == "CONCURRENT_API" {
+		conn.requester = claims.Audience
+	}
+
+	// a peer which answers neither pings nor sends requests is considered dead
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	go conn.writePump()
+	go conn.readPump()
 
 	for {
 		var req Request
@@ -65,72 +79,89 @@ func (h handler) Connect(c echo.Context) error {
 			log.Println("Error reading JSON: ", err)
 			break
 		}
+		ws.SetReadDeadline(time.Now().Add(pongWait))
 
 		if req.Type == "ping" {
-			err = h.send(ws, "pong")
-			if err != nil {
-				log.Println("Error writing message: ", err)
-				break
-			}
+			conn.enqueue([]byte("pong"))
 			continue
 		}
 
-		if cancel != nil {
-			cancel()
-		}
-		if pubsub != nil {
-			pubsub.Close()
+		if req.Token != "" {
+			requester, err := h.service.Identify(ctx, req.Token)
+			if err != nil {
+				conn.sendError("invalid token", nil)
+				continue
+			}
+			if requester != conn.requester {
+				conn.requester = requester
+				h.revalidate(ctx, conn)
+			}
 		}
 
-		ctx, cancel = context.WithCancel(context.Background())
+		switch req.Type {
+		case "auth":
+		case "subscribe":
+			h.subscribe(ctx, conn, req.Channels, req.Since)
+		case "unsubscribe":
+			err = conn.unsubscribe(ctx, req.Channels)
+			if err != nil {
+				log.Println("Error unsubscribing: ", err)
+				conn.sendError("failed to unsubscribe", req.Channels)
+			}
+		default:
+			h.replace(ctx, conn, req.Channels, req.Since)
+		}
+	}
 
-		// Unsubscribe from all channels before subscribing to new ones
-		pubsub = h.rdb.Subscribe(ctx)
+	return nil
+}
 
-		// Subscribe to new channels
-		for _, ch := range req.Channels {
-			pubsub.Subscribe(ctx, ch)
-			log.Printf("Subscribed to channel: %s\n", ch)
+// replace makes the channels the whole subscription of the connection
+// Kept for clients sending the full channel list on every change.
+func (h handler) replace(ctx context.Context, conn *connection, channels []string, since map[string]string) {
+	stale := []string{}
+	for _, channel := range conn.subscribed() {
+		if !slices.Contains(channels, channel) {
+			stale = append(stale, channel)
 		}
+	}
+	err := conn.unsubscribe(ctx, stale)
+	if err != nil {
+		log.Println("Error unsubscribing: ", err)
+		conn.sendError("failed to unsubscribe", stale)
+	}
+	h.subscribe(ctx, conn, channels, since)
+}
 
-		// Read from channels
-		go func(ctx context.Context, pubsub *redis.PubSub) {
-			for {
-				select {
-				case <-ctx.Done():
-					log.Println("finish goroutine")
-					return
-				default:
-					msg, err := pubsub.ReceiveMessage(ctx)
-					if ctx.Err() != nil {
-						log.Println("context seems to be canceled")
-						continue
-					}
-					if err != nil {
-						log.Println("Error receiving message: ", err)
-						break
-					}
-					log.Printf("Received message from channel %s: %s\n", msg.Channel, msg.Payload)
-
-					err = h.send(ws, msg.Payload)
-					if err != nil {
-						log.Println("Error writing message: ", err)
-						break
-					}
-				}
-			}
-		}(ctx, pubsub)
+// subscribe adds the channels the requester can read to the connection
+// The client is told about the channels denied or over the limit.
+func (h handler) subscribe(ctx context.Context, conn *connection, channels []string, since map[string]string) {
+	allowed, denied := h.service.FilterReadable(ctx, channels, conn.requester)
+	if len(denied) > 0 {
+		conn.sendError("permission denied", denied)
+	}
 
+	rejected, err := conn.subscribe(ctx, allowed, since)
+	if err != nil {
+		log.Println("Error subscribing: ", err)
+		conn.sendError("failed to subscribe", allowed)
 	}
+	if len(rejected) > 0 {
+		conn.sendError("too many channels", rejected)
+	}
+}
 
-	defer func() {
-		if cancel != nil {
-			cancel()
-		}
-		if pubsub != nil {
-			pubsub.Close()
-		}
-	}()
+// revalidate drops the channels the requester can no longer read
+// Called when the requester changes during the connection.
+func (h handler) revalidate(ctx context.Context, conn *connection) {
+	_, denied := h.service.FilterReadable(ctx, conn.subscribed(), conn.requester)
+	if len(denied) == 0 {
+		return
+	}
 
-	return nil
+	err := conn.unsubscribe(ctx, denied)
+	if err != nil {
+		log.Println("Error unsubscribing: ", err)
+	}
+	conn.sendError("permission denied", denied)
 }
@@ -0,0 +1,193 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type fakeSubscriber struct {
+	channels map[string]bool
+}
+
+func (f *fakeSubscriber) Subscribe(ctx context.Context, channels ...string) error {
+	for _, channel := range channels {
+		f.channels[channel] = true
+	}
+	return nil
+}
+
+func (f *fakeSubscriber) Unsubscribe(ctx context.Context, channels ...string) error {
+	if len(channels) == 0 {
+		return fmt.Errorf("unsubscribing without channels drops every subscription")
+	}
+	for _, channel := range channels {
+		delete(f.channels, channel)
+	}
+	return nil
+}
+
+func (f *fakeSubscriber) ChannelWithSubscriptions(opts ...redis.ChannelOption) <-chan interface{} {
+	return make(chan interface{})
+}
+
+func (f *fakeSubscriber) Close() error {
+	return nil
+}
+
+type fakeReadable struct {
+	Service
+	denied map[string]bool
+}
+
+func (f fakeReadable) FilterReadable(ctx context.Context, channels []string, requester string) ([]string, []string) {
+	allowed := []string{}
+	denied := []string{}
+	for _, channel := range channels {
+		if f.denied[channel] {
+			denied = append(denied, channel)
+		} else {
+			allowed = append(allowed, channel)
+		}
+	}
+	return allowed, denied
+}
+
+func newTestConnection(denied ...string) (handler, *connection, *fakeSubscriber) {
+	service := fakeReadable{denied: map[string]bool{}}
+	for _, channel := range denied {
+		service.denied[channel] = true
+	}
+	pubsub := &fakeSubscriber{channels: map[string]bool{}}
+	return handler{service: service}, newConnection(nil, pubsub, service), pubsub
+}
+
+func channelNames(prefix string, n int) []string {
+	channels := make([]string, n)
+	for i := range channels {
+		channels[i] = fmt.Sprintf("%s%d@example.com", prefix, i)
+	}
+	return channels
+}
+
+func sorted(channels []string) string {
+	channels = append([]string{}, channels...)
+	sort.Strings(channels)
+	return strings.Join(channels, ",")
+}
+
+func receiveErrors(t *testing.T, conn *connection) map[string][]string {
+	errs := map[string][]string{}
+	for len(conn.send) > 0 {
+		var event ErrorEvent
+		err := json.Unmarshal(<-conn.send, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs[event.Error] = event.Channels
+	}
+	return errs
+}
+
+func TestSubscribeLimit(t *testing.T) {
+	h, conn, pubsub := newTestConnection()
+	ctx := context.Background()
+
+	h.subscribe(ctx, conn, channelNames("a", maxChannels-1), nil)
+	if errs := receiveErrors(t, conn); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// subscribing again is not counted twice
+	h.subscribe(ctx, conn, channelNames("a", maxChannels-1), nil)
+	if len(conn.channels) != maxChannels-1 {
+		t.Fatalf("expected %d channels, got %d", maxChannels-1, len(conn.channels))
+	}
+
+	h.subscribe(ctx, conn, channelNames("b", 3), nil)
+	errs := receiveErrors(t, conn)
+	if sorted(errs["too many channels"]) != "b1@example.com,b2@example.com" {
+		t.Errorf("unexpected rejected channels: %v", errs)
+	}
+	if len(conn.channels) != maxChannels || len(pubsub.channels) != maxChannels {
+		t.Errorf("expected %d channels, got %d (pubsub %d)", maxChannels, len(conn.channels), len(pubsub.channels))
+	}
+	if !pubsub.channels["b0@example.com"] || pubsub.channels["b1@example.com"] {
+		t.Error("channels beyond the limit must not be subscribed")
+	}
+
+	// unsubscribing makes room again
+	err := conn.unsubscribe(ctx, []string{"a0@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.subscribe(ctx, conn, []string{"b1@example.com"}, nil)
+	if errs := receiveErrors(t, conn); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if !pubsub.channels["b1@example.com"] || pubsub.channels["a0@example.com"] {
+		t.Error("freed slot must be reused")
+	}
+}
+
+func TestSubscribeDenied(t *testing.T) {
+	h, conn, pubsub := newTestConnection("private@example.com")
+
+	h.subscribe(context.Background(), conn, []string{"public@example.com", "private@example.com"}, nil)
+
+	errs := receiveErrors(t, conn)
+	if sorted(errs["permission denied"]) != "private@example.com" {
+		t.Errorf("unexpected denied channels: %v", errs)
+	}
+	if sorted(conn.subscribed()) != "public@example.com" || len(pubsub.channels) != 1 {
+		t.Errorf("unexpected subscription: %v", conn.subscribed())
+	}
+}
+
+func TestUnsubscribeIgnoresUnknownChannels(t *testing.T) {
+	h, conn, pubsub := newTestConnection()
+	ctx := context.Background()
+
+	h.subscribe(ctx, conn, []string{"a@example.com"}, nil)
+
+	// must not reach redis, where no channels means every channel
+	err := conn.unsubscribe(ctx, []string{"unknown@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pubsub.channels["a@example.com"] {
+		t.Error("subscribed channel was dropped")
+	}
+}
+
+func TestReplaceSubscription(t *testing.T) {
+	h, conn, pubsub := newTestConnection()
+	ctx := context.Background()
+
+	h.replace(ctx, conn, []string{"a@example.com", "b@example.com"}, nil)
+	h.replace(ctx, conn, []string{"b@example.com", "c@example.com"}, map[string]string{"c@example.com": "1700000000000-0"})
+
+	if sorted(conn.subscribed()) != "b@example.com,c@example.com" {
+		t.Errorf("unexpected subscription: %v", conn.subscribed())
+	}
+	if len(pubsub.channels) != 2 || pubsub.channels["a@example.com"] {
+		t.Errorf("stale channel was not unsubscribed: %v", pubsub.channels)
+	}
+	if _, ok := conn.pending["c@example.com"]; !ok {
+		t.Error("replay of the added channel was not requested")
+	}
+
+	// the same list again changes nothing
+	h.replace(ctx, conn, []string{"b@example.com", "c@example.com"}, nil)
+	if errs := receiveErrors(t, conn); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if len(pubsub.channels) != 2 {
+		t.Errorf("unexpected subscription: %v", pubsub.channels)
+	}
+}
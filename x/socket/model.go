@@ -1,8 +1,14 @@
 package socket
 
+// Request is a message sent by the client
+// Type is one of "ping", "auth", "subscribe" and "unsubscribe".
+// Requests of other types replace the subscription with the channels.
+// Since maps channels to the last element id seen by the client.
 type Request struct {
-	Type string `json:"type"`
-	Channels []string `json:"channels"`
+	Type     string            `json:"type"`
+	Channels []string          `json:"channels"`
+	Since    map[string]string `json:"since,omitempty"`
+	Token    string            `json:"token,omitempty"`
 }
 
 type StreamEvent struct {
@@ -10,3 +16,10 @@ type StreamEvent struct {
 	Type   string `json:"type"`
 	Action string `json:"action"`
 }
+
+// ErrorEvent tells the client the request could not be fulfilled
+type ErrorEvent struct {
+	Type     string   `json:"type"`
+	Error    string   `json:"error"`
+	Channels []string `json:"channels,omitempty"`
+}
@@ -1,15 +1,74 @@
 package socket
 
-import ()
+import (
+	"context"
 
+	"github.com/totegamma/concurrent/x/auth"
+	"github.com/totegamma/concurrent/x/stream"
+)
+
+// Service is the interface for socket service
 type Service interface {
+	Identify(ctx context.Context, token string) (string, error)
+	FilterReadable(ctx context.Context, channels []string, requester string) ([]string, []string)
+	Replay(ctx context.Context, channel string, since string, requester string) ([]stream.Event, bool, error)
 }
 
-// Service is socket service
 type service struct {
+	stream stream.Service
+	auth   auth.Service
 }
 
 // NewService is for wire.go
-func NewService() Service {
-	return &service{}
+func NewService(stream stream.Service, auth auth.Service) Service {
+	return &service{stream, auth}
+}
+
+// Identify returns the entity of the token sent through the socket
+func (s *service) Identify(ctx context.Context, token string) (string, error) {
+	claims, err := s.auth.Identify(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	return claims.Audience, nil
+}
+
+// FilterReadable splits channels into the ones the requester can subscribe and the others
+// Channels are the stream IDs with host, the same as the pubsub channels
+func (s *service) FilterReadable(ctx context.Context, channels []string, requester string) ([]string, []string) {
+	allowed := []string{}
+	denied := []string{}
+	for _, channel := range channels {
+		if s.stream.CanRead(ctx, channel, requester) {
+			allowed = append(allowed, channel)
+		} else {
+			denied = append(denied, channel)
+		}
+	}
+	return allowed, denied
+}
+
+// Replay returns the events of the elements posted to the channel after the element id in order
+// At most replayLimit events are returned, the second result tells if there are more.
+func (s *service) Replay(ctx context.Context, channel string, since string, requester string) ([]stream.Event, bool, error) {
+	elements, err := s.stream.GetSince(ctx, channel, since, replayLimit+1, requester)
+	if err != nil {
+		return nil, false, err
+	}
+
+	truncated := len(elements) > replayLimit
+	if truncated {
+		elements = elements[:replayLimit]
+	}
+
+	events := make([]stream.Event, 0, len(elements))
+	for _, element := range elements {
+		events = append(events, stream.Event{
+			Stream: channel,
+			Type:   element.Type,
+			Action: "create",
+			Body:   element,
+		})
+	}
+	return events, truncated, nil
 }
@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/tombstone"
 	"github.com/totegamma/concurrent/x/util"
 	"go.opentelemetry.io/otel"
 	"gorm.io/gorm"
@@ -23,11 +25,14 @@ type Handler interface {
 	Update(c echo.Context) error
     Recent(c echo.Context) error
     Range(c echo.Context) error
+    Page(c echo.Context) error
     List(c echo.Context) error
     ListMine(c echo.Context) error
     Delete(c echo.Context) error
     Remove(c echo.Context) error
+    Transfer(c echo.Context) error
     Checkpoint(c echo.Context) error
+    RetractCheckpoint(c echo.Context) error
 }
 
 type handler struct {
@@ -52,9 +57,21 @@ func (h handler) Get(c echo.Context) error {
 		}
 		return err
 	}
+	if !h.service.CanRead(ctx, streamID, requester(c)) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "You are not allowed to read this stream"})
+	}
 	return c.JSON(http.StatusOK, stream)
 }
 
+// requester returns the entity of the request, or empty string for anonymous requests
+func requester(c echo.Context) string {
+	claims, ok := c.Get("jwtclaims").(util.JwtClaims)
+	if !ok || claims.Subject != "CONCURRENT_API" {
+		return ""
+	}
+	return claims.Audience
+}
+
 // Create creates a new stream
 func (h handler) Create(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerCreate")
@@ -66,6 +83,8 @@ func (h handler) Create(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
 	}
 
+	data.Author = requester(c)
+
 	created, err := h.service.Create(ctx, data)
 	if err != nil {
 		span.RecordError(err)
@@ -90,9 +109,15 @@ func (h handler) Update(c echo.Context) error {
 
 	data.ID = id
 
-	updated, err := h.service.Update(ctx, data)
+	updated, err := h.service.Update(ctx, data, requester(c))
 	if err != nil {
 		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Stream not found"})
+		}
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
 	}
 
@@ -107,11 +132,46 @@ func (h handler) Recent(c echo.Context) error {
 
 	streamsStr := c.QueryParam("streams")
 	streams := strings.Split(streamsStr, ",")
-	messages, _ := h.service.GetRecent(ctx, streams, 16)
+	messages, err := h.service.GetRecent(ctx, streams, 16, requester(c))
+	if errors.Is(err, ErrPermissionDenied) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
 
 	return c.JSON(http.StatusOK, messages)
 }
 
+// Page returns a page of the merged timeline of streams
+// Use the returned next/prev cursor to continue to older/newer elements.
+func (h handler) Page(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerPage")
+	defer span.End()
+
+	var streams []string
+	if queryStreams := c.QueryParam("streams"); queryStreams != "" {
+		streams = strings.Split(queryStreams, ",")
+	}
+
+	limit := 16
+	if queryLimit := c.QueryParam("limit"); queryLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(queryLimit)
+		if err != nil || limit <= 0 || limit > 100 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "limit must be between 1 and 100"})
+		}
+	}
+
+	page, err := h.service.GetPage(ctx, streams, c.QueryParam("cursor"), limit, requester(c))
+	if errors.Is(err, ErrPermissionDenied) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": page})
+}
+
 // Range returns messages since to until in specified streams
 func (h handler) Range(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerRange")
@@ -132,11 +192,19 @@ func (h handler) Range(c echo.Context) error {
 		until = queryUntil
 	}
 
-	messages, _ := h.service.GetRange(ctx, streams, since, until, 16)
+	messages, err := h.service.GetRange(ctx, streams, since, until, 16, requester(c))
+	if errors.Is(err, ErrPermissionDenied) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
 	return c.JSON(http.StatusOK, messages)
 }
 
 // List returns stream ids which filtered by specific schema
+// Streams the requester cannot read are left out.
 func (h handler) List(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerList")
 	defer span.End()
@@ -147,7 +215,14 @@ func (h handler) List(c echo.Context) error {
 		span.RecordError(err)
 		return err
 	}
-	return c.JSON(http.StatusOK, list)
+
+	readable := make([]core.Stream, 0, len(list))
+	for _, stream := range list {
+		if IsReader(stream, requester(c)) {
+			readable = append(readable, stream)
+		}
+	}
+	return c.JSON(http.StatusOK, readable)
 }
 
 // ListMine returns stream ids which filtered by specific schema
@@ -177,28 +252,22 @@ func (h handler) Delete(c echo.Context) error {
 		streamID = split[0]
 	}
 
-	target, err := h.service.Get(ctx, streamID)
-	if err != nil {
-		span.RecordError(err)
-		return err
-	}
-
-	claims := c.Get("jwtclaims").(util.JwtClaims)
-	requester := claims.Audience
-
-	if target.Author != requester {
-		return c.JSON(http.StatusForbidden, echo.Map{"error": "You are not owner of this stream"})
-	}
-
-	err = h.service.Delete(ctx, streamID)
+	err := h.service.Delete(ctx, streamID, requester(c))
 	if err != nil {
 		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "You are not owner of this stream"})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Stream not found"})
+		}
 		return err
 	}
 	return c.String(http.StatusOK, fmt.Sprintf("{\"message\": \"accept\"}"))
 }
 
 // Remove is remove stream element from stream
+// The author of the element and the maintainers of the stream can remove it
 func (h handler) Remove(c echo.Context) error {
 	ctx, span := tracer.Start(c.Request().Context(), "HandlerRemove")
 	defer span.End()
@@ -211,22 +280,52 @@ func (h handler) Remove(c echo.Context) error {
 
 	elementID := c.Param("element")
 
-	target, err := h.service.GetElement(ctx, streamID, elementID)
+	err := h.service.Remove(ctx, streamID, elementID, requester(c))
 	if err != nil {
 		span.RecordError(err)
-		return err
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "You are not allowed to remove this stream element"})
+		}
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Stream element not found"})
 	}
 
-	claims := c.Get("jwtclaims").(util.JwtClaims)
-	requester := claims.Audience
+	return c.String(http.StatusOK, fmt.Sprintf("{\"message\": \"accept\"}"))
+}
 
-	if target.Author != requester {
-		return c.JSON(http.StatusForbidden, echo.Map{"error": "You are not owner of this stream element"})
+// Transfer hands the stream over to another entity
+// Only the author can transfer the stream
+func (h handler) Transfer(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerTransfer")
+	defer span.End()
+
+	streamID := c.Param("id")
+	split := strings.Split(streamID, "@")
+	if len(split) == 2 {
+		streamID = split[0]
 	}
 
-	h.service.Remove(ctx, streamID, elementID)
+	var request transferRequest
+	err := c.Bind(&request)
+	if err != nil || request.To == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
 
-	return c.String(http.StatusOK, fmt.Sprintf("{\"message\": \"accept\"}"))
+	transferred, err := h.service.Transfer(ctx, streamID, request.To, requester(c))
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "You are not owner of this stream"})
+		}
+		if errors.Is(err, ErrUnknownEntity) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "New author is not known"})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Stream not found"})
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": transferred})
 }
 
 // Checkpoint receives events from remote domains
@@ -241,11 +340,56 @@ func (h handler) Checkpoint(c echo.Context) error {
 		return err
 	}
 
-	err = h.service.Post(ctx, packet.Stream, packet.ID, packet.Type, packet.Author, packet.Host, packet.Owner)
+	host, ok := c.Get("requesterHost").(string)
+	if !ok {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "requesting domain is not known"})
+	}
+
+	err = h.service.Receive(ctx, packet, host)
 	if err != nil {
 		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
 		return nil
 	}
 
 	return c.String(http.StatusCreated, fmt.Sprintf("{\"message\": \"accept\"}"))
 }
+
+// RetractCheckpoint receives tombstones of deleted objects from remote domains
+// The tombstone must be signed by the domain sending the request
+func (h handler) RetractCheckpoint(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerRetractCheckpoint")
+	defer span.End()
+
+	var packet retractPacket
+	err := c.Bind(&packet)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request"})
+	}
+
+	object, err := tombstone.Verify(packet.Tombstone)
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	claims, ok := c.Get("jwtclaims").(util.JwtClaims)
+	host, _ := c.Get("requesterHost").(string)
+	if !ok || claims.Issuer != object.Signer || host != object.Host {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "tombstone is not signed by the requesting domain"})
+	}
+
+	err = h.service.Retract(ctx, packet.Stream, packet.Tombstone)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrPermissionDenied) {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return c.String(http.StatusOK, fmt.Sprintf("{\"message\": \"accept\"}"))
+}
@@ -1,7 +1,17 @@
 package stream
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/totegamma/concurrent/x/core"
+	"golang.org/x/exp/slices"
 )
 
 type postQuery struct {
@@ -28,6 +38,10 @@ type signedObject struct {
 	Visible    bool        `json:"visible"`
 }
 
+type transferRequest struct {
+	To string `json:"to"`
+}
+
 // Event is websocket root packet model
 type Event struct {
 	Stream string  `json:"stream"`
@@ -46,6 +60,11 @@ type Element struct {
 	Domain    string `json:"domain"`
 }
 
+type retractPacket struct {
+	Stream    string         `json:"stream"`
+	Tombstone core.Tombstone `json:"tombstone"`
+}
+
 type checkpointPacket struct {
 	Stream string `json:"stream"`
 	ID     string `json:"id"`
@@ -54,3 +73,213 @@ type checkpointPacket struct {
 	Host   string `json:"host"`
 	Owner  string `json:"owner"`
 }
+
+// ErrPermissionDenied is returned when the requester is not allowed to read the stream
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrUnknownEntity is returned when the stream is transferred to an entity not known here
+var ErrUnknownEntity = errors.New("unknown entity")
+
+// IsMaintainer reports whether the entity can maintain the stream
+// The author is always a maintainer.
+func IsMaintainer(stream core.Stream, entity string) bool {
+	if entity == "" {
+		return false
+	}
+	return stream.Author == entity || slices.Contains(stream.Maintainer, entity)
+}
+
+// IsReader reports whether the entity can read the stream
+// Streams without readers are public, an empty entity stands for an anonymous reader.
+func IsReader(stream core.Stream, entity string) bool {
+	if len(stream.Reader) == 0 {
+		return true
+	}
+	if entity == "" {
+		return false
+	}
+	return stream.Author == entity || slices.Contains(stream.Reader, entity)
+}
+
+// Hosts returns the distinct domains of the streams given in "id@host" form
+func Hosts(streams []string) []string {
+	hosts := []string{}
+	for _, stream := range streams {
+		_, host, found := strings.Cut(stream, "@")
+		if !found || host == "" {
+			continue
+		}
+		if !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// elementKey is the position of a stream element
+// It corresponds to the redis stream ID "ms-seq"
+type elementKey struct {
+	Ms  int64
+	Seq int64
+}
+
+// maxElementKey is larger than any element
+var maxElementKey = elementKey{math.MaxInt64, math.MaxInt64}
+
+func (k elementKey) String() string {
+	return fmt.Sprintf("%d-%d", k.Ms, k.Seq)
+}
+
+// redisID returns the ID usable for XRANGE arguments
+func (k elementKey) redisID() string {
+	if k == maxElementKey {
+		return "+"
+	}
+	if k.Ms < 0 {
+		return "-"
+	}
+	return k.String()
+}
+
+// less reports whether k is older than other
+func (k elementKey) less(other elementKey) bool {
+	if k.Ms != other.Ms {
+		return k.Ms < other.Ms
+	}
+	return k.Seq < other.Seq
+}
+
+// nextElementKey returns the key of a new element posted at now (unix milliseconds)
+// The key is larger than both the last stored element and floor, so that it can be added to redis as is.
+func nextElementKey(last elementKey, floor elementKey, now int64) elementKey {
+	if last.less(floor) {
+		last = floor
+	}
+	if last.Ms < now {
+		return elementKey{now, 0}
+	}
+	return last.next()
+}
+
+// next returns the smallest key larger than k
+// maxElementKey has no successor and is returned as is.
+func (k elementKey) next() elementKey {
+	if k == maxElementKey {
+		return k
+	}
+	if k.Seq < math.MaxInt64 {
+		return elementKey{k.Ms, k.Seq + 1}
+	}
+	return elementKey{k.Ms + 1, 0}
+}
+
+// prev returns the largest key smaller than k
+// maxElementKey stands for the open end and is returned as is.
+func (k elementKey) prev() elementKey {
+	if k == maxElementKey {
+		return k
+	}
+	if k.Seq > 0 {
+		return elementKey{k.Ms, k.Seq - 1}
+	}
+	return elementKey{k.Ms - 1, math.MaxInt64}
+}
+
+// parseElementKey parses redis stream ID including the special IDs "-" and "+"
+// Incomplete ID "ms" is completed as the upper or lower bound of the millisecond
+func parseElementKey(id string, upper bool) (elementKey, error) {
+	switch id {
+	case "-":
+		return elementKey{0, 0}, nil
+	case "+":
+		return maxElementKey, nil
+	}
+
+	msStr, seqStr, hasSeq := strings.Cut(id, "-")
+	ms, err := strconv.ParseInt(msStr, 10, 64)
+	if err != nil {
+		return elementKey{}, fmt.Errorf("invalid stream id: %v", id)
+	}
+	if !hasSeq {
+		if upper {
+			return elementKey{ms, math.MaxInt64}, nil
+		}
+		return elementKey{ms, 0}, nil
+	}
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if err != nil {
+		return elementKey{}, fmt.Errorf("invalid stream id: %v", id)
+	}
+	return elementKey{ms, seq}, nil
+}
+
+// After reports whether the element id is after the mark
+// A mark without the sequence covers the whole millisecond. Ids which can not be parsed are never after.
+func After(id string, mark string) bool {
+	key, err := parseElementKey(id, false)
+	if err != nil {
+		return false
+	}
+	markKey, err := parseElementKey(mark, true)
+	if err != nil {
+		return false
+	}
+	return markKey.less(key)
+}
+
+// Page is a chunk of the merged timeline of streams
+// Next continues to older elements, Prev to newer elements.
+type Page struct {
+	Elements []Element `json:"elements"`
+	Next     string    `json:"next"`
+	Prev     string    `json:"prev"`
+}
+
+const (
+	directionOlder = "older"
+	directionNewer = "newer"
+)
+
+// cursor holds the boundary of each stream. Elements on the boundary are excluded.
+type cursor struct {
+	Direction string                `json:"d"`
+	Positions map[string]elementKey `json:"p"`
+}
+
+func (k elementKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+func (k *elementKey) UnmarshalJSON(data []byte) error {
+	var id string
+	err := json.Unmarshal(data, &id)
+	if err != nil {
+		return err
+	}
+	*k, err = parseElementKey(id, false)
+	return err
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	if c.Direction != directionOlder && c.Direction != directionNewer {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	if len(c.Positions) == 0 {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
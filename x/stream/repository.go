@@ -2,9 +2,13 @@ package stream
 
 import (
 	"context"
+	"time"
+
+	"github.com/lib/pq"
 	"github.com/totegamma/concurrent/x/core"
 	"golang.org/x/exp/slices"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Repository is stream repository interface
@@ -17,6 +21,19 @@ type Repository interface {
     Delete(ctx context.Context, key string) error
     HasWriteAccess(ctx context.Context, key string, author string) bool
     HasReadAccess(ctx context.Context, key string, author string) bool
+    List(ctx context.Context) ([]core.Stream, error)
+    Transfer(ctx context.Context, streamID string, author string, maintainers []string) (core.Stream, error)
+    UpdateLimit(ctx context.Context, streamID string, maxLen int64, retention int64) error
+
+    AddElement(ctx context.Context, element core.StreamElement) error
+    AppendElement(ctx context.Context, element *core.StreamElement, floor elementKey) error
+    RemoveElement(ctx context.Context, stream string, ms int64, seq int64) error
+    GetElement(ctx context.Context, stream string, ms int64, seq int64) (core.StreamElement, error)
+    GetElementsByObject(ctx context.Context, stream string, objectID string) ([]core.StreamElement, error)
+    GetElements(ctx context.Context, stream string, until elementKey, since elementKey, limit int) ([]core.StreamElement, error)
+    GetElementsAfter(ctx context.Context, stream string, since elementKey, limit int) ([]core.StreamElement, error)
+    CollectElements(ctx context.Context, streamID string, fullname string) ([]core.StreamElement, error)
+    PruneElements(ctx context.Context, defaultRetention time.Duration) (int64, error)
 }
 
 
@@ -90,6 +107,24 @@ func (r *repository) Delete(ctx context.Context, streamID string) error {
 	return r.db.WithContext(ctx).Delete(&core.Stream{}, "id = ?", streamID).Error
 }
 
+// Transfer replaces the author and the maintainers of a stream
+func (r *repository) Transfer(ctx context.Context, streamID string, author string, maintainers []string) (core.Stream, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryTransfer")
+	defer span.End()
+
+	result := r.db.WithContext(ctx).Model(&core.Stream{}).Where("id = ?", streamID).Updates(map[string]interface{}{
+		"author":     author,
+		"maintainer": pq.StringArray(maintainers),
+	})
+	if result.Error != nil {
+		return core.Stream{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return core.Stream{}, gorm.ErrRecordNotFound
+	}
+	return r.Get(ctx, streamID)
+}
+
 // HasWriteAccess returns true if the user has write access
 func (r *repository) HasWriteAccess(ctx context.Context, streamID string, userAddress string) bool {
 	ctx, span := tracer.Start(ctx, "RepositoryHasWriteAccess")
@@ -110,8 +145,193 @@ func (r *repository) HasReadAccess(ctx context.Context, streamID string, userAdd
 
 	var stream core.Stream
 	r.db.WithContext(ctx).First(&stream, "id = ?", streamID)
-	if len(stream.Reader) == 0 {
-		return true
+	return IsReader(stream, userAddress)
+}
+
+// List returns all streams
+func (r *repository) List(ctx context.Context) ([]core.Stream, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryList")
+	defer span.End()
+
+	var streams []core.Stream
+	err := r.db.WithContext(ctx).Find(&streams).Error
+	return streams, err
+}
+
+// UpdateLimit sets the per stream maxLen and retention. 0 means the server default.
+func (r *repository) UpdateLimit(ctx context.Context, streamID string, maxLen int64, retention int64) error {
+	ctx, span := tracer.Start(ctx, "RepositoryUpdateLimit")
+	defer span.End()
+
+	result := r.db.WithContext(ctx).Model(&core.Stream{}).Where("id = ?", streamID).Updates(map[string]interface{}{
+		"max_len":   maxLen,
+		"retention": retention,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// AddElement stores a stream element. Already stored element is ignored.
+func (r *repository) AddElement(ctx context.Context, element core.StreamElement) error {
+	ctx, span := tracer.Start(ctx, "RepositoryAddElement")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&element).Error
+}
+
+// AppendElement stores a new element with the next key of the stream, and sets the key to the element
+// The stream is locked while the key is taken, so that concurrent posts get distinct keys.
+func (r *repository) AppendElement(ctx context.Context, element *core.StreamElement, floor elementKey) error {
+	ctx, span := tracer.Start(ctx, "RepositoryAppendElement")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT 1 FROM streams WHERE id = ? FOR UPDATE", element.Stream).Error
+		if err != nil {
+			return err
+		}
+
+		var last core.StreamElement
+		err = tx.Where("stream = ?", element.Stream).Order("ms desc, seq desc").Limit(1).Find(&last).Error
+		if err != nil {
+			return err
+		}
+
+		key := nextElementKey(elementKey{last.Ms, last.Seq}, floor, time.Now().UnixMilli())
+		element.Ms, element.Seq = key.Ms, key.Seq
+		return tx.Create(element).Error
+	})
+}
+
+// RemoveElement deletes a stream element
+func (r *repository) RemoveElement(ctx context.Context, stream string, ms int64, seq int64) error {
+	ctx, span := tracer.Start(ctx, "RepositoryRemoveElement")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Delete(&core.StreamElement{}, "stream = ? AND ms = ? AND seq = ?", stream, ms, seq).Error
+}
+
+// GetElement returns a stream element
+func (r *repository) GetElement(ctx context.Context, stream string, ms int64, seq int64) (core.StreamElement, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetElement")
+	defer span.End()
+
+	var element core.StreamElement
+	err := r.db.WithContext(ctx).First(&element, "stream = ? AND ms = ? AND seq = ?", stream, ms, seq).Error
+	return element, err
+}
+
+// GetElementsByObject returns the elements of the object in the stream
+func (r *repository) GetElementsByObject(ctx context.Context, stream string, objectID string) ([]core.StreamElement, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetElementsByObject")
+	defer span.End()
+
+	var elements []core.StreamElement
+	err := r.db.WithContext(ctx).Where("stream = ? AND object_id = ?", stream, objectID).Find(&elements).Error
+	return elements, err
+}
+
+// GetElements returns elements between since and until (both inclusive) in descending order
+func (r *repository) GetElements(ctx context.Context, stream string, until elementKey, since elementKey, limit int) ([]core.StreamElement, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetElements")
+	defer span.End()
+
+	var elements []core.StreamElement
+	err := r.db.WithContext(ctx).
+		Where("stream = ?", stream).
+		Where("(ms, seq) <= (?, ?)", until.Ms, until.Seq).
+		Where("(ms, seq) >= (?, ?)", since.Ms, since.Seq).
+		Order("ms desc, seq desc").
+		Limit(limit).
+		Find(&elements).Error
+	return elements, err
+}
+
+// GetElementsAfter returns elements since the key (inclusive) in ascending order
+func (r *repository) GetElementsAfter(ctx context.Context, stream string, since elementKey, limit int) ([]core.StreamElement, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGetElementsAfter")
+	defer span.End()
+
+	var elements []core.StreamElement
+	err := r.db.WithContext(ctx).
+		Where("stream = ?", stream).
+		Where("(ms, seq) >= (?, ?)", since.Ms, since.Seq).
+		Order("ms asc, seq asc").
+		Limit(limit).
+		Find(&elements).Error
+	return elements, err
+}
+
+// CollectElements builds elements from messages and associations posted to the stream
+// Ms is derived from the creation date, Seq is left 0.
+func (r *repository) CollectElements(ctx context.Context, streamID string, fullname string) ([]core.StreamElement, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryCollectElements")
+	defer span.End()
+
+	var messages []core.Message
+	err := r.db.WithContext(ctx).Select("id", "author", "c_date").Where("? = ANY(streams)", fullname).Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// stream entry of an association is owned by the author of the target message
+	var associations []struct {
+		ID     string
+		Author string
+		Owner  string
+		CDate  time.Time
+	}
+	err = r.db.WithContext(ctx).Table("associations").
+		Select("associations.id, associations.author, coalesce(messages.author, associations.author) as owner, associations.c_date").
+		Joins("LEFT JOIN messages ON messages.id = associations.target_id").
+		Where("? = ANY(associations.streams)", fullname).
+		Scan(&associations).Error
+	if err != nil {
+		return nil, err
 	}
-	return slices.Contains(stream.Reader, userAddress)
+
+	elements := make([]core.StreamElement, 0, len(messages)+len(associations))
+	for _, message := range messages {
+		elements = append(elements, core.StreamElement{
+			Stream:   streamID,
+			Ms:       message.CDate.UnixMilli(),
+			ObjectID: message.ID,
+			Type:     "message",
+			Author:   message.Author,
+			Owner:    message.Author,
+		})
+	}
+	for _, association := range associations {
+		elements = append(elements, core.StreamElement{
+			Stream:   streamID,
+			Ms:       association.CDate.UnixMilli(),
+			ObjectID: association.ID,
+			Type:     "association",
+			Author:   association.Author,
+			Owner:    association.Owner,
+		})
+	}
+
+	return elements, nil
+}
+
+// PruneElements deletes elements older than the retention of its stream
+func (r *repository) PruneElements(ctx context.Context, defaultRetention time.Duration) (int64, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryPruneElements")
+	defer span.End()
+
+	now := time.Now().UnixMilli()
+	result := r.db.WithContext(ctx).Exec(
+		`DELETE FROM stream_elements USING streams
+		WHERE stream_elements.stream = streams.id
+		AND (CASE WHEN streams.retention > 0 THEN streams.retention ELSE ? END) > 0
+		AND stream_elements.ms < ? - (CASE WHEN streams.retention > 0 THEN streams.retention ELSE ? END) * 1000`,
+		int64(defaultRetention.Seconds()), now, int64(defaultRetention.Seconds()),
+	)
+	return result.RowsAffected, result.Error
 }
@@ -1,56 +1,67 @@
 package stream
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/xid"
 	"github.com/totegamma/concurrent/x/core"
 	"github.com/totegamma/concurrent/x/entity"
+	"github.com/totegamma/concurrent/x/outbox"
+	"github.com/totegamma/concurrent/x/tombstone"
 	"github.com/totegamma/concurrent/x/util"
 
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/trace"
 )
 
 // Service is the interface for stream service
 type Service interface {
-    GetRecent(ctx context.Context, streams []string, limit int) ([]Element, error)
-    GetRange(ctx context.Context, streams []string, since string, until string, limit int) ([]Element, error)
+    GetRecent(ctx context.Context, streams []string, limit int, requester string) ([]Element, error)
+    GetRange(ctx context.Context, streams []string, since string, until string, limit int, requester string) ([]Element, error)
+    GetPage(ctx context.Context, streams []string, cursor string, limit int, requester string) (Page, error)
+    GetSince(ctx context.Context, stream string, since string, limit int, requester string) ([]Element, error)
+    CanRead(ctx context.Context, stream string, requester string) bool
+    CanReadAny(ctx context.Context, streams []string, requester string) bool
     GetElement(ctx context.Context, stream string, id string) (Element, error)
     Post(ctx context.Context, stream string, id string, typ string, author string, host string, owner string) error
-    Remove(ctx context.Context, stream string, id string)
+    Receive(ctx context.Context, packet checkpointPacket, requesterHost string) error
+    Remove(ctx context.Context, stream string, id string, requester string) error
+    Retract(ctx context.Context, stream string, tombstone core.Tombstone) error
+    NotifyUpdate(ctx context.Context, stream string, id string, host string) error
+    Trim(ctx context.Context, stream string, maxlen int64) (int64, error)
+    Rebuild(ctx context.Context, streamID string) (int64, error)
+    Prune(ctx context.Context) (int64, error)
 
     Create(ctx context.Context, stream core.Stream) (core.Stream, error)
-    Update(ctx context.Context, stream core.Stream) (core.Stream, error)
+    Update(ctx context.Context, stream core.Stream, requester string) (core.Stream, error)
     Get(ctx context.Context, key string) (core.Stream, error)
-    Delete(ctx context.Context, streamID string) error
+    Delete(ctx context.Context, streamID string, requester string) error
+    Transfer(ctx context.Context, streamID string, to string, requester string) (core.Stream, error)
 
     StreamListBySchema(ctx context.Context, schema string) ([]core.Stream, error)
     StreamListByAuthor(ctx context.Context, author string) ([]core.Stream, error)
+    List(ctx context.Context) ([]core.Stream, error)
+    SetLimit(ctx context.Context, streamID string, maxLen int64, retention time.Duration) error
 }
 
 type service struct {
 	rdb        *redis.Client
 	repository Repository
 	entity     entity.Service
+	outbox     outbox.Service
 	config     util.Config
 }
 
 // NewService creates a new service
-func NewService(rdb *redis.Client, repository Repository, entity entity.Service, config util.Config) Service {
-	return &service{rdb, repository, entity, config}
+func NewService(rdb *redis.Client, repository Repository, entity entity.Service, outbox outbox.Service, config util.Config) Service {
+	return &service{rdb, repository, entity, outbox, config}
 }
 
 func min(a, b int) int {
@@ -60,117 +71,476 @@ func min(a, b int) int {
 	return b
 }
 
+// rebuildPageSize is the number of elements read from postgres at once on Rebuild
+const rebuildPageSize = 1000
+
+// maxLen returns the number of elements kept in redis for the stream
+func (s *service) maxLen(stream core.Stream) int64 {
+	if stream.MaxLen > 0 {
+		return stream.MaxLen
+	}
+	return s.config.Server.StreamMaxLen
+}
+
+// fromXMessage converts redis stream entry to the element
+func fromXMessage(stream string, message redis.XMessage) (core.StreamElement, error) {
+	key, err := parseElementKey(message.ID, false)
+	if err != nil {
+		return core.StreamElement{}, err
+	}
+	id, _ := message.Values["id"].(string)
+	typ, ok := message.Values["type"].(string)
+	if !ok {
+		typ = "message"
+	}
+	author, _ := message.Values["author"].(string)
+	owner, ok := message.Values["owner"].(string)
+	if !ok {
+		owner = author
+	}
+	host, _ := message.Values["host"].(string)
+	return core.StreamElement{
+		Stream:   stream,
+		Ms:       key.Ms,
+		Seq:      key.Seq,
+		ObjectID: id,
+		Type:     typ,
+		Author:   author,
+		Owner:    owner,
+		Host:     host,
+	}, nil
+}
+
+// toXValues converts the element to the values of the redis stream entry
+func toXValues(element core.StreamElement) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     element.ObjectID,
+		"type":   element.Type,
+		"author": element.Author,
+		"owner":  element.Owner,
+		"host":   element.Host,
+	}
+}
+
+func fromXMessages(stream string, messages []redis.XMessage) []core.StreamElement {
+	elements := make([]core.StreamElement, 0, len(messages))
+	for _, message := range messages {
+		element, err := fromXMessage(stream, message)
+		if err != nil {
+			continue
+		}
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+func keyOf(element core.StreamElement) elementKey {
+	return elementKey{element.Ms, element.Seq}
+}
+
+// readOlder returns at most limit elements of each stream between since and until (both inclusive) in descending order
+// Streams are read from redis in a single pipeline. Elements already trimmed from redis are read from postgres.
+func (s *service) readOlder(ctx context.Context, until map[string]elementKey, since elementKey, limit int) map[string][]core.StreamElement {
+	ctx, span := tracer.Start(ctx, "ServiceReadOlder")
+	defer span.End()
+
+	pipe := s.rdb.Pipeline()
+	cmds := make(map[string]*redis.XMessageSliceCmd, len(until))
+	for stream, upper := range until {
+		cmds[stream] = pipe.XRevRangeN(ctx, stream, upper.redisID(), since.redisID(), int64(limit))
+	}
+	pipe.Exec(ctx)
+
+	result := make(map[string][]core.StreamElement, len(until))
+	for stream, cmd := range cmds {
+		elements := fromXMessages(stream, cmd.Val())
+		if len(elements) < limit {
+			upper := until[stream]
+			if len(elements) > 0 {
+				upper = keyOf(elements[len(elements)-1]).prev()
+			}
+			if !upper.less(since) {
+				history, err := s.repository.GetElements(ctx, stream, upper, since, limit-len(elements))
+				if err != nil {
+					span.RecordError(err)
+					log.Printf("fail to read stream history: %v", err)
+				}
+				elements = append(elements, history...)
+			}
+		}
+		result[stream] = elements
+	}
+	return result
+}
+
+// readNewer returns at most limit elements of each stream since the key (inclusive) in ascending order
+func (s *service) readNewer(ctx context.Context, since map[string]elementKey, limit int) map[string][]core.StreamElement {
+	ctx, span := tracer.Start(ctx, "ServiceReadNewer")
+	defer span.End()
+
+	pipe := s.rdb.Pipeline()
+	cmds := make(map[string]*redis.XMessageSliceCmd, len(since))
+	firsts := make(map[string]*redis.XMessageSliceCmd, len(since))
+	for stream, lower := range since {
+		cmds[stream] = pipe.XRangeN(ctx, stream, lower.redisID(), "+", int64(limit))
+		firsts[stream] = pipe.XRangeN(ctx, stream, "-", "+", 1)
+	}
+	pipe.Exec(ctx)
+
+	result := make(map[string][]core.StreamElement, len(since))
+	for stream, cmd := range cmds {
+		// redis is contiguous only after its oldest entry
+		first := fromXMessages(stream, firsts[stream].Val())
+		if len(first) > 0 && !since[stream].less(keyOf(first[0])) {
+			result[stream] = fromXMessages(stream, cmd.Val())
+			continue
+		}
+		elements, err := s.repository.GetElementsAfter(ctx, stream, since[stream], limit)
+		if err != nil {
+			span.RecordError(err)
+			log.Printf("fail to read stream history: %v", err)
+			elements = fromXMessages(stream, cmd.Val())
+		}
+		result[stream] = elements
+	}
+	return result
+}
+
+// merge merges elements of streams ordered by (ms, seq, stream)
+// Elements with the same key are ordered by stream so that both directions give the same order.
+func merge(perStream map[string][]core.StreamElement, descending bool) []core.StreamElement {
+	merged := []core.StreamElement{}
+	for _, elements := range perStream {
+		merged = append(merged, elements...)
+	}
+	sort.Slice(merged, func(l, r int) bool {
+		if descending {
+			l, r = r, l
+		}
+		lKey, rKey := keyOf(merged[l]), keyOf(merged[r])
+		if lKey == rKey {
+			return merged[l].Stream < merged[r].Stream
+		}
+		return lKey.less(rKey)
+	})
+	return merged
+}
+
+// uniq removes elements pointing the same object posted to multiple streams
+func uniq(elements []core.StreamElement) []core.StreamElement {
+	seen := make(map[string]bool)
+	result := []core.StreamElement{}
+	for _, element := range elements {
+		if seen[element.ObjectID] {
+			continue
+		}
+		seen[element.ObjectID] = true
+		result = append(result, element)
+	}
+	return result
+}
+
+// toElement converts the stored element to the shape published by Post
+// Domain is the current home of the author, so that elements of moved entities point to the new home.
+// The origin host is used only when the author is not known here.
+func (s *service) toElement(ctx context.Context, element core.StreamElement) Element {
+	host, err := s.entity.ResolveHost(ctx, element.Author)
+	if err != nil {
+		host = element.Host
+	}
+	owner := element.Owner
+	if owner == "" {
+		owner = element.Author
+	}
+	return Element{
+		Timestamp: keyOf(element).String(),
+		ID:        element.ObjectID,
+		Type:      element.Type,
+		Author:    element.Author,
+		Owner:     owner,
+		Domain:    host,
+	}
+}
+
+func (s *service) toElements(ctx context.Context, elements []core.StreamElement) []Element {
+	result := make([]Element, 0, len(elements))
+	for _, element := range elements {
+		result = append(result, s.toElement(ctx, element))
+	}
+	return result
+}
+
+// CanRead reports whether the requester can read the stream
+// stream may be given with its host. Streams of other domains are checked by the domain itself.
+// An empty requester stands for an anonymous reader.
+func (s *service) CanRead(ctx context.Context, stream string, requester string) bool {
+	ctx, span := tracer.Start(ctx, "ServiceCanRead")
+	defer span.End()
+
+	streamID, host, found := strings.Cut(stream, "@")
+	if found && host != s.config.Concurrent.FQDN {
+		return true
+	}
+	return s.repository.HasReadAccess(ctx, streamID, requester)
+}
+
+// CanReadAny reports whether the requester can read one of the streams
+// Used for objects posted to streams, which are as readable as the most open of them.
+// Objects posted to no stream are readable by anyone.
+func (s *service) CanReadAny(ctx context.Context, streams []string, requester string) bool {
+	if len(streams) == 0 {
+		return true
+	}
+	for _, stream := range streams {
+		if s.CanRead(ctx, stream, requester) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRead returns ErrPermissionDenied unless the requester can read all of the streams
+func (s *service) checkRead(ctx context.Context, streams []string, requester string) error {
+	for _, stream := range streams {
+		if !s.CanRead(ctx, stream, requester) {
+			return errors.Wrapf(ErrPermissionDenied, "stream %v", stream)
+		}
+	}
+	return nil
+}
+
 // GetRecent returns recent message from streams
-func (s *service) GetRecent(ctx context.Context, streams []string, limit int) ([]Element, error) {
+func (s *service) GetRecent(ctx context.Context, streams []string, limit int, requester string) ([]Element, error) {
 	ctx, span := tracer.Start(ctx, "ServiceGetRecent")
 	defer span.End()
 
-	var messages []redis.XMessage
-    for _, stream := range streams { // TODO: use pipeline
-		cmd := s.rdb.XRevRangeN(ctx, stream, "+", "-", int64(limit))
-		messages = append(messages, cmd.Val()...)
-	}
-	m := make(map[string]bool)
-	uniq := []redis.XMessage{}
-	for _, elem := range messages {
-		if !m[elem.Values["id"].(string)] {
-			m[elem.Values["id"].(string)] = true
-			uniq = append(uniq, elem)
-		}
-	}
-	sort.Slice(uniq, func(l, r int) bool {
-		lStr := strings.Replace(uniq[l].ID, "-", ".", 1)
-		rStr := strings.Replace(uniq[r].ID, "-", ".", 1)
-		lTime, _ := strconv.ParseFloat(lStr, 32)
-		rTime, _ := strconv.ParseFloat(rStr, 32)
-		return lTime > rTime
-	})
-	chopped := uniq[:min(len(uniq), limit)]
-	result := []Element{}
-
-	for _, elem := range chopped {
-		host, _ := s.entity.ResolveHost(ctx, elem.Values["author"].(string))
-		id, ok := elem.Values["id"].(string)
-		if !ok {
-			id = ""
-		}
-		typ, ok := elem.Values["type"].(string)
-		if !ok {
-			typ = "message"
-		}
-		author, ok := elem.Values["author"].(string)
-		if !ok {
-			author = ""
-		}
-		result = append(result, Element{
-			Timestamp: elem.ID,
-			ID:        id,
-			Type:      typ,
-			Author:    author,
-			Domain:    host,
-		})
+	err := s.checkRead(ctx, streams, requester)
+	if err != nil {
+		return nil, err
+	}
+
+	until := make(map[string]elementKey, len(streams))
+	for _, stream := range streams {
+		until[stream] = maxElementKey
 	}
+	merged := uniq(merge(s.readOlder(ctx, until, elementKey{0, 0}, limit), true))
+	chopped := merged[:min(len(merged), limit)]
 
-	return result, nil
+	return s.toElements(ctx, chopped), nil
 }
 
 // GetRange returns specified range messages from streams
-func (s *service) GetRange(ctx context.Context, streams []string, since string, until string, limit int) ([]Element, error) {
+func (s *service) GetRange(ctx context.Context, streams []string, since string, until string, limit int, requester string) ([]Element, error) {
 	ctx, span := tracer.Start(ctx, "ServiceGetRange")
 	defer span.End()
 
-	var messages []redis.XMessage
-	for _, stream := range streams { // TODO: use pipeline
-		cmd := s.rdb.XRevRangeN(ctx, stream, until, since, int64(limit))
-		messages = append(messages, cmd.Val()...)
-	}
-	m := make(map[string]bool)
-	uniq := []redis.XMessage{}
-	for _, elem := range messages {
-		if !m[elem.Values["id"].(string)] {
-			m[elem.Values["id"].(string)] = true
-			uniq = append(uniq, elem)
-		}
-	}
-	sort.Slice(uniq, func(l, r int) bool {
-		lStr := strings.Replace(uniq[l].ID, "-", ".", 1)
-		rStr := strings.Replace(uniq[r].ID, "-", ".", 1)
-		lTime, _ := strconv.ParseFloat(lStr, 32)
-		rTime, _ := strconv.ParseFloat(rStr, 32)
-		return lTime > rTime
-	})
-	chopped := uniq[:min(len(uniq), limit)]
-	result := []Element{}
-
-	for _, elem := range chopped {
-		host, _ := s.entity.ResolveHost(ctx, elem.Values["author"].(string))
-		id, ok := elem.Values["id"].(string)
-		if !ok {
-			id = ""
-		}
-		typ, ok := elem.Values["type"].(string)
-		if !ok {
-			typ = "message"
-		}
-		author, ok := elem.Values["author"].(string)
-		if !ok {
-			author = ""
-		}
-		result = append(result, Element{
-			Timestamp: elem.ID,
-			ID:        id,
-			Type:      typ,
-			Author:    author,
-			Domain:    host,
-		})
+	err := s.checkRead(ctx, streams, requester)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	lower, err := parseElementKey(since, false)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := parseElementKey(until, true)
+	if err != nil {
+		return nil, err
+	}
+
+	uppers := make(map[string]elementKey, len(streams))
+	for _, stream := range streams {
+		uppers[stream] = upper
+	}
+	merged := uniq(merge(s.readOlder(ctx, uppers, lower, limit), true))
+	chopped := merged[:min(len(merged), limit)]
+
+	return s.toElements(ctx, chopped), nil
+}
+
+// GetPage returns a page of the merged timeline of streams
+// Without a cursor, the page starts from the newest elements of the streams.
+// Returned cursors continue exactly before and after the page, so that paging never skips nor repeats an element.
+func (s *service) GetPage(ctx context.Context, streams []string, token string, limit int, requester string) (Page, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetPage")
+	defer span.End()
+
+	var c cursor
+	if token == "" {
+		c = cursor{Direction: directionOlder, Positions: make(map[string]elementKey, len(streams))}
+		for _, stream := range streams {
+			c.Positions[stream] = maxElementKey
+		}
+	} else {
+		var err error
+		c, err = decodeCursor(token)
+		if err != nil {
+			return Page{}, err
+		}
+	}
+	if len(c.Positions) == 0 {
+		return Page{Elements: []Element{}}, nil
+	}
+
+	// the cursor names the streams read, so it is checked instead of the query
+	positions := make([]string, 0, len(c.Positions))
+	for stream := range c.Positions {
+		positions = append(positions, stream)
+	}
+	err := s.checkRead(ctx, positions, requester)
+	if err != nil {
+		return Page{}, err
+	}
+
+	older := c.Direction == directionOlder
+
+	var perStream map[string][]core.StreamElement
+	bounds := make(map[string]elementKey, len(c.Positions))
+	if older {
+		for stream, position := range c.Positions {
+			bounds[stream] = position.prev()
+		}
+		perStream = s.readOlder(ctx, bounds, elementKey{0, 0}, limit)
+	} else {
+		for stream, position := range c.Positions {
+			bounds[stream] = position.next()
+		}
+		perStream = s.readNewer(ctx, bounds, limit)
+	}
+
+	taken, next, prev, hasOlder := paginate(c, perStream, limit, time.Now().UnixMilli())
+
+	page := Page{
+		Elements: s.toElements(ctx, taken),
+		Prev:     prev.encode(),
+	}
+	if hasOlder {
+		page.Next = next.encode()
+	}
+
+	return page, nil
+}
+
+// paginate takes a page from the elements read for the cursor and returns the cursors around it
+// perStream must hold at most limit elements of each stream ordered in the direction of the cursor.
+// The returned elements are newest first. hasOlder is false when no element remains older than the page.
+func paginate(c cursor, perStream map[string][]core.StreamElement, limit int, now int64) ([]core.StreamElement, cursor, cursor, bool) {
+	older := c.Direction == directionOlder
+
+	// a stream which returned a full list may have more elements beyond its last one,
+	// so elements past that point can not be taken without leaving a gap
+	var horizon *elementKey
+	for _, elements := range perStream {
+		if len(elements) < limit {
+			continue
+		}
+		last := keyOf(elements[len(elements)-1])
+		if horizon == nil || (older && horizon.less(last)) || (!older && last.less(*horizon)) {
+			horizon = &last
+		}
+	}
+
+	taken := []core.StreamElement{}
+	consumed := make(map[string][]elementKey)
+	seen := make(map[string]bool)
+	exhausted := true
+	for _, element := range merge(perStream, older) {
+		key := keyOf(element)
+		if len(taken) >= limit || (horizon != nil && (older && key.less(*horizon) || !older && horizon.less(key))) {
+			exhausted = false
+			break
+		}
+		consumed[element.Stream] = append(consumed[element.Stream], key)
+		if seen[element.ObjectID] {
+			continue
+		}
+		seen[element.ObjectID] = true
+		taken = append(taken, element)
+	}
+
+	next := cursor{Direction: directionOlder, Positions: make(map[string]elementKey, len(c.Positions))}
+	prev := cursor{Direction: directionNewer, Positions: make(map[string]elementKey, len(c.Positions))}
+	for stream, position := range c.Positions {
+		keys := consumed[stream]
+		if len(keys) == 0 {
+			if older {
+				next.Positions[stream] = position
+				if position == maxElementKey {
+					// the open end is fixed to now so that elements posted later are found by prev
+					prev.Positions[stream] = elementKey{now, 0}
+				} else {
+					prev.Positions[stream] = position.prev()
+				}
+			} else {
+				next.Positions[stream] = position.next()
+				prev.Positions[stream] = position
+			}
+			continue
+		}
+		if older {
+			next.Positions[stream] = keys[len(keys)-1]
+			prev.Positions[stream] = keys[0]
+		} else {
+			next.Positions[stream] = keys[0]
+			prev.Positions[stream] = keys[len(keys)-1]
+		}
+	}
+
+	// pages are always newest first
+	if !older {
+		for i, j := 0, len(taken)-1; i < j; i, j = i+1, j-1 {
+			taken[i], taken[j] = taken[j], taken[i]
+		}
+	}
+
+	return taken, next, prev, !older || !exhausted || horizon != nil
+}
+
+// GetSince returns at most limit elements of the stream after the element id in ascending order
+// stream is given with its host. Elements of streams on other domains are not stored here.
+func (s *service) GetSince(ctx context.Context, stream string, since string, limit int, requester string) ([]Element, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGetSince")
+	defer span.End()
+
+	streamID, host, found := strings.Cut(stream, "@")
+	if found && host != s.config.Concurrent.FQDN {
+		return nil, fmt.Errorf("stream %v is not stored here", stream)
+	}
+
+	err := s.checkRead(ctx, []string{stream}, requester)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := parseElementKey(since, true)
+	if err != nil {
+		return nil, err
+	}
+
+	elements := s.readNewer(ctx, map[string]elementKey{streamID: after.next()}, limit)[streamID]
+	return s.toElements(ctx, elements), nil
+}
+
+// Receive posts an element sent by a remote domain
+// The element must be sent by the home domain of its author.
+func (s *service) Receive(ctx context.Context, packet checkpointPacket, requesterHost string) error {
+	ctx, span := tracer.Start(ctx, "ServiceReceive")
+	defer span.End()
+
+	if packet.Host != requesterHost {
+		return errors.Wrap(ErrPermissionDenied, "element is not sent by its host")
+	}
+	home, err := s.entity.ResolveHost(ctx, packet.Author)
+	if err != nil || home != requesterHost {
+		return errors.Wrap(ErrPermissionDenied, "author is not homed on the requesting domain")
+	}
+
+	return s.Post(ctx, packet.Stream, packet.ID, packet.Type, packet.Author, packet.Host, packet.Owner)
 }
 
 // Post posts events to the stream.
-// If the stream is local, it will be posted to the local Redis.
-// If the stream is remote, it will be posted to the remote domain's Checkpoint.
+// If the stream is local, it will be stored to postgres with a new key and then added to the local Redis with the same key.
+// If the stream is remote, it will be queued to the outbox and delivered to the remote domain's Checkpoint.
 func (s *service) Post(ctx context.Context, stream string, id string, typ string, author string, host string, owner string) error {
 	ctx, span := tracer.Start(ctx, "ServicePost")
 	defer span.End()
@@ -199,17 +569,41 @@ func (s *service) Post(ctx context.Context, stream string, id string, typ string
 			return fmt.Errorf("You don't have write access to %v", streamID)
 		}
 
-		// add to stream
-		timestamp, err := s.rdb.XAdd(ctx, &redis.XAddArgs{
+		target, _ := s.repository.Get(ctx, streamID)
+
+		element := core.StreamElement{
+			Stream:   streamID,
+			ObjectID: id,
+			Type:     typ,
+			Author:   author,
+			Owner:    owner,
+			Host:     host,
+		}
+
+		// the key must also be above the top of redis, which can be ahead if postgres was rebuilt
+		floor := elementKey{}
+		top, err := s.rdb.XRevRangeN(ctx, streamID, "+", "-", 1).Result()
+		if err == nil && len(top) > 0 {
+			floor, _ = parseElementKey(top[0].ID, false)
+		}
+
+		// postgres keeps the whole history, redis keeps only recent elements
+		err = s.repository.AppendElement(ctx, &element, floor)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		timestamp := elementKey{element.Ms, element.Seq}.String()
+
+		err = s.rdb.XAdd(ctx, &redis.XAddArgs{
 			Stream: streamID,
-			ID:     "*",
-			Values: map[string]interface{}{
-				"id":     id,
-				"type":   typ,
-				"author": owner,
-			},
-		}).Result()
+			MaxLen: s.maxLen(target),
+			Approx: true,
+			ID:     timestamp,
+			Values: toXValues(element),
+		}).Err()
 		if err != nil {
+			// the element is kept in postgres, redis gets it back when the stream is rebuilt
 			span.RecordError(err)
 			log.Printf("fail to xadd: %v", err)
 		}
@@ -242,42 +636,11 @@ func (s *service) Post(ctx context.Context, stream string, id string, typ string
 			Host:   s.config.Concurrent.FQDN,
 			Owner:  owner,
 		}
-		packetStr, err := json.Marshal(packet)
-		if err != nil {
-			span.RecordError(err)
-			return err
-		}
-		req, err := http.NewRequest("POST", "https://"+streamHost+"/api/v1/streams/checkpoint", bytes.NewBuffer(packetStr))
-
-		if err != nil {
-			span.RecordError(err)
-			return err
-		}
-
-		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
-
-		jwt, err := util.CreateJWT(util.JwtClaims{
-			Issuer:         s.config.Concurrent.CCID,
-			Subject:        "CONCURRENT_API",
-			Audience:       streamHost,
-			ExpirationTime: strconv.FormatInt(time.Now().Add(1*time.Minute).Unix(), 10),
-			IssuedAt:       strconv.FormatInt(time.Now().Unix(), 10),
-			JWTID:          xid.New().String(),
-		}, s.config.Concurrent.PrivateKey)
-
-		req.Header.Add("content-type", "application/json")
-		req.Header.Add("authorization", "Bearer "+jwt)
-		client := new(http.Client)
-		resp, err := client.Do(req)
+		err := s.outbox.Enqueue(ctx, streamHost, "/streams/checkpoint", packet)
 		if err != nil {
 			span.RecordError(err)
 			return err
 		}
-		defer resp.Body.Close()
-
-		// TODO: response check
-		span.AddEvent("checkpoint response", trace.WithAttributes(attribute.String("response", resp.Status)))
-
 	}
 	return nil
 }
@@ -291,6 +654,9 @@ func (s *service) Create(ctx context.Context, obj core.Stream) (core.Stream, err
 		return core.Stream{}, fmt.Errorf("id must be empty")
 	}
 	obj.ID = xid.New().String()
+	// limits are set by the administrators
+	obj.MaxLen = 0
+	obj.Retention = 0
 
 	created, err := s.repository.Create(ctx, obj)
 	created.ID = created.ID + "@" + s.config.Concurrent.FQDN
@@ -299,7 +665,8 @@ func (s *service) Create(ctx context.Context, obj core.Stream) (core.Stream, err
 }
 
 // Update updates stream information
-func (s *service) Update(ctx context.Context, obj core.Stream) (core.Stream, error) {
+// Maintainers can edit the metadata and the writer/reader lists. Only the author can change the maintainers.
+func (s *service) Update(ctx context.Context, obj core.Stream, requester string) (core.Stream, error) {
 	ctx, span := tracer.Start(ctx, "ServiceUpdate")
 	defer span.End()
 
@@ -311,6 +678,23 @@ func (s *service) Update(ctx context.Context, obj core.Stream) (core.Stream, err
 		obj.ID = split[0]
 	}
 
+	current, err := s.repository.Get(ctx, obj.ID)
+	if err != nil {
+		span.RecordError(err)
+		return core.Stream{}, err
+	}
+	if !IsMaintainer(current, requester) {
+		return core.Stream{}, errors.Wrap(ErrPermissionDenied, "only maintainers can update the stream")
+	}
+
+	// ownership moves only through Transfer, and limits are set by the administrators
+	obj.Author = current.Author
+	obj.MaxLen = current.MaxLen
+	obj.Retention = current.Retention
+	if requester != current.Author {
+		obj.Maintainer = current.Maintainer
+	}
+
 	updated, err := s.repository.Update(ctx, obj)
 
 	updated.ID = updated.ID + "@" + s.config.Concurrent.FQDN
@@ -361,28 +745,354 @@ func (s *service) GetElement(ctx context.Context, stream string, id string) (Ele
 		return Element{}, err
 	}
 	if len(result) == 0 {
-		return Element{}, fmt.Errorf("element not found")
+		key, err := parseElementKey(id, false)
+		if err != nil {
+			return Element{}, err
+		}
+		element, err := s.repository.GetElement(ctx, stream, key.Ms, key.Seq)
+		if err != nil {
+			return Element{}, fmt.Errorf("element not found")
+		}
+		return s.toElement(ctx, element), nil
 	}
-	return Element{
-		Timestamp: result[0].ID,
-		ID:        result[0].Values["id"].(string),
-		Type:      result[0].Values["type"].(string),
-		Author:    result[0].Values["author"].(string),
-	}, nil
+	element, err := fromXMessage(stream, result[0])
+	if err != nil {
+		return Element{}, err
+	}
+	return s.toElement(ctx, element), nil
 }
 
 // Remove removes stream element by ID
-func (s *service) Remove(ctx context.Context, stream string, id string) {
+// The element can be removed by its author or the maintainers of the stream for moderation.
+func (s *service) Remove(ctx context.Context, stream string, id string, requester string) error {
 	ctx, span := tracer.Start(ctx, "ServiceRemove")
 	defer span.End()
 
+	target, err := s.repository.Get(ctx, stream)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	element, err := s.GetElement(ctx, stream, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if element.Author != requester && element.Owner != requester && !IsMaintainer(target, requester) {
+		return errors.Wrap(ErrPermissionDenied, "only the author or owner of the element or maintainers can remove it")
+	}
+
 	s.rdb.XDel(ctx, stream, id)
+
+	key, err := parseElementKey(id, false)
+	if err != nil {
+		return err
+	}
+	err = s.repository.RemoveElement(ctx, stream, key.Ms, key.Seq)
+	if err != nil {
+		span.RecordError(err)
+		log.Printf("fail to remove stream element: %v", err)
+		return err
+	}
+	return nil
 }
 
-// Delete deletes
-func (s *service) Delete(ctx context.Context, streamID string) error {
+// Retract removes every element of the deleted object from the stream
+// If the stream is remote, the tombstone is queued to the outbox and delivered to the remote domain's retract checkpoint.
+// The tombstone must be signed by the home domain of the author, and only elements of the author are removed.
+func (s *service) Retract(ctx context.Context, stream string, tomb core.Tombstone) error {
+	ctx, span := tracer.Start(ctx, "ServiceRetract")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("stream", stream))
+
+	streamID, streamHost, found := strings.Cut(stream, "@")
+	if !found {
+		return fmt.Errorf("Invalid format: %v", stream)
+	}
+
+	if streamHost != s.config.Concurrent.FQDN {
+		err := s.outbox.Enqueue(ctx, streamHost, "/streams/retract", retractPacket{Stream: stream, Tombstone: tomb})
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		return nil
+	}
+
+	object, err := tombstone.Verify(tomb)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	home, err := s.entity.ResolveHost(ctx, tomb.Author)
+	if err != nil || home != object.Host {
+		return errors.Wrap(ErrPermissionDenied, "tombstone is not signed by the home domain of the author")
+	}
+
+	elements, err := s.repository.GetElementsByObject(ctx, streamID, tomb.ID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	for _, element := range elements {
+		if element.Author != tomb.Author {
+			continue
+		}
+		key := keyOf(element)
+		s.rdb.XDel(ctx, streamID, key.String())
+		err = s.repository.RemoveElement(ctx, streamID, key.Ms, key.Seq)
+		if err != nil {
+			span.RecordError(err)
+			log.Printf("fail to remove stream element: %v", err)
+		}
+	}
+
+	jsonstr, _ := json.Marshal(Event{
+		Stream: stream,
+		Type:   tomb.Type,
+		Action: "delete",
+		Body: Element{
+			ID:     tomb.ID,
+			Type:   tomb.Type,
+			Author: tomb.Author,
+		},
+	})
+	err = s.rdb.Publish(context.Background(), stream, jsonstr).Err()
+	if err != nil {
+		span.RecordError(err)
+		log.Printf("fail to publish message to Redis: %v", err)
+	}
+
+	return nil
+}
+
+// NotifyUpdate publishes an update event of the object to the local stream
+// Called when the home domain of the object tells it is edited, so only elements of objects homed on the host are notified.
+func (s *service) NotifyUpdate(ctx context.Context, stream string, id string, host string) error {
+	ctx, span := tracer.Start(ctx, "ServiceNotifyUpdate")
+	defer span.End()
+
+	streamID, streamHost, found := strings.Cut(stream, "@")
+	if !found || streamHost != s.config.Concurrent.FQDN {
+		return fmt.Errorf("stream %v is not local", stream)
+	}
+
+	elements, err := s.repository.GetElementsByObject(ctx, streamID, id)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	for _, element := range elements {
+		body := s.toElement(ctx, element)
+		if body.Domain != host {
+			continue
+		}
+		jsonstr, _ := json.Marshal(Event{
+			Stream: stream,
+			Type:   body.Type,
+			Action: "update",
+			Body:   body,
+		})
+		err = s.rdb.Publish(context.Background(), stream, jsonstr).Err()
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		break
+	}
+
+	return nil
+}
+
+// Trim removes old stream elements so that at most maxlen elements remain
+func (s *service) Trim(ctx context.Context, stream string, maxlen int64) (int64, error) {
+	ctx, span := tracer.Start(ctx, "ServiceTrim")
+	defer span.End()
+
+	return s.rdb.XTrimMaxLen(ctx, stream, maxlen).Result()
+}
+
+// Rebuild repopulates the redis stream from postgres
+// Elements only in redis are stored in postgres first with their ids, and elements missing in both
+// are recovered from messages and associations posted to the stream.
+func (s *service) Rebuild(ctx context.Context, streamID string) (int64, error) {
+	ctx, span := tracer.Start(ctx, "ServiceRebuild")
+	defer span.End()
+
+	target, err := s.repository.Get(ctx, streamID)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	known := map[string]bool{}
+	used := map[elementKey]bool{}
+	until := maxElementKey
+	for {
+		stored, err := s.repository.GetElements(ctx, streamID, until, elementKey{0, 0}, rebuildPageSize)
+		if err != nil {
+			span.RecordError(err)
+			return 0, err
+		}
+		for _, element := range stored {
+			known[element.ObjectID] = true
+			used[keyOf(element)] = true
+		}
+		if len(stored) < rebuildPageSize {
+			break
+		}
+		until = keyOf(stored[len(stored)-1]).prev()
+	}
+
+	cached, err := s.rdb.XRange(ctx, streamID, "-", "+").Result()
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+		return 0, err
+	}
+	for _, element := range fromXMessages(streamID, cached) {
+		if known[element.ObjectID] || used[keyOf(element)] {
+			continue
+		}
+		known[element.ObjectID] = true
+		used[keyOf(element)] = true
+		err = s.repository.AddElement(ctx, element)
+		if err != nil {
+			span.RecordError(err)
+			return 0, err
+		}
+	}
+
+	collected, err := s.repository.CollectElements(ctx, streamID, streamID+"@"+s.config.Concurrent.FQDN)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+	for _, element := range collected {
+		if known[element.ObjectID] {
+			continue
+		}
+		// objects created in the same millisecond are given consecutive sequence numbers
+		for used[elementKey{element.Ms, element.Seq}] {
+			element.Seq++
+		}
+		used[elementKey{element.Ms, element.Seq}] = true
+		err = s.repository.AddElement(ctx, element)
+		if err != nil {
+			span.RecordError(err)
+			return 0, err
+		}
+	}
+
+	elements, err := s.repository.GetElements(ctx, streamID, maxElementKey, elementKey{0, 0}, int(s.maxLen(target)))
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, streamID)
+	for i := len(elements) - 1; i >= 0; i-- {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: streamID,
+			ID:     elementKey{elements[i].Ms, elements[i].Seq}.String(),
+			Values: toXValues(elements[i]),
+		})
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	return int64(len(elements)), nil
+}
+
+// Prune deletes stream elements older than the retention from postgres
+func (s *service) Prune(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "ServicePrune")
+	defer span.End()
+
+	return s.repository.PruneElements(ctx, s.config.Server.StreamRetention)
+}
+
+// List returns all streams
+func (s *service) List(ctx context.Context) ([]core.Stream, error) {
+	ctx, span := tracer.Start(ctx, "ServiceList")
+	defer span.End()
+
+	return s.repository.List(ctx)
+}
+
+// SetLimit sets the redis maxLen and the postgres retention of the stream
+// 0 means the server default
+func (s *service) SetLimit(ctx context.Context, streamID string, maxLen int64, retention time.Duration) error {
+	ctx, span := tracer.Start(ctx, "ServiceSetLimit")
+	defer span.End()
+
+	if maxLen < 0 || retention < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+
+	return s.repository.UpdateLimit(ctx, streamID, maxLen, int64(retention.Seconds()))
+}
+
+// Delete deletes a stream
+// Only the author can delete the stream
+func (s *service) Delete(ctx context.Context, streamID string, requester string) error {
 	ctx, span := tracer.Start(ctx, "ServiceDelete")
 	defer span.End()
 
+	current, err := s.repository.Get(ctx, streamID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if current.Author != requester {
+		return errors.Wrap(ErrPermissionDenied, "only the author can delete the stream")
+	}
+
 	return s.repository.Delete(ctx, streamID)
 }
+
+// Transfer hands the ownership of the stream over to another entity
+// Only the author can transfer the stream. The new author is removed from the maintainers as the author maintains anyway.
+func (s *service) Transfer(ctx context.Context, streamID string, to string, requester string) (core.Stream, error) {
+	ctx, span := tracer.Start(ctx, "ServiceTransfer")
+	defer span.End()
+
+	if to == "" {
+		return core.Stream{}, fmt.Errorf("new author must be specified")
+	}
+
+	current, err := s.repository.Get(ctx, streamID)
+	if err != nil {
+		span.RecordError(err)
+		return core.Stream{}, err
+	}
+	if current.Author != requester {
+		return core.Stream{}, errors.Wrap(ErrPermissionDenied, "only the author can transfer the stream")
+	}
+
+	// the new author must be known here, either local or homed on a known domain
+	if _, err := s.entity.ResolveHost(ctx, to); err != nil {
+		return core.Stream{}, errors.Wrap(ErrUnknownEntity, "new author is not known")
+	}
+
+	maintainers := []string{}
+	for _, maintainer := range current.Maintainer {
+		if maintainer != to {
+			maintainers = append(maintainers, maintainer)
+		}
+	}
+
+	transferred, err := s.repository.Transfer(ctx, streamID, to, maintainers)
+	if err != nil {
+		span.RecordError(err)
+		return core.Stream{}, err
+	}
+	transferred.ID = transferred.ID + "@" + s.config.Concurrent.FQDN
+	return transferred, nil
+}
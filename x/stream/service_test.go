@@ -0,0 +1,309 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/entity"
+)
+
+func TestParseElementKey(t *testing.T) {
+	cases := []struct {
+		id     string
+		upper  bool
+		expect elementKey
+	}{
+		{"-", false, elementKey{0, 0}},
+		{"+", true, elementKey{math.MaxInt64, math.MaxInt64}},
+		{"1700000000000-3", false, elementKey{1700000000000, 3}},
+		{"1700000000000", false, elementKey{1700000000000, 0}},
+		{"1700000000000", true, elementKey{1700000000000, math.MaxInt64}},
+	}
+	for _, c := range cases {
+		key, err := parseElementKey(c.id, c.upper)
+		if err != nil {
+			t.Errorf("%s: %v", c.id, err)
+		}
+		if key != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.id, c.expect, key)
+		}
+	}
+
+	_, err := parseElementKey("abc-1", false)
+	if err == nil {
+		t.Error("expected error for invalid id")
+	}
+
+	if (elementKey{10, 2}).prev() != (elementKey{10, 1}) {
+		t.Error("prev within the same millisecond")
+	}
+	if (elementKey{10, 0}).prev() != (elementKey{9, math.MaxInt64}) {
+		t.Error("prev across milliseconds")
+	}
+	if (elementKey{10, 2}).String() != "10-2" {
+		t.Error("unexpected string form")
+	}
+}
+
+func TestNextElementKey(t *testing.T) {
+	cases := []struct {
+		last   elementKey
+		floor  elementKey
+		now    int64
+		expect elementKey
+	}{
+		{elementKey{0, 0}, elementKey{0, 0}, 1700000000000, elementKey{1700000000000, 0}},
+		{elementKey{1700000000000, 0}, elementKey{0, 0}, 1700000000000, elementKey{1700000000000, 1}},
+		{elementKey{1700000000005, 2}, elementKey{0, 0}, 1700000000000, elementKey{1700000000005, 3}},
+		{elementKey{1700000000000, 0}, elementKey{1700000000000, 4}, 1700000000000, elementKey{1700000000000, 5}},
+	}
+	for _, c := range cases {
+		key := nextElementKey(c.last, c.floor, c.now)
+		if key != c.expect {
+			t.Errorf("last %v floor %v: expected %v, got %v", c.last, c.floor, c.expect, key)
+		}
+	}
+}
+
+// fakeRead reads the elements of streams like readOlder/readNewer do
+func fakeRead(data map[string][]core.StreamElement, c cursor, limit int) map[string][]core.StreamElement {
+	result := map[string][]core.StreamElement{}
+	for stream, position := range c.Positions {
+		elements := []core.StreamElement{}
+		all := data[stream]
+		if c.Direction == directionOlder {
+			for i := len(all) - 1; i >= 0 && len(elements) < limit; i-- {
+				if keyOf(all[i]).less(position) {
+					elements = append(elements, all[i])
+				}
+			}
+		} else {
+			for i := 0; i < len(all) && len(elements) < limit; i++ {
+				if position.less(keyOf(all[i])) {
+					elements = append(elements, all[i])
+				}
+			}
+		}
+		result[stream] = elements
+	}
+	return result
+}
+
+func TestAfter(t *testing.T) {
+	cases := []struct {
+		id     string
+		mark   string
+		expect bool
+	}{
+		{"1700000000000-3", "1700000000000-2", true},
+		{"1700000000000-2", "1700000000000-2", false},
+		{"1700000000000-1", "1700000000000-2", false},
+		{"1700000000001-0", "1700000000000", true},
+		{"1700000000000-5", "1700000000000", false},
+		{"", "1700000000000-2", false},
+	}
+	for _, c := range cases {
+		if After(c.id, c.mark) != c.expect {
+			t.Errorf("After(%q, %q): expected %v", c.id, c.mark, c.expect)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	data := map[string][]core.StreamElement{}
+	add := func(stream string, ms int64, seq int64, id string) {
+		data[stream] = append(data[stream], core.StreamElement{Stream: stream, Ms: ms, Seq: seq, ObjectID: id})
+	}
+	// elements within the same millisecond are ordered by seq, then by stream
+	add("a", 1000, 0, "a1")
+	add("a", 1000, 1, "a2")
+	add("a", 1000, 2, "a3")
+	add("b", 1000, 1, "b1")
+	add("b", 1001, 0, "b2")
+	add("c", 999, 5, "c1")
+	add("c", 1002, 0, "c2")
+	add("c", 1002, 1, "c3")
+	add("a", 1003, 0, "a4")
+	add("b", 1004, 0, "b3")
+	add("c", 1005, 0, "c4")
+	add("a", 1006, 0, "a5")
+	expected := []string{"a5", "c4", "b3", "a4", "c3", "c2", "b2", "a3", "b1", "a2", "a1", "c1"}
+
+	initial := cursor{Direction: directionOlder, Positions: map[string]elementKey{"a": maxElementKey, "b": maxElementKey, "c": maxElementKey}}
+
+	for _, limit := range []int{1, 2, 3, 5, 20} {
+		var got []string
+		var pages [][]core.StreamElement
+		var prevs []cursor
+		c := initial
+		for i := 0; ; i++ {
+			if i > len(expected)+1 {
+				t.Fatalf("limit %d: paging does not terminate", limit)
+			}
+			taken, next, prev, hasOlder := paginate(c, fakeRead(data, c, limit), limit, 2000)
+			for _, element := range taken {
+				got = append(got, element.ObjectID)
+			}
+			pages = append(pages, taken)
+			prevs = append(prevs, prev)
+			if !hasOlder {
+				break
+			}
+			c = next
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("limit %d: expected %v, got %v", limit, expected, got)
+		}
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Fatalf("limit %d: expected %v, got %v", limit, expected, got)
+			}
+		}
+
+		// going back from the last page returns the newer elements in order
+		last := len(pages) - 1
+		var back []string
+		c = prevs[last]
+		for i := 0; ; i++ {
+			if i > len(expected)+1 {
+				t.Fatalf("limit %d: backward paging does not terminate", limit)
+			}
+			taken, _, prev, _ := paginate(c, fakeRead(data, c, limit), limit, 2000)
+			if len(taken) == 0 {
+				break
+			}
+			page := []string{}
+			for _, element := range taken {
+				page = append(page, element.ObjectID)
+			}
+			back = append(page, back...)
+			c = prev
+		}
+		newer := len(got) - len(pages[last])
+		if len(back) != newer {
+			t.Fatalf("limit %d: expected %v, got %v", limit, expected[:newer], back)
+		}
+		for i := range back {
+			if back[i] != expected[i] {
+				t.Fatalf("limit %d: expected %v, got %v", limit, expected[:newer], back)
+			}
+		}
+	}
+
+	// elements posted after the first page are found by its prev cursor
+	_, _, prev, _ := paginate(initial, fakeRead(data, initial, 3), 3, 2000)
+	add("b", 2001, 0, "b4")
+	taken, _, _, _ := paginate(prev, fakeRead(data, prev, 3), 3, 3000)
+	if len(taken) != 1 || taken[0].ObjectID != "b4" {
+		t.Errorf("expected new element, got %v", taken)
+	}
+}
+
+func TestPaginateDuplicates(t *testing.T) {
+	// the same message posted to two streams appears once
+	data := map[string][]core.StreamElement{
+		"a": {{Stream: "a", Ms: 1, ObjectID: "m1"}, {Stream: "a", Ms: 3, ObjectID: "m2"}},
+		"b": {{Stream: "b", Ms: 2, ObjectID: "m3"}, {Stream: "b", Ms: 3, ObjectID: "m2"}},
+	}
+	c := cursor{Direction: directionOlder, Positions: map[string]elementKey{"a": maxElementKey, "b": maxElementKey}}
+	taken, _, _, hasOlder := paginate(c, fakeRead(data, c, 10), 10, 10)
+	if len(taken) != 3 || hasOlder {
+		t.Errorf("unexpected page: %v (hasOlder: %v)", taken, hasOlder)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := cursor{Direction: directionNewer, Positions: map[string]elementKey{"a": {1700000000000, 3}, "b": maxElementKey}}
+	decoded, err := decodeCursor(c.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Direction != c.Direction || decoded.Positions["a"] != c.Positions["a"] || decoded.Positions["b"] != c.Positions["b"] {
+		t.Errorf("cursor mismatch: %v", decoded)
+	}
+
+	for _, token := range []string{"", "!!", "e30"} {
+		_, err = decodeCursor(token)
+		if err == nil {
+			t.Errorf("expected error for %q", token)
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	hosts := Hosts([]string{"a@example.tld", "b@example.tld", "c@remote.tld", "invalid"})
+	if len(hosts) != 2 || hosts[0] != "example.tld" || hosts[1] != "remote.tld" {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestIsMaintainer(t *testing.T) {
+	stream := core.Stream{Author: "CCauthor", Maintainer: []string{"CCmaintainer"}}
+	if !IsMaintainer(stream, "CCauthor") || !IsMaintainer(stream, "CCmaintainer") {
+		t.Error("author and maintainers must be maintainers")
+	}
+	if IsMaintainer(stream, "CCother") || IsMaintainer(stream, "") {
+		t.Error("others must not be maintainers")
+	}
+}
+
+func TestIsReader(t *testing.T) {
+	public := core.Stream{Author: "CCauthor"}
+	if !IsReader(public, "CCother") || !IsReader(public, "") {
+		t.Error("stream without readers must be public")
+	}
+
+	private := core.Stream{Author: "CCauthor", Reader: []string{"CCreader"}}
+	if !IsReader(private, "CCauthor") || !IsReader(private, "CCreader") {
+		t.Error("author and readers must be readers")
+	}
+	if IsReader(private, "CCother") || IsReader(private, "") {
+		t.Error("others must not be readers")
+	}
+}
+
+type fakeEntity struct {
+	entity.Service
+	homes map[string]string
+}
+
+func (f fakeEntity) ResolveHost(ctx context.Context, user string) (string, error) {
+	home, ok := f.homes[user]
+	if !ok {
+		return "", fmt.Errorf("not found")
+	}
+	return home, nil
+}
+
+func TestToElement(t *testing.T) {
+	s := &service{entity: fakeEntity{homes: map[string]string{"CCmoved": "new.tld"}}}
+	element := s.toElement(context.Background(), core.StreamElement{
+		Ms:       1700000000000,
+		Seq:      1,
+		ObjectID: "association-id",
+		Type:     "association",
+		Author:   "CCliker",
+		Owner:    "CCowner",
+		Host:     "remote.tld",
+	})
+
+	expected := Element{
+		Timestamp: "1700000000000-1",
+		ID:        "association-id",
+		Type:      "association",
+		Author:    "CCliker",
+		Owner:     "CCowner",
+		Domain:    "remote.tld",
+	}
+	if element != expected {
+		t.Errorf("expected %v, got %v", expected, element)
+	}
+
+	moved := s.toElement(context.Background(), core.StreamElement{ObjectID: "message-id", Type: "message", Author: "CCmoved", Host: "old.tld"})
+	if moved.Domain != "new.tld" {
+		t.Errorf("element of a moved author must point to the new home, got %v", moved.Domain)
+	}
+}
@@ -0,0 +1,67 @@
+// Package timeline serves stream elements hydrated with their objects
+package timeline
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/totegamma/concurrent/x/stream"
+	"github.com/totegamma/concurrent/x/util"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("timeline")
+
+// Handler is the interface for handling HTTP requests
+type Handler interface {
+    Get(c echo.Context) error
+}
+
+type handler struct {
+	service Service
+}
+
+// NewHandler creates a new handler
+func NewHandler(service Service) Handler {
+	return &handler{service: service}
+}
+
+// Get returns a page of the timeline of streams
+// Elements carry the message or association and the author's character of the given schema.
+func (h handler) Get(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerGet")
+	defer span.End()
+
+	var streams []string
+	if queryStreams := c.QueryParam("streams"); queryStreams != "" {
+		streams = strings.Split(queryStreams, ",")
+	}
+
+	limit := 16
+	if queryLimit := c.QueryParam("limit"); queryLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(queryLimit)
+		if err != nil || limit <= 0 || limit > 100 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "limit must be between 1 and 100"})
+		}
+	}
+
+	var requester string
+	if claims, ok := c.Get("jwtclaims").(util.JwtClaims); ok && claims.Subject == "CONCURRENT_API" {
+		requester = claims.Audience
+	}
+
+	page, err := h.service.Get(ctx, streams, c.QueryParam("cursor"), limit, c.QueryParam("schema"), requester)
+	if errors.Is(err, stream.ErrPermissionDenied) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
+		span.RecordError(err)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": page})
+}
@@ -0,0 +1,23 @@
+package timeline
+
+import (
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/stream"
+)
+
+// Item is a stream element joined with its object and the author's character
+// Message or Association is nil when the object could not be resolved.
+type Item struct {
+	stream.Element
+	Message     *core.Message     `json:"message,omitempty"`
+	Association *core.Association `json:"association,omitempty"`
+	Character   *core.Character   `json:"character,omitempty"`
+}
+
+// Page is a chunk of the hydrated timeline
+// Next and Prev are the cursors of stream.Page.
+type Page struct {
+	Elements []Item `json:"elements"`
+	Next     string `json:"next"`
+	Prev     string `json:"prev"`
+}
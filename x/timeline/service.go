@@ -0,0 +1,232 @@
+package timeline
+
+import (
+	"context"
+	"sync"
+
+	"github.com/totegamma/concurrent/x/association"
+	"github.com/totegamma/concurrent/x/character"
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/message"
+	"github.com/totegamma/concurrent/x/stream"
+	"github.com/totegamma/concurrent/x/util"
+)
+
+// remoteConcurrency is the maximum number of remote objects fetched at once
+const remoteConcurrency = 8
+
+// Service is the interface for timeline service
+type Service interface {
+    Get(ctx context.Context, streams []string, cursor string, limit int, schema string, requester string) (Page, error)
+}
+
+type service struct {
+	stream      stream.Service
+	message     message.Service
+	association association.Service
+	character   character.Service
+	config      util.Config
+}
+
+// NewService creates a new timeline service
+func NewService(stream stream.Service, message message.Service, association association.Service, character character.Service, config util.Config) Service {
+	return &service{stream, message, association, character, config}
+}
+
+// Get returns a page of streams with the objects of the elements resolved
+// Local objects are loaded in batch and remote objects are fetched from their domain.
+// Characters are attached only when schema is given.
+func (s *service) Get(ctx context.Context, streams []string, cursor string, limit int, schema string, requester string) (Page, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGet")
+	defer span.End()
+
+	page, err := s.stream.GetPage(ctx, streams, cursor, limit, requester)
+	if err != nil {
+		span.RecordError(err)
+		return Page{}, err
+	}
+
+	items := make([]Item, len(page.Elements))
+	for i, element := range page.Elements {
+		items[i].Element = element
+	}
+
+	err = s.hydrate(ctx, items, requester)
+	if err != nil {
+		span.RecordError(err)
+		return Page{}, err
+	}
+
+	if schema != "" {
+		err = s.attachCharacters(ctx, items, schema)
+		if err != nil {
+			span.RecordError(err)
+			return Page{}, err
+		}
+	}
+
+	return Page{Elements: items, Next: page.Next, Prev: page.Prev}, nil
+}
+
+func (s *service) isLocal(element stream.Element) bool {
+	return element.Domain == "" || element.Domain == s.config.Concurrent.FQDN
+}
+
+// hydrate resolves the message or association of each item
+// Remote objects are read with the identity of the requester, as they may be in private streams.
+func (s *service) hydrate(ctx context.Context, items []Item, requester string) error {
+	ctx, span := tracer.Start(ctx, "ServiceHydrate")
+	defer span.End()
+
+	var messageIDs, associationIDs []string
+	var remote []int
+	for i, item := range items {
+		if !s.isLocal(item.Element) {
+			remote = append(remote, i)
+			continue
+		}
+		switch item.Type {
+		case "message":
+			messageIDs = append(messageIDs, item.ID)
+		case "association":
+			associationIDs = append(associationIDs, item.ID)
+		}
+	}
+
+	messages := map[string]*core.Message{}
+	if len(messageIDs) > 0 {
+		found, err := s.message.GetMulti(ctx, messageIDs)
+		if err != nil {
+			return err
+		}
+		for i := range found {
+			messages[found[i].ID] = &found[i]
+		}
+	}
+
+	associations := map[string]*core.Association{}
+	if len(associationIDs) > 0 {
+		found, err := s.association.GetMulti(ctx, associationIDs)
+		if err != nil {
+			return err
+		}
+		for i := range found {
+			associations[found[i].ID] = &found[i]
+		}
+	}
+
+	for i := range items {
+		switch items[i].Type {
+		case "message":
+			items[i].Message = messages[items[i].ID]
+		case "association":
+			items[i].Association = associations[items[i].ID]
+		}
+	}
+
+	// remote objects that cannot be fetched are left unresolved
+	s.parallel(remote, func(i int) {
+		item := &items[i]
+		switch item.Type {
+		case "message":
+			message, err := s.message.GetRemote(ctx, item.Domain, item.ID, requester)
+			if err != nil {
+				span.RecordError(err)
+				return
+			}
+			item.Message = &message
+		case "association":
+			association, err := s.association.GetRemote(ctx, item.Domain, item.ID, requester)
+			if err != nil {
+				span.RecordError(err)
+				return
+			}
+			item.Association = &association
+		}
+	})
+
+	return nil
+}
+
+// parallel calls fn for each index with at most remoteConcurrency calls running at once
+func (s *service) parallel(indices []int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, remoteConcurrency)
+	for _, i := range indices {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// attachCharacters sets the character of the schema for the author of each item
+func (s *service) attachCharacters(ctx context.Context, items []Item, schema string) error {
+	ctx, span := tracer.Start(ctx, "ServiceAttachCharacters")
+	defer span.End()
+
+	var authors []string
+	seen := map[string]bool{}
+	for _, item := range items {
+		author := authorOf(item)
+		if author != "" && !seen[author] {
+			seen[author] = true
+			authors = append(authors, author)
+		}
+	}
+	if len(authors) == 0 {
+		return nil
+	}
+
+	characters, err := s.character.GetCharactersByOwners(ctx, authors, schema)
+	if err != nil {
+		return err
+	}
+	byAuthor := make(map[string]*core.Character, len(characters))
+	for i := range characters {
+		byAuthor[characters[i].Author] = &characters[i]
+	}
+
+	// characters of remote authors are fetched from the domain of their element
+	var remote []int
+	fetching := map[string]bool{}
+	for i, item := range items {
+		author := authorOf(item)
+		if author == "" || byAuthor[author] != nil || fetching[author] || s.isLocal(item.Element) {
+			continue
+		}
+		fetching[author] = true
+		remote = append(remote, i)
+	}
+	var mutex sync.Mutex
+	s.parallel(remote, func(i int) {
+		author := authorOf(items[i])
+		found, err := s.character.GetRemote(ctx, items[i].Domain, author, schema)
+		if err != nil || len(found) == 0 {
+			return
+		}
+		mutex.Lock()
+		byAuthor[author] = &found[0]
+		mutex.Unlock()
+	})
+
+	for i := range items {
+		items[i].Character = byAuthor[authorOf(items[i])]
+	}
+	return nil
+}
+
+// authorOf returns the author of the resolved object, falling back to the element author
+func authorOf(item Item) string {
+	switch {
+	case item.Message != nil:
+		return item.Message.Author
+	case item.Association != nil:
+		return item.Association.Author
+	}
+	return item.Author
+}
@@ -0,0 +1,159 @@
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/totegamma/concurrent/x/association"
+	"github.com/totegamma/concurrent/x/character"
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/message"
+	"github.com/totegamma/concurrent/x/stream"
+	"github.com/totegamma/concurrent/x/util"
+)
+
+type fakeStream struct {
+	stream.Service
+	page stream.Page
+}
+
+func (f fakeStream) GetPage(ctx context.Context, streams []string, cursor string, limit int, requester string) (stream.Page, error) {
+	return f.page, nil
+}
+
+type fakeMessage struct {
+	message.Service
+	local  map[string]core.Message
+	remote map[string]core.Message
+}
+
+func (f fakeMessage) GetMulti(ctx context.Context, ids []string) ([]core.Message, error) {
+	result := []core.Message{}
+	for _, id := range ids {
+		if message, ok := f.local[id]; ok {
+			result = append(result, message)
+		}
+	}
+	return result, nil
+}
+
+func (f fakeMessage) GetRemote(ctx context.Context, host string, id string, requester string) (core.Message, error) {
+	message, ok := f.remote[host+"/"+id]
+	if !ok {
+		return core.Message{}, fmt.Errorf("not found")
+	}
+	return message, nil
+}
+
+type fakeAssociation struct {
+	association.Service
+	local map[string]core.Association
+}
+
+func (f fakeAssociation) GetMulti(ctx context.Context, ids []string) ([]core.Association, error) {
+	result := []core.Association{}
+	for _, id := range ids {
+		if association, ok := f.local[id]; ok {
+			result = append(result, association)
+		}
+	}
+	return result, nil
+}
+
+type fakeCharacter struct {
+	character.Service
+	local  []core.Character
+	remote map[string]core.Character
+}
+
+func (f fakeCharacter) GetCharactersByOwners(ctx context.Context, owners []string, schema string) ([]core.Character, error) {
+	return f.local, nil
+}
+
+func (f fakeCharacter) GetRemote(ctx context.Context, host string, owner string, schema string) ([]core.Character, error) {
+	character, ok := f.remote[host+"/"+owner]
+	if !ok {
+		return nil, nil
+	}
+	return []core.Character{character}, nil
+}
+
+func newTestService(page stream.Page) *service {
+	config := util.Config{}
+	config.Concurrent.FQDN = "local.tld"
+
+	return &service{
+		stream: fakeStream{page: page},
+		message: fakeMessage{
+			local:  map[string]core.Message{"m1": {ID: "m1", Author: "CClocal"}},
+			remote: map[string]core.Message{"remote.tld/m2": {ID: "m2", Author: "CCremote"}},
+		},
+		association: fakeAssociation{
+			local: map[string]core.Association{"a1": {ID: "a1", Author: "CCliker", TargetID: "m1"}},
+		},
+		character: fakeCharacter{
+			local:  []core.Character{{ID: "c1", Author: "CClocal"}},
+			remote: map[string]core.Character{"remote.tld/CCremote": {ID: "c2", Author: "CCremote"}},
+		},
+		config: config,
+	}
+}
+
+func TestGetHydrates(t *testing.T) {
+	s := newTestService(stream.Page{
+		Elements: []stream.Element{
+			{ID: "m1", Type: "message", Author: "CClocal", Domain: "local.tld"},
+			{ID: "a1", Type: "association", Author: "CCliker", Owner: "CClocal", Domain: ""},
+			{ID: "m2", Type: "message", Author: "CCremote", Domain: "remote.tld"},
+			{ID: "m3", Type: "message", Author: "CCgone", Domain: "remote.tld"},
+		},
+		Next: "next",
+	})
+
+	page, err := s.Get(context.Background(), []string{"s@local.tld"}, "", 16, "schema", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Next != "next" || len(page.Elements) != 4 {
+		t.Fatalf("unexpected page: %v", page)
+	}
+
+	items := page.Elements
+	if items[0].Message == nil || items[0].Message.ID != "m1" {
+		t.Error("local message must be resolved")
+	}
+	if items[1].Association == nil || items[1].Association.ID != "a1" {
+		t.Error("local association must be resolved")
+	}
+	if items[2].Message == nil || items[2].Message.ID != "m2" {
+		t.Error("remote message must be fetched from its domain")
+	}
+	if items[3].Message != nil {
+		t.Error("unreachable remote message must be left unresolved")
+	}
+
+	if items[0].Character == nil || items[0].Character.ID != "c1" {
+		t.Error("local character must be attached")
+	}
+	if items[1].Character != nil {
+		t.Error("character of the association author must be used, not the owner")
+	}
+	if items[2].Character == nil || items[2].Character.ID != "c2" {
+		t.Error("remote character must be fetched from the domain of the element")
+	}
+}
+
+func TestGetWithoutSchema(t *testing.T) {
+	s := newTestService(stream.Page{
+		Elements: []stream.Element{{ID: "m1", Type: "message", Author: "CClocal", Domain: "local.tld"}},
+	})
+
+	page, err := s.Get(context.Background(), []string{"s@local.tld"}, "", 16, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Elements[0].Character != nil {
+		t.Error("characters must not be attached without schema")
+	}
+}
@@ -0,0 +1,43 @@
+// Package tombstone keeps the signed proofs of deleted objects
+package tombstone
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+)
+
+var tracer = otel.Tracer("tombstone")
+
+// Handler is the interface for handling HTTP requests
+type Handler interface {
+    Get(c echo.Context) error
+}
+
+type handler struct {
+	service Service
+}
+
+// NewHandler creates a new handler
+func NewHandler(service Service) Handler {
+	return &handler{service: service}
+}
+
+// Get returns the tombstone of a deleted object
+func (h handler) Get(c echo.Context) error {
+	ctx, span := tracer.Start(c.Request().Context(), "HandlerGet")
+	defer span.End()
+
+	tombstone, err := h.service.Get(ctx, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Tombstone not found"})
+		}
+		span.RecordError(err)
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "content": tombstone})
+}
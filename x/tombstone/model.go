@@ -0,0 +1,17 @@
+package tombstone
+
+import (
+	"time"
+)
+
+// SignedObject is the domain signed proof of a deletion
+// Target is the ID of the deleted object and TargetType is "message" or "association".
+type SignedObject struct {
+	Signer     string    `json:"signer"`
+	Type       string    `json:"type"`
+	Target     string    `json:"target"`
+	TargetType string    `json:"targetType"`
+	Author     string    `json:"author"`
+	Host       string    `json:"host"`
+	SignedAt   time.Time `json:"signedAt"`
+}
@@ -0,0 +1,43 @@
+package tombstone
+
+import (
+	"context"
+
+	"github.com/totegamma/concurrent/x/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Repository is the interface for tombstone repository
+type Repository interface {
+    Create(ctx context.Context, tombstone core.Tombstone) error
+    Get(ctx context.Context, id string) (core.Tombstone, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+// NewRepository creates a new tombstone repository
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// Create stores a tombstone
+// The first tombstone of an object is kept if it is deleted again
+func (r *repository) Create(ctx context.Context, tombstone core.Tombstone) error {
+	ctx, span := tracer.Start(ctx, "RepositoryCreate")
+	defer span.End()
+
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&tombstone).Error
+}
+
+// Get returns a tombstone by the ID of the deleted object
+func (r *repository) Get(ctx context.Context, id string) (core.Tombstone, error) {
+	ctx, span := tracer.Start(ctx, "RepositoryGet")
+	defer span.End()
+
+	var tombstone core.Tombstone
+	err := r.db.WithContext(ctx).First(&tombstone, "id = ?", id).Error
+	return tombstone, err
+}
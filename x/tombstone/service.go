@@ -0,0 +1,108 @@
+package tombstone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/util"
+)
+
+// Service is the interface for tombstone service
+type Service interface {
+    Create(ctx context.Context, targetType string, target string, author string) (core.Tombstone, error)
+    Sign(targetType string, target string, author string) (core.Tombstone, error)
+    Get(ctx context.Context, id string) (core.Tombstone, error)
+}
+
+type service struct {
+	repo   Repository
+	config util.Config
+}
+
+// NewService creates a new tombstone service
+func NewService(repo Repository, config util.Config) Service {
+	return &service{repo, config}
+}
+
+// Create signs a tombstone of the deleted object with the domain key and stores it
+func (s *service) Create(ctx context.Context, targetType string, target string, author string) (core.Tombstone, error) {
+	ctx, span := tracer.Start(ctx, "ServiceCreate")
+	defer span.End()
+
+	tombstone, err := s.Sign(targetType, target, author)
+	if err != nil {
+		span.RecordError(err)
+		return core.Tombstone{}, err
+	}
+
+	err = s.repo.Create(ctx, tombstone)
+	if err != nil {
+		span.RecordError(err)
+		return core.Tombstone{}, err
+	}
+
+	return tombstone, nil
+}
+
+// Sign signs a tombstone of the object with the domain key without storing it
+// Used when the tombstone is stored in the same transaction as the deletion.
+func (s *service) Sign(targetType string, target string, author string) (core.Tombstone, error) {
+	object := SignedObject{
+		Signer:     s.config.Concurrent.CCID,
+		Type:       "tombstone",
+		Target:     target,
+		TargetType: targetType,
+		Author:     author,
+		Host:       s.config.Concurrent.FQDN,
+		SignedAt:   time.Now(),
+	}
+	objectBytes, err := json.Marshal(object)
+	if err != nil {
+		return core.Tombstone{}, err
+	}
+
+	signature, err := util.SignBytes(objectBytes, s.config.Concurrent.PrivateKey)
+	if err != nil {
+		return core.Tombstone{}, err
+	}
+
+	return core.Tombstone{
+		ID:        target,
+		Type:      targetType,
+		Author:    author,
+		Payload:   string(objectBytes),
+		Signature: signature,
+	}, nil
+}
+
+// Get returns a tombstone by the ID of the deleted object
+func (s *service) Get(ctx context.Context, id string) (core.Tombstone, error) {
+	ctx, span := tracer.Start(ctx, "ServiceGet")
+	defer span.End()
+
+	return s.repo.Get(ctx, id)
+}
+
+// Verify checks that the tombstone is signed by its signer and matches the signed object
+// The caller must check that the signer is the domain entitled to delete the object.
+func Verify(tombstone core.Tombstone) (SignedObject, error) {
+	var object SignedObject
+	err := json.Unmarshal([]byte(tombstone.Payload), &object)
+	if err != nil {
+		return object, err
+	}
+
+	if object.Type != "tombstone" || object.Target != tombstone.ID || object.TargetType != tombstone.Type || object.Author != tombstone.Author {
+		return object, fmt.Errorf("tombstone %s does not match the signed object", tombstone.ID)
+	}
+
+	err = util.VerifySignedObject(tombstone.Payload, object.Signer, tombstone.Signature)
+	if err != nil {
+		return object, err
+	}
+
+	return object, nil
+}
@@ -0,0 +1,85 @@
+package tombstone
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/totegamma/concurrent/x/core"
+	"github.com/totegamma/concurrent/x/util"
+)
+
+type memoryRepository map[string]core.Tombstone
+
+func (r memoryRepository) Create(ctx context.Context, tombstone core.Tombstone) error {
+	r[tombstone.ID] = tombstone
+	return nil
+}
+
+func (r memoryRepository) Get(ctx context.Context, id string) (core.Tombstone, error) {
+	return r[id], nil
+}
+
+func TestCreateAndVerify(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := util.Config{}
+	config.Concurrent.FQDN = "example.tld"
+	config.Concurrent.PrivateKey = hex.EncodeToString(crypto.FromECDSA(key))
+	config.Concurrent.CCID = "CC" + crypto.PubkeyToAddress(key.PublicKey).Hex()[2:]
+
+	repo := memoryRepository{}
+	service := NewService(repo, config)
+
+	tombstone, err := service.Create(context.Background(), "message", "target-id", "CCauthor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repo["target-id"]; !ok {
+		t.Error("tombstone must be stored")
+	}
+
+	object, err := Verify(tombstone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Signer != config.Concurrent.CCID || object.Host != "example.tld" {
+		t.Errorf("unexpected signed object: %v", object)
+	}
+
+	// the tombstone cannot be moved to another object
+	moved := tombstone
+	moved.ID = "other-id"
+	if _, err := Verify(moved); err == nil {
+		t.Error("expected error for moved tombstone")
+	}
+
+	forged := tombstone
+	forged.Signature = flip(tombstone.Signature)
+	if _, err := Verify(forged); err == nil {
+		t.Error("expected error for forged signature")
+	}
+
+	// signed only tombstones are stored by the caller
+	signed, err := service.Sign("association", "signed-id", "CCauthor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repo["signed-id"]; ok {
+		t.Error("signed tombstone must not be stored")
+	}
+	if _, err := Verify(signed); err != nil {
+		t.Errorf("signed tombstone must be valid: %v", err)
+	}
+}
+
+// flip changes the first hex digit of the signature
+func flip(signature string) string {
+	if signature[0] == '0' {
+		return "1" + signature[1:]
+	}
+	return "0" + signature[1:]
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/go-yaml/yaml"
 	"log"
 	"os"
+	"time"
 )
 
 // Config is Concurrent base configuration
@@ -23,6 +24,12 @@ type Server struct {
 	LogPath        string `yaml:"logPath"`
 	CaptchaSitekey string `yaml:"captchaSitekey"`
 	CaptchaSecret  string `yaml:"captchaSecret"`
+
+	TokenLifetime        time.Duration `yaml:"tokenLifetime"`        // default 6h
+	RefreshTokenLifetime time.Duration `yaml:"refreshTokenLifetime"` // default 720h
+
+	StreamMaxLen    int64         `yaml:"streamMaxLen"`    // default 10000, elements kept in redis per stream
+	StreamRetention time.Duration `yaml:"streamRetention"` // default 0 (keep forever)
 }
 
 type Concurrent struct {
@@ -68,6 +75,16 @@ func (c *Config) Load(path string) error {
 		return err
 	}
 
+	if c.Server.TokenLifetime == 0 {
+		c.Server.TokenLifetime = 6 * time.Hour
+	}
+	if c.Server.RefreshTokenLifetime == 0 {
+		c.Server.RefreshTokenLifetime = 30 * 24 * time.Hour
+	}
+	if c.Server.StreamMaxLen == 0 {
+		c.Server.StreamMaxLen = 10000
+	}
+
 	// generate worker public key
 	proxyPrivateKey, err := crypto.HexToECDSA(c.Concurrent.PrivateKey)
 	if err != nil {
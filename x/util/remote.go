@@ -0,0 +1,113 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/xid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// RemoteCacheTTL is how long objects fetched from other domains are cached
+const RemoteCacheTTL = 10 * time.Minute
+
+// requesterTokenLifetime is how long the token sent with a remote fetch is valid
+const requesterTokenLifetime = time.Minute
+
+var remoteClient = &http.Client{Timeout: 10 * time.Second}
+
+// ErrRemoteNotFound is returned when the remote domain does not have the object
+var ErrRemoteNotFound = fmt.Errorf("remote object not found")
+
+// ErrUnknownRemote is returned when objects are requested from a domain which is not known or blocked
+var ErrUnknownRemote = fmt.Errorf("remote domain is not known")
+
+// FetchRemote gets https://host/api/v1+path and decodes the JSON response into v
+// If the token is given, the object is read with the identity it carries (see RequesterToken).
+// It returns the raw body so that callers can cache it as is.
+func FetchRemote(ctx context.Context, host string, path string, token string, v interface{}) ([]byte, error) {
+	req, err := http.NewRequest("GET", "https://"+host+"/api/v1"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set("authorization", "Bearer "+token)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrRemoteNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s%s: %s", host, path, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return body, json.Unmarshal(body, v)
+}
+
+// RequesterToken creates a short lived jwt with which this domain vouches for the requester on remote fetches
+// The remote accepts it only if this domain is the home of the requester, otherwise the fetch is anonymous.
+// Returns empty token for anonymous requesters.
+func RequesterToken(config Config, requester string) (string, error) {
+	if requester == "" {
+		return "", nil
+	}
+	return CreateJWT(JwtClaims{
+		Issuer:         config.Concurrent.CCID,
+		Subject:        "CONCURRENT_API",
+		Audience:       requester,
+		ExpirationTime: strconv.FormatInt(time.Now().Add(requesterTokenLifetime).Unix(), 10),
+		IssuedAt:       strconv.FormatInt(time.Now().Unix(), 10),
+		JWTID:          xid.New().String(),
+	}, config.Concurrent.PrivateKey)
+}
+
+// GetRemoteCache returns the cached response of the key for the requester
+// Responses read with the identity of a requester may contain private objects,
+// so they are kept apart from the public copy and only returned to the same requester.
+func GetRemoteCache(ctx context.Context, rdb *redis.Client, key string, requester string) (string, error) {
+	cached, err := rdb.Get(ctx, key).Result()
+	if err == nil || requester == "" {
+		return cached, err
+	}
+	return rdb.HGet(ctx, scopedCacheKey(key), requester).Result()
+}
+
+// SetRemoteCache caches the response of the key read by the requester for RemoteCacheTTL
+func SetRemoteCache(ctx context.Context, rdb *redis.Client, key string, requester string, body []byte) error {
+	if requester == "" {
+		return rdb.Set(ctx, key, body, RemoteCacheTTL).Err()
+	}
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, scopedCacheKey(key), requester, body)
+	pipe.Expire(ctx, scopedCacheKey(key), RemoteCacheTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DeleteRemoteCache drops both the public and the identity scoped copies of the key
+func DeleteRemoteCache(ctx context.Context, rdb *redis.Client, key string) error {
+	return rdb.Del(ctx, key, scopedCacheKey(key)).Err()
+}
+
+func scopedCacheKey(key string) string {
+	return key + ":scoped"
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestRequesterToken(t *testing.T) {
+	privatekey, _, ccid := newTestKey(t)
+	config := Config{}
+	config.Concurrent.CCID = ccid
+	config.Concurrent.PrivateKey = privatekey
+
+	token, err := RequesterToken(config, "")
+	if err != nil || token != "" {
+		t.Fatalf("anonymous requester must not get a token: %q %v", token, err)
+	}
+
+	token, err = RequesterToken(config, "CCrequester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != ccid || claims.Audience != "CCrequester" || claims.Subject != "CONCURRENT_API" {
+		t.Errorf("token must be issued by the domain for the requester: %+v", claims)
+	}
+	if claims.Scope != "" || claims.Subkey != "" {
+		t.Errorf("token must not be scoped: %+v", claims)
+	}
+}
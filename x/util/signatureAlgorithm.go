@@ -0,0 +1,232 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/sha3"
+)
+
+// Key types, also used as the alg of JWT header
+const (
+	KeyTypeSecp256k1 = "ECRECOVER"
+	KeyTypeEd25519   = "ED25519"
+	KeyTypeWebAuthn  = "WEBAUTHN"
+)
+
+// SignatureAlgorithm verifies signatures made by one key type
+type SignatureAlgorithm interface {
+	// Verify checks the signature of the message and returns the public key of the signer.
+	// pubkey can be empty if the algorithm is able to recover it from the signature.
+	Verify(message []byte, signature []byte, pubkey []byte) ([]byte, error)
+}
+
+var signatureAlgorithms = map[string]SignatureAlgorithm{
+	KeyTypeSecp256k1: secp256k1Algorithm{},
+	KeyTypeEd25519:   ed25519Algorithm{},
+	KeyTypeWebAuthn:  webAuthnAlgorithm{},
+}
+
+// RegisterSignatureAlgorithm adds or replaces the algorithm for the key type
+func RegisterSignatureAlgorithm(keyType string, algorithm SignatureAlgorithm) {
+	signatureAlgorithms[strings.ToUpper(keyType)] = algorithm
+}
+
+// GetSignatureAlgorithm returns the algorithm for the key type
+// empty key type is treated as ECRECOVER for backward compatibility
+func GetSignatureAlgorithm(keyType string) (SignatureAlgorithm, error) {
+	if keyType == "" {
+		keyType = KeyTypeSecp256k1
+	}
+	algorithm, ok := signatureAlgorithms[strings.ToUpper(keyType)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %v", keyType)
+	}
+	return algorithm, nil
+}
+
+// PubkeyToCCID derives ccid from the public key
+// secp256k1 keys are derived as same as ethereum address, other keys are keccak256 hash of the raw public key
+func PubkeyToCCID(keyType string, pubkey []byte) string {
+	if keyType == "" || strings.ToUpper(keyType) == KeyTypeSecp256k1 {
+		if len(pubkey) == 65 {
+			pubkey = pubkey[1:]
+		}
+	}
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(pubkey)
+	return "CC" + common.BytesToAddress(hash.Sum(nil)[12:]).Hex()[2:]
+}
+
+// VerifySignatureWithKey verifies signature made by the key type
+// the public key must belong to the address
+func VerifySignatureWithKey(message []byte, signature []byte, keyType string, pubkey []byte, address string) error {
+	algorithm, err := GetSignatureAlgorithm(keyType)
+	if err != nil {
+		return err
+	}
+
+	signerKey, err := algorithm.Verify(message, signature, pubkey)
+	if err != nil {
+		return err
+	}
+
+	if len(address) < 2 || address[2:] != PubkeyToCCID(keyType, signerKey)[2:] {
+		return errors.New("signature validation failed")
+	}
+
+	return nil
+}
+
+// secp256k1Algorithm is keccak256 + ecrecover
+type secp256k1Algorithm struct{}
+
+func (secp256k1Algorithm) Verify(message []byte, signature []byte, pubkey []byte) ([]byte, error) {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(message)
+
+	recovered, err := crypto.Ecrecover(hash.Sum(nil), signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pubkey) > 0 && hex.EncodeToString(pubkey) != hex.EncodeToString(recovered) {
+		return nil, errors.New("signature validation failed")
+	}
+
+	return recovered, nil
+}
+
+// ed25519Algorithm is plain ed25519 over the message
+type ed25519Algorithm struct{}
+
+func (ed25519Algorithm) Verify(message []byte, signature []byte, pubkey []byte) ([]byte, error) {
+	if len(pubkey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pubkey), message, signature) {
+		return nil, errors.New("signature validation failed")
+	}
+	return pubkey, nil
+}
+
+// WebAuthnAssertion is the signature made by webauthn authenticator (passkey)
+// every field is base64url encoded
+type WebAuthnAssertion struct {
+	AuthenticatorData string `json:"authenticatorData"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	Signature         string `json:"signature"`
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+}
+
+// WebAuthnChallenge returns the challenge which must be signed by the authenticator for the message
+func WebAuthnChallenge(message []byte) string {
+	hash := sha256.Sum256(message)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// webAuthnAlgorithm verifies webauthn assertion made by P-256 (ES256) credential
+// the signature is json encoded WebAuthnAssertion, and the public key is uncompressed P-256 point.
+// rpId is not checked because the same key may be used from any client.
+type webAuthnAlgorithm struct{}
+
+func (webAuthnAlgorithm) Verify(message []byte, signature []byte, pubkey []byte) ([]byte, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), pubkey)
+	if x == nil {
+		return nil, fmt.Errorf("invalid webauthn public key")
+	}
+
+	var assertion WebAuthnAssertion
+	err := json.Unmarshal(signature, &assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	authData, err := base64.RawURLEncoding.DecodeString(assertion.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	clientDataJSON, err := base64.RawURLEncoding.DecodeString(assertion.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(assertion.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	// rpIdHash(32) + flags(1) + signCount(4)
+	if len(authData) < 37 {
+		return nil, fmt.Errorf("invalid authenticator data")
+	}
+	if authData[32]&0x01 == 0 {
+		return nil, fmt.Errorf("user presence is not asserted")
+	}
+
+	var clientData webAuthnClientData
+	err = json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil {
+		return nil, err
+	}
+	if clientData.Type != "webauthn.get" {
+		return nil, fmt.Errorf("invalid webauthn client data type")
+	}
+	if strings.TrimRight(clientData.Challenge, "=") != WebAuthnChallenge(message) {
+		return nil, fmt.Errorf("webauthn challenge mismatch")
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	key := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if !ecdsa.VerifyASN1(&key, signed[:], sig) {
+		return nil, errors.New("signature validation failed")
+	}
+
+	return pubkey, nil
+}
+
+// signingKey is the key information embedded in signed objects
+type signingKey struct {
+	KeyType string `json:"keyType"`
+	Pubkey  string `json:"pubkey"`
+}
+
+// VerifySignedObject verifies hex encoded signature of the signed object
+// the object can specify keyType and pubkey of the signer. pubkey is required unless the key type is ECRECOVER.
+func VerifySignedObject(objectStr string, signer string, signature string) error {
+	var key signingKey
+	err := json.Unmarshal([]byte(objectStr), &key)
+	if err != nil {
+		return err
+	}
+
+	sigBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+
+	var pubkey []byte
+	if key.Pubkey != "" {
+		pubkey, err = hex.DecodeString(key.Pubkey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return VerifySignatureWithKey([]byte(objectStr), sigBytes, key.KeyType, pubkey, signer)
+}
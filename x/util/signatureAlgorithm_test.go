@@ -0,0 +1,99 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestVerifySignedObjectSecp256k1(t *testing.T) {
+	privatekey, _, ccid := newTestKey(t)
+
+	object := fmt.Sprintf(`{"signer":"%s","type":"message","body":"hello"}`, ccid)
+	signature, err := SignBytes([]byte(object), privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifySignedObject(object, ccid, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifySignedObjectEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ccid := PubkeyToCCID(KeyTypeEd25519, pub)
+
+	object := fmt.Sprintf(`{"signer":"%s","keyType":"ED25519","pubkey":"%s","body":"hello"}`, ccid, hex.EncodeToString(pub))
+	signature := hex.EncodeToString(ed25519.Sign(priv, []byte(object)))
+
+	err = VerifySignedObject(object, ccid, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key must belong to the signer
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	err = VerifySignedObject(object, PubkeyToCCID(KeyTypeEd25519, other), signature)
+	if err == nil {
+		t.Error("expected error for another signer")
+	}
+}
+
+func TestVerifySignedObjectWebAuthn(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := elliptic.Marshal(elliptic.P256(), key.X, key.Y)
+	ccid := PubkeyToCCID(KeyTypeWebAuthn, pub)
+
+	object := fmt.Sprintf(`{"signer":"%s","keyType":"WEBAUTHN","pubkey":"%s","body":"hello"}`, ccid, hex.EncodeToString(pub))
+
+	assert := func(message string, flags byte) string {
+		authData := make([]byte, 37)
+		authData[32] = flags
+		clientDataJSON, _ := json.Marshal(webAuthnClientData{
+			Type:      "webauthn.get",
+			Challenge: WebAuthnChallenge([]byte(message)),
+		})
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		signed := sha256.Sum256(append(authData, clientDataHash[:]...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, signed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion, _ := json.Marshal(WebAuthnAssertion{
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			Signature:         base64.RawURLEncoding.EncodeToString(sig),
+		})
+		return hex.EncodeToString(assertion)
+	}
+
+	err = VerifySignedObject(object, ccid, assert(object, 0x01))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifySignedObject(object, ccid, assert("another object", 0x01))
+	if err == nil {
+		t.Error("expected error for challenge mismatch")
+	}
+
+	err = VerifySignedObject(object, ccid, assert(object, 0x00))
+	if err == nil {
+		t.Error("expected error without user presence")
+	}
+}
@@ -0,0 +1,158 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/sha3"
+)
+
+// SignedRequestSkew is the maximum accepted difference between the date of a signed request and now
+const SignedRequestSkew = 5 * time.Minute
+
+// signedHeaders is the list of headers covered by the request signature
+var signedHeaders = []string{"(request-target)", "host", "date", "digest", "cc-request-id"}
+
+// SignedRequest is the verified signature information of a server-to-server request
+type SignedRequest struct {
+	KeyID  string    `json:"keyId"`  // CCID of the signer
+	Pubkey string    `json:"pubkey"` // public key recovered from the signature
+	JTI    string    `json:"jti"`    // request ID for replay protection
+	Date   time.Time `json:"date"`
+}
+
+// SignRequest signs the request with the domain key
+// body must be the exact bytes sent as the request body (nil for empty body)
+func SignRequest(req *http.Request, body []byte, ccid string, privatekey string) error {
+	digest := sha256.Sum256(body)
+
+	req.Header.Set("date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+	req.Header.Set("cc-request-id", xid.New().String())
+
+	target := strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+	signingString := buildSigningString(target, req.URL.Host, req.Header)
+
+	signature, err := SignBytes([]byte(signingString), privatekey)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("signature", fmt.Sprintf(
+		`keyId="%s",algorithm="ecrecover",headers="%s",signature="%s"`,
+		ccid,
+		strings.Join(signedHeaders, " "),
+		signature,
+	))
+
+	return nil
+}
+
+// VerifyRequest checks the signature, digest and date of the request
+// If the request is proxied, the original request target is taken from the X-Forwarded-Uri header
+func VerifyRequest(req *http.Request, body []byte) (SignedRequest, error) {
+	params, err := parseSignatureHeader(req.Header.Get("signature"))
+	if err != nil {
+		return SignedRequest{}, err
+	}
+
+	if params["algorithm"] != "ecrecover" {
+		return SignedRequest{}, fmt.Errorf("unsupported signature algorithm")
+	}
+	if params["headers"] != strings.Join(signedHeaders, " ") {
+		return SignedRequest{}, fmt.Errorf("signature does not cover required headers")
+	}
+
+	// check digest
+	digest := sha256.Sum256(body)
+	if req.Header.Get("digest") != "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]) {
+		return SignedRequest{}, fmt.Errorf("digest mismatch")
+	}
+
+	// check date
+	date, err := http.ParseTime(req.Header.Get("date"))
+	if err != nil {
+		return SignedRequest{}, fmt.Errorf("invalid date header")
+	}
+	if diff := time.Since(date); diff > SignedRequestSkew || diff < -SignedRequestSkew {
+		return SignedRequest{}, fmt.Errorf("request date is out of acceptable range")
+	}
+
+	jti := req.Header.Get("cc-request-id")
+	if jti == "" {
+		return SignedRequest{}, fmt.Errorf("request id is missing")
+	}
+
+	uri := req.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+	target := strings.ToLower(req.Method) + " " + uri
+	signingString := buildSigningString(target, req.Host, req.Header)
+
+	// recover signer
+	sigBytes, err := hex.DecodeString(params["signature"])
+	if err != nil {
+		return SignedRequest{}, err
+	}
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte(signingString))
+	recoveredPub, err := crypto.Ecrecover(hash.Sum(nil), sigBytes)
+	if err != nil {
+		return SignedRequest{}, err
+	}
+	pubkey, err := crypto.UnmarshalPubkey(recoveredPub)
+	if err != nil {
+		return SignedRequest{}, err
+	}
+
+	ccid := "CC" + crypto.PubkeyToAddress(*pubkey).Hex()[2:]
+	if ccid != params["keyId"] {
+		return SignedRequest{}, fmt.Errorf("signature validation failed")
+	}
+
+	return SignedRequest{
+		KeyID:  ccid,
+		Pubkey: hex.EncodeToString(recoveredPub),
+		JTI:    jti,
+		Date:   date,
+	}, nil
+}
+
+func buildSigningString(target string, host string, header http.Header) string {
+	lines := []string{
+		"(request-target): " + target,
+		"host: " + host,
+		"date: " + header.Get("date"),
+		"digest: " + header.Get("digest"),
+		"cc-request-id: " + header.Get("cc-request-id"),
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseSignatureHeader(header string) (map[string]string, error) {
+	if header == "" {
+		return nil, fmt.Errorf("signature header is missing")
+	}
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid signature header")
+		}
+		params[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+	for _, key := range []string{"keyId", "algorithm", "headers", "signature"} {
+		if params[key] == "" {
+			return nil, fmt.Errorf("signature header lacks %v", key)
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestKey(t *testing.T) (string, string, string) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatekey := hex.EncodeToString(crypto.FromECDSA(key))
+	pubkey := hex.EncodeToString(crypto.FromECDSAPub(&key.PublicKey))
+	ccid := "CC" + crypto.PubkeyToAddress(key.PublicKey).Hex()[2:]
+	return privatekey, pubkey, ccid
+}
+
+func TestSignedRequest(t *testing.T) {
+	privatekey, pubkey, ccid := newTestKey(t)
+
+	body := []byte(`{"stream":"abc@example.tld"}`)
+	req, err := http.NewRequest("POST", "https://example.tld/api/v1/streams/checkpoint", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SignRequest(req, body, ccid, privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as seen by the api server behind the gateway
+	req.Host = "example.tld"
+	req.Header.Set("X-Forwarded-Uri", req.URL.RequestURI())
+	req.URL.Path = "/streams/checkpoint"
+
+	verified, err := VerifyRequest(req, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.KeyID != ccid {
+		t.Errorf("expected keyId %s, got %s", ccid, verified.KeyID)
+	}
+	if verified.Pubkey != pubkey {
+		t.Errorf("expected pubkey %s, got %s", pubkey, verified.Pubkey)
+	}
+
+	_, err = VerifyRequest(req, []byte(`{"stream":"tampered@example.tld"}`))
+	if err == nil {
+		t.Error("expected digest mismatch error")
+	}
+
+	req.Header.Set("X-Forwarded-Uri", "/api/v1/domains/hello")
+	_, err = VerifyRequest(req, body)
+	if err == nil {
+		t.Error("expected signature validation error for different target")
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/pkg/errors"
 	"golang.org/x/crypto/sha3"
 	"strconv"
 	"strings"
@@ -23,55 +22,12 @@ func VerifySignature(message string, address string, signature string) error {
 		return err
 	}
 
-	// メッセージをKeccak256でハッシュ化
-	hash := sha3.NewLegacyKeccak256()
-	hash.Write([]byte(message))
-	hashedMessage := hash.Sum(nil)
-
-	recoveredPub, err := crypto.Ecrecover(hashedMessage, sigBytes)
-	if err != nil {
-		return err
-	}
-
-	pubkey, err := crypto.UnmarshalPubkey(recoveredPub)
-	if err != nil {
-		return err
-	}
-	sigaddr := crypto.PubkeyToAddress(*pubkey)
-
-	verified := address[2:] == sigaddr.Hex()[2:]
-	if verified {
-		return nil
-	}
-
-	return errors.New("signature validation failed")
+	return VerifySignatureFromBytes([]byte(message), sigBytes, address)
 }
 
 // VerifySignatureFromBytes verifies a keccak256 signature
 func VerifySignatureFromBytes(message []byte, signature []byte, address string) error {
-
-	// メッセージをKeccak256でハッシュ化
-	hash := sha3.NewLegacyKeccak256()
-	hash.Write(message)
-	hashedMessage := hash.Sum(nil)
-
-	recoveredPub, err := crypto.Ecrecover(hashedMessage, signature)
-	if err != nil {
-		return err
-	}
-
-	pubkey, err := crypto.UnmarshalPubkey(recoveredPub)
-	if err != nil {
-		return err
-	}
-	sigaddr := crypto.PubkeyToAddress(*pubkey)
-
-	verified := address[2:] == sigaddr.Hex()[2:]
-	if verified {
-		return nil
-	}
-
-	return errors.New("signature validation failed")
+	return VerifySignatureWithKey(message, signature, KeyTypeSecp256k1, nil, address)
 }
 
 // CreateJWT creates server signed JWT
@@ -153,9 +109,21 @@ func ValidateJWT(jwt string) (JwtClaims, error) {
 	}
 
 	// check jwt type
-	if header.Type != "JWT" || header.Algorithm != "ECRECOVER" {
+	if header.Type != "JWT" || header.Algorithm == "" {
 		return claims, fmt.Errorf("Unsupported JWT type")
 	}
+	if _, err := GetSignatureAlgorithm(header.Algorithm); err != nil {
+		return claims, err
+	}
+
+	// keys which can not be recovered from the signature are attached to the header
+	var pubkey []byte
+	if header.Pubkey != "" {
+		pubkey, err = hex.DecodeString(header.Pubkey)
+		if err != nil {
+			return claims, err
+		}
+	}
 
 	payloadBytes, err := base64.RawURLEncoding.DecodeString(split[1])
 	if err != nil {
@@ -184,7 +152,7 @@ func ValidateJWT(jwt string) (JwtClaims, error) {
 		return claims, err
 	}
 
-	err = VerifySignatureFromBytes([]byte(split[0]+"."+split[1]), signatureBytes, claims.Issuer)
+	err = VerifySignatureWithKey([]byte(split[0]+"."+split[1]), signatureBytes, header.Algorithm, pubkey, claims.Issuer)
 	if err != nil {
 		return claims, err
 	}
@@ -197,15 +165,19 @@ func ValidateJWT(jwt string) (JwtClaims, error) {
 type JwtHeader struct {
 	Algorithm string `json:"alg"`
 	Type      string `json:"typ"`
+	Pubkey    string `json:"pub,omitempty"` // hex encoded public key for non-recoverable algorithms
 }
 
 // JwtClaims is jwt payload type
 type JwtClaims struct {
-	Issuer         string `json:"iss"` // 発行者
-	Subject        string `json:"sub"` // 用途
-	Audience       string `json:"aud"` // 想定利用者
-	ExpirationTime string `json:"exp"` // 失効時刻
-	IssuedAt       string `json:"iat"` // 発行時刻
-	JWTID          string `json:"jti"` // JWT ID
-	Tag            string `json:"tag"` // タグ
+	Issuer         string `json:"iss"`           // 発行者
+	Subject        string `json:"sub"`           // 用途
+	Audience       string `json:"aud"`           // 想定利用者
+	ExpirationTime string `json:"exp"`           // 失効時刻
+	IssuedAt       string `json:"iat"`           // 発行時刻
+	JWTID          string `json:"jti"`           // JWT ID
+	Tag            string `json:"tag"`           // タグ
+	Scope          string `json:"scp,omitempty"` // 委任された権限 (空白区切り)
+	Subkey         string `json:"sbk,omitempty"` // 委任された鍵
+	Principal      string `json:"prn,omitempty"` // サブキーで署名したときの本人
 }